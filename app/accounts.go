@@ -0,0 +1,633 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Ошибки аккаунтов
+var (
+	ErrAccountExists      = errors.New("username is already taken")
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrSessionHasAccount  = errors.New("session already belongs to an account")
+)
+
+// Account - зарегистрированный пользователь. Его альбомы живут в домашней сессии SessionID,
+// а вход привязывает браузер к ней как устройство. Аккаунт входит либо по паролю,
+// либо через OIDC провайдера (Issuer и Subject); у второго Username - только отображаемое имя.
+type Account struct {
+	ID           string    `json:"id"`
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"password_hash,omitempty"` // argon2id в формате PHC
+	Issuer       string    `json:"issuer,omitempty"`
+	Subject      string    `json:"subject,omitempty"`
+	SessionID    string    `json:"session_id"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// accountStore хранит аккаунты в StatePath/AccountsStateFile
+type accountStore struct {
+	mu       sync.Mutex
+	loaded   bool
+	accounts []*Account
+}
+
+var accounts = &accountStore{}
+
+// load лениво читает аккаунты с диска (вызывается под мьютексом)
+func (s *accountStore) load() error {
+	if s.loaded {
+		return nil
+	}
+	if err := loadState(AccountsStateFile, &s.accounts); err != nil {
+		return err
+	}
+	s.loaded = true
+	return nil
+}
+
+// save записывает аккаунты на диск (вызывается под мьютексом)
+func (s *accountStore) save() error {
+	return saveState(AccountsStateFile, s.accounts)
+}
+
+// Create регистрирует аккаунт; ID и время создания заполняются здесь.
+// Имена уникальны среди аккаунтов с паролем, субъекты - среди аккаунтов провайдера.
+func (s *accountStore) Create(params Account) (*Account, error) {
+	id, err := RandomToken(8)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	for _, account := range s.accounts {
+		if params.PasswordHash != "" && account.PasswordHash != "" && account.Username == params.Username {
+			return nil, ErrAccountExists
+		}
+		if params.Subject != "" && account.Issuer == params.Issuer && account.Subject == params.Subject {
+			return nil, ErrAccountExists
+		}
+		if account.SessionID == params.SessionID {
+			return nil, ErrSessionHasAccount
+		}
+	}
+
+	account := &params
+	account.ID = id
+	account.CreatedAt = time.Now()
+	s.accounts = append(s.accounts, account)
+	if err := s.save(); err != nil {
+		return nil, err
+	}
+	found := *account
+	return &found, nil
+}
+
+// find возвращает копию первого аккаунта, подходящего под match
+func (s *accountStore) find(match func(*Account) bool) (*Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	for _, account := range s.accounts {
+		if match(account) {
+			found := *account
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+// FindByUsername ищет аккаунт с паролем по имени; nil, если такого нет
+func (s *accountStore) FindByUsername(username string) (*Account, error) {
+	return s.find(func(a *Account) bool { return a.PasswordHash != "" && a.Username == username })
+}
+
+// FindBySubject ищет аккаунт по субъекту OIDC провайдера; nil, если такого нет
+func (s *accountStore) FindBySubject(issuer, subject string) (*Account, error) {
+	return s.find(func(a *Account) bool { return a.Issuer == issuer && a.Subject == subject })
+}
+
+// FindBySession ищет аккаунт, которому принадлежит домашняя сессия; nil, если такого нет
+func (s *accountStore) FindBySession(sessionID string) (*Account, error) {
+	return s.find(func(a *Account) bool { return a.SessionID == sessionID })
+}
+
+// DeleteSession удаляет аккаунт вместе с его домашней сессией
+func (s *accountStore) DeleteSession(sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	for i, account := range s.accounts {
+		if account.SessionID == sessionID {
+			s.accounts = append(s.accounts[:i], s.accounts[i+1:]...)
+			return s.save()
+		}
+	}
+	return nil
+}
+
+// argon2Slots ограничивает число одновременных хеширований, чтобы поток регистраций
+// и входов не занял всю память сервера
+var argon2Slots = make(chan struct{}, Argon2MaxConcurrent)
+
+// argon2Key вычисляет argon2id, дожидаясь свободного слота
+func argon2Key(password, salt []byte, time, memory uint32, threads uint8, keyLen uint32) []byte {
+	argon2Slots <- struct{}{}
+	defer func() { <-argon2Slots }()
+	return argon2.IDKey(password, salt, time, memory, threads, keyLen)
+}
+
+// hashPassword хеширует пароль argon2id со случайной солью
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, Argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2Key([]byte(password), salt, Argon2Time, Argon2Memory, Argon2Threads, Argon2KeyLen)
+
+	encoding := base64.RawStdEncoding
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+		Argon2Memory, Argon2Time, Argon2Threads, encoding.EncodeToString(salt), encoding.EncodeToString(key)), nil
+}
+
+// verifyPassword сверяет пароль с хешем; параметры берутся из самого хеша,
+// поэтому смена Argon2* не ломает уже сохраненные пароли
+func verifyPassword(password, encoded string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false
+	}
+
+	var version int
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false
+	}
+
+	encoding := base64.RawStdEncoding
+	salt, err := encoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	key, err := encoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return false
+	}
+
+	actual := argon2Key([]byte(password), salt, iterations, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(actual, key) == 1
+}
+
+// dummyPasswordHash нужен, чтобы вход с несуществующим именем занимал столько же времени,
+// сколько и с неверным паролем, и по времени ответа нельзя было перебирать имена
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, err := hashPassword("ripx-dummy-password")
+	if err != nil {
+		logger.Error("Failed to hash dummy password: " + err.Error())
+	}
+	return hash
+})
+
+// normalizeUsername приводит имя к нижнему регистру и проверяет допустимые символы
+func normalizeUsername(username string) (string, error) {
+	username = strings.ToLower(strings.TrimSpace(username))
+	if len(username) < MinUsernameLen || len(username) > MaxUsernameLen {
+		return "", fmt.Errorf("username must be %d to %d characters", MinUsernameLen, MaxUsernameLen)
+	}
+	for _, c := range username {
+		isAlnum := (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')
+		if !isAlnum && c != '-' && c != '_' && c != '.' {
+			return "", errors.New("username may contain only letters, digits, '-', '_' and '.'")
+		}
+	}
+	return username, nil
+}
+
+// validatePassword проверяет длину пароля
+func validatePassword(password string) error {
+	n := utf8.RuneCountInString(password)
+	if n < MinPasswordLen || len(password) > MaxPasswordLen {
+		return fmt.Errorf("password must be %d to %d characters", MinPasswordLen, MaxPasswordLen)
+	}
+	return nil
+}
+
+// mergeSession переносит альбомы, устройства и токены сессии from в домашнюю сессию
+// аккаунта into. Ссылки на альбомы старой сессии перенаправляются на новые.
+func mergeSession(from, into string) (int, error) {
+	if from == into {
+		return 0, nil
+	}
+	account, err := accounts.FindBySession(from)
+	if err != nil {
+		return 0, err
+	}
+	if account != nil {
+		return 0, ErrSessionHasAccount
+	}
+
+	// Сначала закрепляем старую сессию, чтобы в нее больше ничего не загрузили по cookie
+	if err := sessions.Merge(from, into); err != nil {
+		return 0, err
+	}
+
+	moved, err := moveAlbums(from, into)
+	if err != nil {
+		return moved, err
+	}
+	if err := tokens.MoveSession(from, into); err != nil {
+		return moved, err
+	}
+
+	logger.Info(fmt.Sprintf("Session %s merged into %s (%d albums)", from, into, moved))
+	return moved, nil
+}
+
+// moveAlbums переносит директории альбомов между сессиями. ID альбомов случайны,
+// поэтому совпадение маловероятно, но на этот случай альбом получает новый ID.
+func moveAlbums(from, into string) (int, error) {
+	entries, err := os.ReadDir(userPath(from))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := EnsureDir(userPath(into)); err != nil {
+		return 0, err
+	}
+
+	albumMetaMutex.Lock()
+	defer albumMetaMutex.Unlock()
+
+	moved := 0
+	for _, entry := range entries {
+		if !entry.IsDir() || isHiddenName(entry.Name()) {
+			continue
+		}
+
+		albumID, targetID := entry.Name(), entry.Name()
+		if _, err := os.Stat(albumPath(into, targetID)); err == nil {
+			targetID = RandomID()
+		}
+		if err := os.Rename(albumPath(from, albumID), albumPath(into, targetID)); err != nil {
+			return moved, err
+		}
+		if err := moderation.MoveAlbum(from, albumID, into, targetID); err != nil {
+			logger.Error(fmt.Sprintf("moveAlbums: failed to move reports of %s: %v", albumID, err))
+		}
+		moved++
+	}
+
+	os.Remove(userPath(from))
+	return moved, nil
+}
+
+// newAccountSession выбирает домашнюю сессию нового аккаунта: текущую сессию браузера
+// вместе с ее альбомами, а если ее нет, она уже принадлежит аккаунту или браузер
+// не доказал владение ею (знает только ID из ссылки) - новую
+func newAccountSession(r *http.Request) (*Caller, error) {
+	caller := &Caller{}
+	caller.SessionID, caller.DeviceID = resolveSession(r)
+	if caller.SessionID != "" && provesOwnership(r, caller) {
+		account, err := accounts.FindBySession(caller.SessionID)
+		if err != nil {
+			return nil, err
+		}
+		if account == nil {
+			return caller, nil
+		}
+	}
+
+	sessionID, err := createSession()
+	if err != nil {
+		return nil, err
+	}
+	return &Caller{SessionID: sessionID}, nil
+}
+
+// activateAccount закрепляет домашнюю сессию за только что созданным аккаунтом
+// и привязывает к ней браузер: сессия аккаунта доступна только с устройств
+func activateAccount(w http.ResponseWriter, r *http.Request, caller *Caller, account *Account) error {
+	if err := sessions.SetAccount(caller.SessionID, account.ID); err != nil {
+		return err
+	}
+	if err := claimSession(w, r, caller); err != nil {
+		return err
+	}
+	setSessionCookie(w, caller.SessionID)
+	return nil
+}
+
+// signIn привязывает браузер к домашней сессии существующего аккаунта.
+// С merge альбомы текущей сессии браузера переносятся в аккаунт, если браузер
+// доказал владение ею; иначе возвращается ErrOwnerNotProven.
+func signIn(w http.ResponseWriter, r *http.Request, account *Account, merge bool) (int, error) {
+	currentID, deviceID := resolveSession(r)
+	merged := 0
+	if merge && currentID != "" && currentID != account.SessionID {
+		if !provesOwnership(r, &Caller{SessionID: currentID, DeviceID: deviceID}) {
+			return 0, ErrOwnerNotProven
+		}
+		var err error
+		if merged, err = mergeSession(currentID, account.SessionID); err != nil {
+			return 0, err
+		}
+	}
+
+	// Устройство, перенесенное вместе с сессией, уже привязано к аккаунту
+	caller := &Caller{SessionID: account.SessionID}
+	if merge || currentID == account.SessionID {
+		caller.DeviceID = deviceID
+	}
+	if err := claimSession(w, r, caller); err != nil {
+		return merged, err
+	}
+	setSessionCookie(w, account.SessionID)
+	return merged, nil
+}
+
+// redirectMergedSession перенаправляет запрос к объединенной сессии на сессию аккаунта
+func redirectMergedSession(w http.ResponseWriter, r *http.Request, sessionID string) bool {
+	if !IsValidID(sessionID) {
+		return false
+	}
+	if _, err := os.Stat(userPath(sessionID)); err == nil {
+		return false
+	}
+	into := sessions.MergedInto(sessionID)
+	if into == "" {
+		return false
+	}
+
+	target := *r.URL
+	target.Path = strings.Replace(r.URL.Path, "/"+sessionID+"/", "/"+into+"/", 1)
+	target.RawPath = ""
+	http.Redirect(w, r, target.String(), http.StatusPermanentRedirect)
+	return true
+}
+
+// AccountResource - представление аккаунта в API (без хеша пароля)
+type AccountResource struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	SessionID string    `json:"session_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func accountResource(account *Account) AccountResource {
+	return AccountResource{
+		ID:        account.ID,
+		Username:  account.Username,
+		SessionID: account.SessionID,
+		CreatedAt: account.CreatedAt,
+	}
+}
+
+// accountCredentials - имя и пароль для регистрации и входа
+type accountCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Merge    bool   `json:"merge"` // при входе перенести альбомы текущей сессии в аккаунт
+}
+
+// apiAccountsEnabled отвечает ошибкой, если регистрация по паролю выключена на сервере
+func apiAccountsEnabled(w http.ResponseWriter) bool {
+	if !AccountsEnabled {
+		ErrorResponse(w, http.StatusNotFound, ErrCodeAccountsDisabled, "accounts are disabled on this server")
+		return false
+	}
+	return true
+}
+
+// accountsAvailable сообщает, можно ли на сервере войти в аккаунт хоть каким-то способом
+func accountsAvailable() bool {
+	return AccountsEnabled || oidcEnabled() || proxyAuthEnabled()
+}
+
+// apiAccountsAvailable отвечает ошибкой, если на сервере нет ни паролей, ни OIDC
+func apiAccountsAvailable(w http.ResponseWriter) bool {
+	if !accountsAvailable() {
+		ErrorResponse(w, http.StatusNotFound, ErrCodeAccountsDisabled, "accounts are disabled on this server")
+		return false
+	}
+	return true
+}
+
+// apiGetAccount возвращает аккаунт, которому принадлежит сессия клиента
+func apiGetAccount(w http.ResponseWriter, r *http.Request) {
+	if !apiAccountsAvailable(w) {
+		return
+	}
+	caller, ok := apiSessionOwner(w, r)
+	if !ok {
+		return
+	}
+
+	account, err := accounts.FindBySession(caller.SessionID)
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+	if account == nil {
+		ErrorResponse(w, http.StatusNotFound, ErrCodeAccountNotFound, "session does not belong to an account")
+		return
+	}
+	apiData(w, http.StatusOK, accountResource(account))
+}
+
+// apiSignup регистрирует аккаунт. Текущая сессия браузера становится домашней сессией
+// аккаунта вместе со всеми альбомами; если сессии нет, создается новая.
+func apiSignup(w http.ResponseWriter, r *http.Request) {
+	if !apiAccountsEnabled(w) {
+		return
+	}
+
+	var req accountCredentials
+	if !apiDecodeJSON(w, r, &req) {
+		return
+	}
+	username, err := normalizeUsername(req.Username)
+	if err == nil {
+		err = validatePassword(req.Password)
+	}
+	if err != nil {
+		ErrorResponse(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		return
+	}
+
+	caller, err := newAccountSession(r)
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+	account, err := accounts.Create(Account{Username: username, PasswordHash: hash, SessionID: caller.SessionID})
+	switch {
+	case errors.Is(err, ErrAccountExists), errors.Is(err, ErrSessionHasAccount):
+		ErrorResponse(w, http.StatusConflict, ErrCodeAccountExists, err.Error())
+		return
+	case err != nil:
+		apiInternalError(w, err)
+		return
+	}
+
+	if err := activateAccount(w, r, caller, account); err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	logger.Info(fmt.Sprintf("Account %s registered with session %s", account.Username, account.SessionID))
+	apiData(w, http.StatusCreated, accountResource(account))
+}
+
+// apiLogin проверяет пароль и привязывает браузер к домашней сессии аккаунта.
+// С merge=true альбомы текущей сессии браузера переносятся в аккаунт.
+func apiLogin(w http.ResponseWriter, r *http.Request) {
+	if !apiAccountsEnabled(w) {
+		return
+	}
+
+	var req accountCredentials
+	if !apiDecodeJSON(w, r, &req) {
+		return
+	}
+
+	username, _ := normalizeUsername(req.Username)
+	account, err := accounts.FindByUsername(username)
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+	if account == nil {
+		verifyPassword(req.Password, dummyPasswordHash())
+		ErrorResponse(w, http.StatusUnauthorized, ErrCodeInvalidCredentials, ErrInvalidCredentials.Error())
+		return
+	}
+	if !verifyPassword(req.Password, account.PasswordHash) {
+		logger.Info(fmt.Sprintf("Failed login for account %s", account.Username))
+		ErrorResponse(w, http.StatusUnauthorized, ErrCodeInvalidCredentials, ErrInvalidCredentials.Error())
+		return
+	}
+
+	merged, err := signIn(w, r, account, req.Merge)
+	if errors.Is(err, ErrSessionHasAccount) {
+		ErrorResponse(w, http.StatusConflict, ErrCodeAccountExists,
+			"current session belongs to another account; log out first")
+		return
+	}
+	if errors.Is(err, ErrOwnerNotProven) {
+		ErrorResponse(w, http.StatusForbidden, ErrCodeForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	apiData(w, http.StatusOK, map[string]interface{}{
+		"account":       accountResource(account),
+		"merged_albums": merged,
+	})
+}
+
+// apiLogout отвязывает текущий браузер от сессии аккаунта
+func apiLogout(w http.ResponseWriter, r *http.Request) {
+	caller, ok := apiSessionOwner(w, r)
+	if !ok {
+		return
+	}
+
+	if caller.DeviceID != "" {
+		if _, err := sessions.RevokeDevice(caller.SessionID, caller.DeviceID); err != nil {
+			apiInternalError(w, err)
+			return
+		}
+	}
+	clearDeviceCookie(w)
+	clearSessionCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sessionMergeRequest - код, подтверждающий владение сессией, которую нужно перенести в аккаунт
+type sessionMergeRequest struct {
+	Code string `json:"code"`
+}
+
+// apiMergeSession переносит в аккаунт альбомы другой сессии. ID сессии владения не доказывает,
+// поэтому нужен ее код привязки или восстановления; текущую сессию браузера переносит вход с merge.
+func apiMergeSession(w http.ResponseWriter, r *http.Request) {
+	if !apiAccountsAvailable(w) {
+		return
+	}
+	caller, ok := apiSessionOwner(w, r)
+	if !ok {
+		return
+	}
+
+	account, err := accounts.FindBySession(caller.SessionID)
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+	if account == nil {
+		ErrorResponse(w, http.StatusNotFound, ErrCodeAccountNotFound, "session does not belong to an account")
+		return
+	}
+
+	var req sessionMergeRequest
+	if !apiDecodeJSON(w, r, &req) {
+		return
+	}
+	sourceID, err := sessions.SessionByCode(req.Code)
+	if errors.Is(err, ErrInvalidCode) {
+		ErrorResponse(w, http.StatusBadRequest, ErrCodeInvalidCode, err.Error())
+		return
+	}
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	merged, err := mergeSession(sourceID, account.SessionID)
+	if errors.Is(err, ErrSessionHasAccount) {
+		ErrorResponse(w, http.StatusConflict, ErrCodeAccountExists, err.Error())
+		return
+	}
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+	apiData(w, http.StatusOK, map[string]int{"merged_albums": merged})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testAccountSeq делает имена аккаунтов в тестах уникальными
+var testAccountSeq atomic.Int64
+
+// signupTestAccount регистрирует аккаунт в браузере b
+func signupTestAccount(t *testing.T, b *testBrowser) string {
+	t.Helper()
+	username := fmt.Sprintf("user%d", testAccountSeq.Add(1))
+	status, body := b.do("POST", "/api/v1/account", map[string]string{"username": username, "password": "correct horse"})
+	if status != http.StatusCreated {
+		t.Fatalf("signup: %d %s", status, body)
+	}
+	return username
+}
+
+func TestMergeSessionRequiresCode(t *testing.T) {
+	// Анонимная сессия с одним альбомом и кодом привязки
+	other := newTestBrowser(t)
+	status, body := other.do("POST", "/api/v1/sessions", nil)
+	if status != http.StatusCreated {
+		t.Fatalf("create session: %d %s", status, body)
+	}
+	otherID := other.sessionID()
+	if status, body := other.do("POST", "/api/v1/sessions/"+otherID+"/albums", nil); status != http.StatusCreated {
+		t.Fatalf("create album: %d %s", status, body)
+	}
+	status, body = other.do("POST", "/api/v1/pairing-codes", nil)
+	if status != http.StatusCreated {
+		t.Fatalf("pairing code: %d %s", status, body)
+	}
+	var pairing struct {
+		Data struct {
+			Code string `json:"code"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &pairing); err != nil || pairing.Data.Code == "" {
+		t.Fatalf("pairing code response %s: %v", body, err)
+	}
+
+	b := newTestBrowser(t)
+	signupTestAccount(t, b)
+
+	// Одного ID сессии недостаточно
+	if status, body := b.do("POST", "/api/v1/account/sessions", map[string]string{"session_id": otherID}); status != http.StatusBadRequest {
+		t.Errorf("merge by session_id: %d %s, want 400", status, body)
+	}
+	if status, body := b.do("POST", "/api/v1/account/sessions", map[string]string{"code": "WRONGCODE"}); status != http.StatusBadRequest || !strings.Contains(string(body), ErrCodeInvalidCode) {
+		t.Errorf("merge with a wrong code: %d %s, want 400 invalid_code", status, body)
+	}
+
+	status, body = b.do("POST", "/api/v1/account/sessions", map[string]string{"code": pairing.Data.Code})
+	if status != http.StatusOK || !strings.Contains(string(body), `"merged_albums":1`) {
+		t.Fatalf("merge with pairing code: %d %s", status, body)
+	}
+
+	// Код привязки одноразовый
+	if status, _ := b.do("POST", "/api/v1/account/sessions", map[string]string{"code": pairing.Data.Code}); status != http.StatusBadRequest {
+		t.Errorf("second merge with the same code: %d, want 400", status)
+	}
+}
+
+func TestArgon2Concurrency(t *testing.T) {
+	// Заполняем все слоты: следующее хеширование должно ждать освобождения
+	for range Argon2MaxConcurrent {
+		argon2Slots <- struct{}{}
+	}
+	var wg sync.WaitGroup
+	var done atomic.Bool
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := hashPassword("correct horse"); err != nil {
+			t.Error(err)
+		}
+		done.Store(true)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if done.Load() {
+		t.Fatal("hashPassword ran with every argon2 slot taken")
+	}
+	for range Argon2MaxConcurrent {
+		<-argon2Slots
+	}
+	wg.Wait()
+	if !done.Load() {
+		t.Fatal("hashPassword did not finish after slots were released")
+	}
+}
+
+func TestSignupRequiresOwnership(t *testing.T) {
+	accountSession := func(body []byte) string {
+		var resp struct {
+			Data struct {
+				SessionID string `json:"session_id"`
+			} `json:"data"`
+		}
+		json.Unmarshal(body, &resp)
+		return resp.Data.SessionID
+	}
+
+	victim := newTestBrowser(t)
+	if status, body := victim.do("POST", "/api/v1/sessions", nil); status != http.StatusCreated {
+		t.Fatalf("create session: %d %s", status, body)
+	}
+	victimID := victim.sessionID()
+	albumID, err := createAlbum(victimID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Регистрация с чужим ID сессии создает новую сессию, а не забирает чужую
+	intruder := newTestBrowser(t)
+	intruder.useSessionID(victimID)
+	status, body := intruder.do("POST", "/api/v1/account", map[string]string{"username": fmt.Sprintf("user%d", testAccountSeq.Add(1)), "password": "correct horse"})
+	if status != http.StatusCreated {
+		t.Fatalf("signup: %d %s", status, body)
+	}
+	if got := accountSession(body); got == "" || got == victimID {
+		t.Errorf("signup with a foreign session ID got home session %q", got)
+	}
+
+	// Вход с merge из браузера, который знает только ID, отклоняется
+	username := signupTestAccount(t, newTestBrowser(t))
+	thief := newTestBrowser(t)
+	thief.useSessionID(victimID)
+	status, body = thief.do("POST", "/api/v1/account/login", map[string]interface{}{"username": username, "password": "correct horse", "merge": true})
+	if status != http.StatusForbidden {
+		t.Errorf("login with merge of a foreign session: %d %s, want 403", status, body)
+	}
+
+	if sessions.IsClaimed(victimID) || sessions.MergedInto(victimID) != "" {
+		t.Fatal("victim session was taken over")
+	}
+	if !albumExists(victimID, albumID) {
+		t.Fatal("victim album was moved")
+	}
+
+	// Создатель сессии регистрируется вместе со своими альбомами
+	status, body = victim.do("POST", "/api/v1/account", map[string]string{"username": fmt.Sprintf("user%d", testAccountSeq.Add(1)), "password": "correct horse"})
+	if status != http.StatusCreated || accountSession(body) != victimID {
+		t.Errorf("signup by the session creator: %d %s, want home session %s", status, body, victimID)
+	}
+}
@@ -0,0 +1,751 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// adminEnabled сообщает, заданы ли учетные данные администратора
+func adminEnabled() bool {
+	return AdminUser != "" && AdminPassword != ""
+}
+
+// isAdmin проверяет Basic auth администратора. Сравниваются хеши, чтобы время
+// сравнения не зависело ни от содержимого, ни от длины строк.
+func isAdmin(r *http.Request) bool {
+	if !adminEnabled() {
+		return false
+	}
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	userHash, wantUser := sha256.Sum256([]byte(user)), sha256.Sum256([]byte(AdminUser))
+	passwordHash, wantPassword := sha256.Sum256([]byte(password)), sha256.Sum256([]byte(AdminPassword))
+	userOK := subtle.ConstantTimeCompare(userHash[:], wantUser[:])
+	passwordOK := subtle.ConstantTimeCompare(passwordHash[:], wantPassword[:])
+	return userOK&passwordOK == 1
+}
+
+// apiRequireAdmin пропускает только администратора; без пароля администрирование выглядит как несуществующий роут
+func apiRequireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if !adminEnabled() {
+		apiNotFound(w, r)
+		return false
+	}
+	if !isAdmin(r) {
+		if _, _, ok := r.BasicAuth(); ok {
+			logger.Info(fmt.Sprintf("Failed admin login from %s", r.RemoteAddr))
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="`+AdminRealm+`", charset="UTF-8"`)
+		ErrorResponse(w, http.StatusUnauthorized, ErrCodeUnauthorized, "admin credentials required")
+		return false
+	}
+	return true
+}
+
+// requireAdmin - вариант apiRequireAdmin для HTML страниц админки
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if !adminEnabled() {
+		http.NotFound(w, r)
+		return false
+	}
+	if !isAdmin(r) {
+		if _, _, ok := r.BasicAuth(); ok {
+			logger.Info(fmt.Sprintf("Failed admin login from %s", r.RemoteAddr))
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="`+AdminRealm+`", charset="UTF-8"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	return true
+}
+
+// byteSize - размер в байтах, который в шаблонах выводится в читаемом виде
+type byteSize int64
+
+func (b byteSize) String() string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", int64(b))
+	}
+	div, exp := int64(unit), 0
+	for n := int64(b) / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+// adminUpload - изображение в списке последних загрузок
+type adminUpload struct {
+	SessionID    string
+	AlbumID      string
+	Filename     string
+	OriginalName string
+	Size         byteSize
+	UploadedAt   time.Time
+	Scan         *ScanResult
+}
+
+// adminAlbum - альбом с размером и временем последнего изменения
+type adminAlbum struct {
+	AlbumInfo
+	Size      byteSize
+	UpdatedAt time.Time
+}
+
+// adminSession - сессия в списке админки
+type adminSession struct {
+	ID        string
+	Account   string
+	Albums    []adminAlbum
+	Images    int
+	Size      byteSize
+	UpdatedAt time.Time
+}
+
+// scanSession собирает размеры альбомов сессии и ее изображения
+func scanSession(sessionID string) (*adminSession, []adminUpload, error) {
+	albums, err := getUserAlbums(sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	session := &adminSession{ID: sessionID}
+	if account, err := accounts.FindBySession(sessionID); err == nil && account != nil {
+		session.Account = account.Username
+	}
+
+	var uploads []adminUpload
+	for _, info := range albums {
+		album := adminAlbum{AlbumInfo: info, UpdatedAt: info.CreatedAt}
+		meta, _ := loadAlbumMeta(sessionID, info.ID)
+
+		images, _ := getUserImages(sessionID, info.ID)
+		for _, img := range images {
+			upload := adminUpload{SessionID: sessionID, AlbumID: info.ID, Filename: img.Filename, Size: byteSize(img.Size)}
+			if meta != nil {
+				upload.OriginalName = meta.Images[img.Filename].OriginalName
+				upload.UploadedAt = meta.Images[img.Filename].UploadedAt
+				upload.Scan = meta.Images[img.Filename].Scan
+			}
+			if upload.UploadedAt.IsZero() {
+				if stat, err := os.Stat(img.Path); err == nil {
+					upload.UploadedAt = stat.ModTime()
+				}
+			}
+			if upload.UploadedAt.After(album.UpdatedAt) {
+				album.UpdatedAt = upload.UploadedAt
+			}
+			album.Size += upload.Size
+			uploads = append(uploads, upload)
+		}
+
+		session.Albums = append(session.Albums, album)
+		session.Images += album.ImageCount
+		session.Size += album.Size
+		if album.UpdatedAt.After(session.UpdatedAt) {
+			session.UpdatedAt = album.UpdatedAt
+		}
+	}
+	return session, uploads, nil
+}
+
+// storageReport - сводка по хранилищу для главной страницы админки
+type storageReport struct {
+	Sessions  []*adminSession
+	Recent    []adminUpload
+	Images    int
+	Size      byteSize
+	StateSize byteSize
+	ScannedAt time.Time
+}
+
+// storageReportCache хранит последний обход хранилища: он читает метаданные всех альбомов,
+// и без кэша каждое обновление админки нагружало бы диск
+var (
+	storageReportCache      *storageReport
+	storageReportCacheMutex sync.Mutex
+)
+
+// cachedStorageReport возвращает обход не старше AdminScanCacheTTL. Мьютекс держится на время
+// обхода, чтобы одновременные запросы не запускали его параллельно.
+func cachedStorageReport() (*storageReport, error) {
+	storageReportCacheMutex.Lock()
+	defer storageReportCacheMutex.Unlock()
+	if storageReportCache != nil && time.Since(storageReportCache.ScannedAt) < AdminScanCacheTTL {
+		return storageReportCache, nil
+	}
+	report, err := scanStorage()
+	if err != nil {
+		return nil, err
+	}
+	storageReportCache = report
+	return report, nil
+}
+
+// invalidateStorageReport сбрасывает кэш после изменений, сделанных из админки
+func invalidateStorageReport() {
+	storageReportCacheMutex.Lock()
+	storageReportCache = nil
+	storageReportCacheMutex.Unlock()
+}
+
+// scanStorage обходит все сессии в DataPath. Служебная директория StatePath пропускается
+// и учитывается отдельно.
+func scanStorage() (*storageReport, error) {
+	entries, err := os.ReadDir(DataPath)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &storageReport{ScannedAt: time.Now()}
+	for _, entry := range entries {
+		if !entry.IsDir() || isHiddenName(entry.Name()) || !IsValidID(entry.Name()) {
+			continue
+		}
+		session, uploads, err := scanSession(entry.Name())
+		if err != nil {
+			logger.Error(fmt.Sprintf("scanStorage: %s: %v", entry.Name(), err))
+			continue
+		}
+		report.Sessions = append(report.Sessions, session)
+		report.Recent = append(report.Recent, uploads...)
+		report.Images += session.Images
+		report.Size += session.Size
+	}
+
+	filepath.WalkDir(StatePath, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				report.StateSize += byteSize(info.Size())
+			}
+		}
+		return nil
+	})
+
+	sort.Slice(report.Sessions, func(i, j int) bool {
+		return report.Sessions[i].UpdatedAt.After(report.Sessions[j].UpdatedAt)
+	})
+	sort.Slice(report.Recent, func(i, j int) bool {
+		return report.Recent[i].UploadedAt.After(report.Recent[j].UploadedAt)
+	})
+	if len(report.Recent) > AdminRecentUploads {
+		report.Recent = report.Recent[:AdminRecentUploads]
+	}
+	return report, nil
+}
+
+// adminPage - данные шаблона admin.html; заполнено ровно одно из представлений
+type adminPage struct {
+	CSRFToken       string
+	TotalImageCount int64
+	Dashboard       *adminDashboard
+	Session         *adminSession
+	Album           *adminAlbumView
+	Moderation      *adminModerationView
+	Blocklist       *adminBlocklistView
+	Quota           *quotaView
+}
+
+// adminDashboard - главная страница админки
+type adminDashboard struct {
+	Report        *storageReport
+	Sessions      []*adminSession // текущая страница списка
+	Pagination    Pagination
+	PrevPage      int // 0, если страницы нет
+	NextPage      int
+	Cleanup       CleanupStatus
+	Disk          *DiskStatus      // nil, если границы свободного места не заданы
+	Retention     *RetentionStatus // nil при политике хранения age
+	Reports       int              // элементов в очереди модерации
+	BlockedHashes int
+	RetentionDays int
+	InviteOnly    bool
+	RequireLogin  bool
+}
+
+// adminAlbumView - страница альбома в админке
+type adminAlbumView struct {
+	SessionID string
+	Album     adminAlbum
+	Images    []adminUpload
+}
+
+// adminReport - элемент очереди модерации; Missing - файл уже удален владельцем или очисткой
+type adminReport struct {
+	ModerationItem
+	Missing bool
+}
+
+// adminModerationView - страница очереди модерации
+type adminModerationView struct {
+	Items []adminReport
+}
+
+// adminBlocklistView - страница блоклиста
+type adminBlocklistView struct {
+	Entries []BlockEntry
+	File    string
+	Rescan  RescanStatus
+	Audit   []AuditEvent
+}
+
+// registerAdminRoutes регистрирует страницы админки; все они требуют Basic auth администратора
+func registerAdminRoutes(mux *http.ServeMux) {
+	handleRoute(mux, "GET /admin", adminDashboardHandler)
+	handleRoute(mux, "GET /admin/sessions/{session}", adminSessionHandler)
+	handleRoute(mux, "POST /admin/sessions/{session}/quota", adminQuotaHandler)
+	handleRoute(mux, "GET /admin/sessions/{session}/albums/{album}", adminAlbumHandler)
+	handleRoute(mux, "POST /admin/sessions/{session}/albums/{album}/delete", adminDeleteAlbumHandler)
+	handleRoute(mux, "POST /admin/sessions/{session}/albums/{album}/pin", adminPinAlbumHandler)
+	handleRoute(mux, "GET /admin/sessions/{session}/albums/{album}/images/{filename}", adminImageHandler)
+	handleRoute(mux, "GET /admin/reports", adminReportsHandler)
+	handleRoute(mux, "POST /admin/reports/{id}/{action}", adminReportActionHandler)
+	handleRoute(mux, "GET /admin/blocklist", adminBlocklistHandler)
+	handleRoute(mux, "POST /admin/blocklist", adminAddBlockHandler)
+	handleRoute(mux, "POST /admin/blocklist/{id}/delete", adminRemoveBlockHandler)
+	handleRoute(mux, "POST /admin/blocklist/rescan", adminRescanHandler)
+}
+
+// renderAdminPage отображает страницу админки. Сессию пользователя админка не создает;
+// CSRF токен нужен, только если у браузера уже есть cookie сессии.
+func renderAdminPage(w http.ResponseWriter, r *http.Request, page adminPage) {
+	sessionID, _ := resolveSession(r)
+	page.CSRFToken = csrfToken(sessionID)
+	page.TotalImageCount = TotalImageCount.Load()
+	if err := renderTemplate(w, "admin.html", page); err != nil {
+		logger.Error(fmt.Sprintf("renderAdminPage: %v", err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// adminDashboardHandler показывает сводку: место на диске, очистку, сессии и последние загрузки
+func adminDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	report, err := cachedStorageReport()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error scanning storage: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	total := len(report.Sessions)
+	totalPages := (total + AdminPageSize - 1) / AdminPageSize
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	page = min(max(page, 1), max(totalPages, 1))
+	start := min((page-1)*AdminPageSize, total)
+	end := min(start+AdminPageSize, total)
+
+	queue, err := moderation.List()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error loading moderation queue: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	blocked, err := blocklist.List()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error loading blocklist: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	dashboard := &adminDashboard{
+		Report:   report,
+		Sessions: report.Sessions[start:end],
+		Pagination: Pagination{
+			Page:       page,
+			PerPage:    AdminPageSize,
+			Total:      total,
+			TotalPages: totalPages,
+		},
+		Cleanup:       getCleanupStatus(),
+		Reports:       len(queue),
+		BlockedHashes: len(blocked),
+		RetentionDays: int(CleanupDuration.Hours() / 24),
+		InviteOnly:    InviteOnly,
+		RequireLogin:  RequireLogin,
+	}
+	if diskMonitoringEnabled() {
+		disk := checkDisk()
+		dashboard.Disk = &disk
+	}
+	if RetentionPolicy == RetentionBySize {
+		retention := getRetentionStatus()
+		dashboard.Retention = &retention
+	}
+	if page > 1 {
+		dashboard.PrevPage = page - 1
+	}
+	if page < totalPages {
+		dashboard.NextPage = page + 1
+	}
+	renderAdminPage(w, r, adminPage{Dashboard: dashboard})
+}
+
+// adminSessionHandler показывает альбомы сессии
+func adminSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	sessionPath, err := resolveStoragePath(r.PathValue("session"), "", "")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if info, err := os.Stat(sessionPath); err != nil || !info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	session, _, err := scanSession(r.PathValue("session"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error scanning session: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	limit, override, err := quotas.Limit(session.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error loading quota: %v", err), http.StatusInternalServerError)
+		return
+	}
+	usage, err := measureUsage(session.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error scanning session: %v", err), http.StatusInternalServerError)
+		return
+	}
+	renderAdminPage(w, r, adminPage{Session: session, Quota: &quotaView{Used: usage, Limit: limit, Override: override}})
+}
+
+// adminQuotaHandler задает владельцу индивидуальную квоту или возвращает квоту по умолчанию
+func adminQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	sessionID := r.PathValue("session")
+	if _, err := resolveStoragePath(sessionID, "", ""); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.FormValue("action") == "reset" {
+		if err := quotas.Reset(sessionID); err != nil {
+			http.Error(w, fmt.Sprintf("Error updating quota: %v", err), http.StatusInternalServerError)
+			return
+		}
+		logger.Info(fmt.Sprintf("Quota of %s reset to default by admin from %s", sessionID, r.RemoteAddr))
+		http.Redirect(w, r, "/admin/sessions/"+sessionID, http.StatusSeeOther)
+		return
+	}
+
+	var quota Quota
+	var err error
+	if value := strings.TrimSpace(r.FormValue("bytes")); value != "" {
+		if quota.Bytes, err = parseByteSize(value); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid size: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	for _, field := range []struct {
+		name  string
+		value *int
+	}{{"images", &quota.Images}, {"albums", &quota.Albums}} {
+		value := strings.TrimSpace(r.FormValue(field.name))
+		if value == "" {
+			continue
+		}
+		if *field.value, err = strconv.Atoi(value); err != nil || *field.value < 0 {
+			http.Error(w, fmt.Sprintf("Invalid %s count %q", field.name, value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := quotas.Set(sessionID, quota); err != nil {
+		http.Error(w, fmt.Sprintf("Error updating quota: %v", err), http.StatusInternalServerError)
+		return
+	}
+	logger.Info(fmt.Sprintf("Quota of %s set to %s, %d images, %d albums by admin from %s",
+		sessionID, quota.Bytes, quota.Images, quota.Albums, r.RemoteAddr))
+	http.Redirect(w, r, "/admin/sessions/"+sessionID, http.StatusSeeOther)
+}
+
+// adminAlbumHandler показывает любой альбом, в том числе приватный
+func adminAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	sessionID, albumID := r.PathValue("session"), r.PathValue("album")
+	if _, err := resolveStoragePath(sessionID, albumID, ""); err != nil || !albumExists(sessionID, albumID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	session, uploads, err := scanSession(sessionID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error scanning session: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	view := &adminAlbumView{SessionID: sessionID}
+	for _, album := range session.Albums {
+		if album.ID == albumID {
+			view.Album = album
+		}
+	}
+	for _, upload := range uploads {
+		if upload.AlbumID == albumID {
+			view.Images = append(view.Images, upload)
+		}
+	}
+	renderAdminPage(w, r, adminPage{Album: view})
+}
+
+// adminDeleteAlbumHandler удаляет любой альбом
+func adminDeleteAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	sessionID, albumID := r.PathValue("session"), r.PathValue("album")
+	err := deleteAlbum(sessionID, albumID)
+	if errors.Is(err, ErrAlbumNotFound) || errors.Is(err, ErrInvalidPath) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error deleting album: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	invalidateStorageReport()
+	logger.Info(fmt.Sprintf("Album %s/%s deleted by admin from %s", sessionID, albumID, r.RemoteAddr))
+	http.Redirect(w, r, "/admin/sessions/"+sessionID, http.StatusSeeOther)
+}
+
+// adminPinAlbumHandler закрепляет альбом, чтобы политика хранения size его не удаляла, или снимает закрепление
+func adminPinAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	sessionID, albumID := r.PathValue("session"), r.PathValue("album")
+	if _, err := resolveStoragePath(sessionID, albumID, ""); err != nil || !albumExists(sessionID, albumID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	pinned := r.FormValue("action") != "unpin"
+	if err := updateAlbumMeta(sessionID, albumID, func(meta *AlbumMeta) { meta.Pinned = pinned }); err != nil {
+		http.Error(w, fmt.Sprintf("Error updating album: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	action := "pinned"
+	if !pinned {
+		action = "unpinned"
+	}
+	logger.Info(fmt.Sprintf("Album %s/%s %s by admin from %s", sessionID, albumID, action, r.RemoteAddr))
+	http.Redirect(w, r, "/admin/sessions/"+sessionID+"/albums/"+albumID, http.StatusSeeOther)
+}
+
+// adminImageHandler отдает изображение любого альбома для просмотра в админке
+func adminImageHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	filePath, err := resolveStoragePath(r.PathValue("session"), r.PathValue("album"), r.PathValue("filename"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if info, err := os.Stat(filePath); err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, filePath)
+}
+
+// adminReportsHandler показывает очередь модерации
+func adminReportsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	queue, err := moderation.List()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error loading moderation queue: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	view := &adminModerationView{}
+	for _, item := range queue {
+		report := adminReport{ModerationItem: item, Missing: true}
+		if path, err := resolveStoragePath(item.SessionID, item.AlbumID, item.Filename); err == nil {
+			if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+				report.Missing = false
+			}
+		}
+		view.Items = append(view.Items, report)
+	}
+	renderAdminPage(w, r, adminPage{Moderation: view})
+}
+
+// adminReportActionHandler выполняет решение по жалобе: quarantine скрывает изображение,
+// restore снимает жалобы и возвращает его, delete удаляет файл, block вдобавок заносит
+// его хеши в блоклист, чтобы файл не загрузили снова
+func adminReportActionHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	item, err := moderation.Get(r.PathValue("id"))
+	if errors.Is(err, ErrReportNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error loading moderation queue: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	action := r.PathValue("action")
+	switch action {
+	case "quarantine":
+		err = moderation.Quarantine(item.ID)
+	case "restore":
+		err = moderation.Resolve(item.ID)
+	case "delete", "block":
+		if action == "block" {
+			err = blockStoredImage(item.SessionID, item.AlbumID, item.Filename, "report "+item.ID)
+			if errors.Is(err, os.ErrNotExist) || errors.Is(err, ErrInvalidPath) {
+				http.Error(w, "Image is already gone, nothing to block", http.StatusConflict)
+				return
+			}
+			if err != nil {
+				break
+			}
+		}
+		err = deleteImage(item.SessionID, item.AlbumID, item.Filename)
+		if errors.Is(err, ErrImageNotFound) || errors.Is(err, ErrInvalidPath) {
+			err = nil
+		}
+		if err == nil {
+			invalidateStorageReport()
+			err = moderation.Resolve(item.ID)
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error resolving report: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info(fmt.Sprintf("Report %s for %s/%s/%s: %s by admin from %s",
+		item.ID, item.SessionID, item.AlbumID, item.Filename, action, r.RemoteAddr))
+	http.Redirect(w, r, "/admin/reports", http.StatusSeeOther)
+}
+
+// adminBlocklistHandler показывает блоклист, состояние повторной проверки и журнал аудита
+func adminBlocklistHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	entries, err := blocklist.List()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error loading blocklist: %v", err), http.StatusInternalServerError)
+		return
+	}
+	events, err := recentAudit(AdminAuditEntries)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading audit log: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	renderAdminPage(w, r, adminPage{Blocklist: &adminBlocklistView{
+		Entries: entries,
+		File:    BlocklistFile,
+		Rescan:  getRescanStatus(),
+		Audit:   events,
+	}})
+}
+
+// adminAddBlockHandler добавляет хеш в блоклист
+func adminAddBlockHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	kind, hash, err := parseBlockHash(r.FormValue("hash"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid hash: %v", err), http.StatusBadRequest)
+		return
+	}
+	note := strings.TrimSpace(r.FormValue("note"))
+	if utf8.RuneCountInString(note) > MaxReportDetailsLen {
+		http.Error(w, "Note is too long", http.StatusBadRequest)
+		return
+	}
+
+	entry, err := blocklist.Add(kind, hash, note)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error updating blocklist: %v", err), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(AuditEvent{Event: AuditBlocklistAdd, Detail: fmt.Sprintf("%s entry %s: %s", entry.Kind, entry.ID, entry.Hash)})
+	logger.Info(fmt.Sprintf("Blocklist entry %s added by admin from %s", entry.ID, r.RemoteAddr))
+	http.Redirect(w, r, "/admin/blocklist", http.StatusSeeOther)
+}
+
+// adminRemoveBlockHandler удаляет хеш из блоклиста
+func adminRemoveBlockHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	entry, err := blocklist.Remove(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error updating blocklist: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if entry == nil {
+		http.NotFound(w, r)
+		return
+	}
+	recordAudit(AuditEvent{Event: AuditBlocklistRemove, Detail: fmt.Sprintf("%s entry %s: %s", entry.Kind, entry.ID, entry.Hash)})
+	logger.Info(fmt.Sprintf("Blocklist entry %s removed by admin from %s", entry.ID, r.RemoteAddr))
+	http.Redirect(w, r, "/admin/blocklist", http.StatusSeeOther)
+}
+
+// adminRescanHandler запускает повторную проверку всего загруженного по блоклисту
+func adminRescanHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	if startRescan() {
+		logger.Info(fmt.Sprintf("Blocklist rescan started by admin from %s", r.RemoteAddr))
+	}
+	http.Redirect(w, r, "/admin/blocklist", http.StatusSeeOther)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// useAdmin задает учетные данные администратора на время теста
+func useAdmin(t *testing.T, user, password string) {
+	oldUser, oldPassword := AdminUser, AdminPassword
+	AdminUser, AdminPassword = user, password
+	t.Cleanup(func() { AdminUser, AdminPassword = oldUser, oldPassword })
+}
+
+// adminGet запрашивает страницу админки с Basic auth
+func adminGet(t *testing.T, path, user, password string) int {
+	t.Helper()
+	req, err := http.NewRequest("GET", testServer.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(user, password)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestAdminUserHasNoDefault(t *testing.T) {
+	// Без имени администрирование выключено, даже если пароль задан
+	useAdmin(t, "", "secret")
+	if status := adminGet(t, "/admin", "admin", "secret"); status != http.StatusNotFound {
+		t.Errorf("/admin without RIPX_ADMIN_USER: %d, want 404", status)
+	}
+	if err := checkAuthConfig(); err == nil || !strings.Contains(err.Error(), "RIPX_ADMIN_USER") {
+		t.Errorf("password without user: %v, want an error naming RIPX_ADMIN_USER", err)
+	}
+
+	useAdmin(t, "root", "")
+	if err := checkAuthConfig(); err == nil {
+		t.Error("user without password: no error")
+	}
+
+	useAdmin(t, "root", "secret")
+	if err := checkAuthConfig(); err != nil {
+		t.Errorf("user and password: %v", err)
+	}
+	if status := adminGet(t, "/admin", "admin", "secret"); status != http.StatusUnauthorized {
+		t.Errorf("/admin as the old default user: %d, want 401", status)
+	}
+	if status := adminGet(t, "/admin", "root", "secret"); status != http.StatusOK {
+		t.Errorf("/admin as the configured user: %d, want 200", status)
+	}
+}
+
+func TestStorageReportCache(t *testing.T) {
+	invalidateStorageReport()
+	first, err := cachedStorageReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Новая сессия не видна, пока кэш не устарел или не сброшен
+	newTestClient(t)
+	second, err := cachedStorageReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second != first {
+		t.Error("report was rescanned within AdminScanCacheTTL")
+	}
+
+	invalidateStorageReport()
+	third, err := cachedStorageReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third == first || !third.ScannedAt.After(first.ScannedAt) {
+		t.Error("report was not rescanned after invalidateStorageReport")
+	}
+	if len(third.Sessions) <= len(first.Sessions) {
+		t.Errorf("rescan found %d sessions, cached report %d", len(third.Sessions), len(first.Sessions))
+	}
+}
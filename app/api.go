@@ -0,0 +1,910 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Коды ошибок API
+const (
+	ErrCodeBadRequest       = "bad_request"
+	ErrCodeUnauthorized     = "unauthorized"
+	ErrCodeForbidden        = "forbidden"
+	ErrCodeNotFound         = "not_found"
+	ErrCodeSessionNotFound  = "session_not_found"
+	ErrCodeAlbumNotFound    = "album_not_found"
+	ErrCodeImageNotFound    = "image_not_found"
+	ErrCodeFileTooLarge     = "file_too_large"
+	ErrCodeInvalidImageType = "invalid_image_type"
+	ErrCodeInternal         = "internal_error"
+
+	ErrCodeInvalidToken      = "invalid_token"
+	ErrCodeTokenExpired      = "token_expired"
+	ErrCodeInsufficientScope = "insufficient_scope"
+	ErrCodeTokenNotFound     = "token_not_found"
+
+	ErrCodeInvalidCode    = "invalid_code"
+	ErrCodeDeviceNotFound = "device_not_found"
+
+	ErrCodeAccountsDisabled   = "accounts_disabled"
+	ErrCodeAccountExists      = "account_exists"
+	ErrCodeAccountNotFound    = "account_not_found"
+	ErrCodeInvalidCredentials = "invalid_credentials"
+	ErrCodeLoginRequired      = "login_required"
+
+	ErrCodeInviteRequired = "invite_required"
+	ErrCodeInviteNotFound = "invite_not_found"
+
+	ErrCodeCSRFFailed = "csrf_failed"
+
+	ErrCodeUnderReview = "under_review"
+
+	ErrCodeContentRejected = "content_rejected"
+	ErrCodeInfectedFile    = "infected_file"
+	ErrCodeScanUnavailable = "scan_unavailable"
+
+	ErrCodeRateLimited         = "rate_limited"
+	ErrCodeQuotaExceeded       = "quota_exceeded"
+	ErrCodeInsufficientStorage = "insufficient_storage"
+)
+
+// SessionResource - представление сессии в API
+type SessionResource struct {
+	ID         string `json:"id"`
+	AlbumCount int    `json:"album_count"`
+	ImageCount int    `json:"image_count"`
+	Token      string `json:"token,omitempty"` // только в ответе на создание
+}
+
+// AlbumResource - представление альбома в API
+type AlbumResource struct {
+	ID         string    `json:"id"`
+	SessionID  string    `json:"session_id"`
+	Name       string    `json:"name"`
+	Private    bool      `json:"private"`
+	ImageCount int       `json:"image_count"`
+	CreatedAt  time.Time `json:"created_at"`
+	URL        string    `json:"url"`
+	ZipURL     string    `json:"zip_url"`
+}
+
+// ImageResource - представление изображения в API
+type ImageResource struct {
+	Filename     string    `json:"filename"`
+	AlbumID      string    `json:"album_id"`
+	SessionID    string    `json:"session_id"`
+	OriginalName string    `json:"original_name,omitempty"`
+	Size         int64     `json:"size"`
+	UploadedAt   time.Time `json:"uploaded_at"`
+	URL          string    `json:"url"`
+	DeletionURL  string    `json:"deletion_url,omitempty"` // только в ответе на загрузку
+}
+
+// HealthResource - состояние сервера для мониторинга
+type HealthResource struct {
+	Status  string        `json:"status"`  // ok или degraded
+	Uploads bool          `json:"uploads"` // принимаются ли загрузки
+	Disk    *DiskResource `json:"disk,omitempty"`
+}
+
+// DiskResource - свободное место в хранилище; есть, если заданы границы свободного места
+type DiskResource struct {
+	FreeBytes          int64      `json:"free_bytes"`
+	TotalBytes         int64      `json:"total_bytes"`
+	LowWatermarkBytes  int64      `json:"low_watermark_bytes"`
+	HighWatermarkBytes int64      `json:"high_watermark_bytes,omitempty"`
+	BelowLowWatermark  bool       `json:"below_low_watermark"`
+	CheckedAt          time.Time  `json:"checked_at"`
+	Error              string     `json:"error,omitempty"`
+	LastEvictionAt     *time.Time `json:"last_eviction_at,omitempty"`
+	EvictedImages      int        `json:"evicted_images,omitempty"` // удалено последним вытеснением
+}
+
+// Pagination описывает страницу списка
+type Pagination struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	Total      int `json:"total"`
+	TotalPages int `json:"total_pages"`
+}
+
+// registerAPIRoutes регистрирует роуты /api/v1
+func registerAPIRoutes(mux *http.ServeMux) {
+	handleRoute(mux, "GET "+APIPrefix+"/openapi.json", openAPIHandler)
+	handleRoute(mux, "GET /openapi.json", openAPIHandler)
+	handleRoute(mux, "GET "+APIPrefix+"/health", apiHealth)
+
+	handleRoute(mux, "POST "+APIPrefix+"/sessions", apiCreateSession)
+	handleRoute(mux, "GET "+APIPrefix+"/session", apiCurrentSession)
+	handleRoute(mux, "GET "+APIPrefix+"/sessions/{session}", apiGetSession)
+	handleRoute(mux, "DELETE "+APIPrefix+"/sessions/{session}", apiDeleteSession)
+
+	handleRoute(mux, "GET "+APIPrefix+"/sessions/{session}/albums", apiListAlbums)
+	handleRoute(mux, "POST "+APIPrefix+"/sessions/{session}/albums", apiCreateAlbum)
+	handleRoute(mux, "GET "+APIPrefix+"/sessions/{session}/albums/{album}", apiGetAlbum)
+	handleRoute(mux, "PATCH "+APIPrefix+"/sessions/{session}/albums/{album}", apiUpdateAlbum)
+	handleRoute(mux, "DELETE "+APIPrefix+"/sessions/{session}/albums/{album}", apiDeleteAlbum)
+
+	handleRoute(mux, "GET "+APIPrefix+"/sessions/{session}/albums/{album}/images", apiListImages)
+	handleRoute(mux, "POST "+APIPrefix+"/sessions/{session}/albums/{album}/images", apiUploadImages)
+	handleRoute(mux, "GET "+APIPrefix+"/sessions/{session}/albums/{album}/images/{filename}", apiGetImage)
+	handleRoute(mux, "PATCH "+APIPrefix+"/sessions/{session}/albums/{album}/images/{filename}", apiUpdateImage)
+	handleRoute(mux, "DELETE "+APIPrefix+"/sessions/{session}/albums/{album}/images/{filename}", apiDeleteImage)
+	handleRoute(mux, "POST "+APIPrefix+"/sessions/{session}/albums/{album}/images/{filename}/reports", apiReportImage)
+
+	handleRoute(mux, "GET "+APIPrefix+"/tokens", apiListTokens)
+	handleRoute(mux, "POST "+APIPrefix+"/tokens", apiCreateToken)
+	handleRoute(mux, "DELETE "+APIPrefix+"/tokens/{id}", apiRevokeToken)
+
+	handleRoute(mux, "GET "+APIPrefix+"/devices", apiListDevices)
+	handleRoute(mux, "POST "+APIPrefix+"/devices", apiRedeemDevice)
+	handleRoute(mux, "DELETE "+APIPrefix+"/devices/{id}", apiRevokeDevice)
+	handleRoute(mux, "POST "+APIPrefix+"/recovery-code", apiCreateRecoveryCode)
+	handleRoute(mux, "POST "+APIPrefix+"/pairing-codes", apiCreatePairingCode)
+
+	handleRoute(mux, "GET "+APIPrefix+"/account", apiGetAccount)
+	handleRoute(mux, "POST "+APIPrefix+"/account", apiSignup)
+	handleRoute(mux, "POST "+APIPrefix+"/account/login", apiLogin)
+	handleRoute(mux, "POST "+APIPrefix+"/account/logout", apiLogout)
+	handleRoute(mux, "POST "+APIPrefix+"/account/sessions", apiMergeSession)
+
+	handleRoute(mux, "POST "+APIPrefix+"/invite", apiRedeemInvite)
+	handleRoute(mux, "GET "+APIPrefix+"/admin/invites", apiListInvites)
+	handleRoute(mux, "POST "+APIPrefix+"/admin/invites", apiCreateInvite)
+	handleRoute(mux, "DELETE "+APIPrefix+"/admin/invites/{id}", apiRevokeInvite)
+
+	// Все остальное под /api/ отвечает JSON ошибкой, а не HTML страницей
+	handleRoute(mux, "/api/", apiNotFound)
+}
+
+// Сессии
+
+// apiCreateSession создает новую сессию. Браузер получает cookie, скрипт - токен владельца:
+// ID сессии виден в ссылках и сам по себе входом не считается.
+func apiCreateSession(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := createSession()
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+	_, secret, err := tokens.CreateOwner(sessionID)
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+	setNewSessionCookies(w, sessionID)
+
+	apiData(w, http.StatusCreated, SessionResource{ID: sessionID, Token: secret})
+}
+
+// apiGetSession возвращает сведения о сессии владельца
+func apiGetSession(w http.ResponseWriter, r *http.Request) {
+	sessionID, ok := apiOwnedSession(w, r, "")
+	if !ok {
+		return
+	}
+	apiSessionSummary(w, sessionID)
+}
+
+// apiHealth сообщает, работает ли сервер и хватает ли места для загрузок.
+// Нехватка места не считается отказом: просмотр продолжает работать, поэтому ответ всегда 200.
+func apiHealth(w http.ResponseWriter, r *http.Request) {
+	health := HealthResource{Status: "ok", Uploads: true}
+	if diskMonitoringEnabled() {
+		status := checkDisk()
+		disk := &DiskResource{
+			FreeBytes:          int64(status.Free),
+			TotalBytes:         int64(status.Total),
+			LowWatermarkBytes:  int64(status.LowWatermark),
+			HighWatermarkBytes: int64(status.HighWatermark),
+			BelowLowWatermark:  status.Low,
+			CheckedAt:          status.CheckedAt,
+			Error:              status.Error,
+			EvictedImages:      status.Evicted,
+		}
+		if !status.LastEviction.IsZero() {
+			disk.LastEvictionAt = &status.LastEviction
+		}
+		if status.Low {
+			health.Status, health.Uploads = "degraded", false
+		} else if status.Error != "" {
+			health.Status = "degraded"
+		}
+		health.Disk = disk
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	apiData(w, http.StatusOK, health)
+}
+
+// apiCurrentSession возвращает сессию клиента; по нему владелец токена узнает ID своей сессии
+func apiCurrentSession(w http.ResponseWriter, r *http.Request) {
+	caller, ok := apiRequireCaller(w, r, "")
+	if !ok {
+		return
+	}
+	apiSessionSummary(w, caller.SessionID)
+}
+
+// apiSessionSummary отправляет сведения о сессии
+func apiSessionSummary(w http.ResponseWriter, sessionID string) {
+	albums, err := getUserAlbums(sessionID)
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	session := SessionResource{ID: sessionID, AlbumCount: len(albums)}
+	for _, album := range albums {
+		session.ImageCount += album.ImageCount
+	}
+	apiData(w, http.StatusOK, session)
+}
+
+// apiDeleteSession удаляет сессию со всеми альбомами
+func apiDeleteSession(w http.ResponseWriter, r *http.Request) {
+	sessionID, ok := apiOwnedSession(w, r, ScopeDelete)
+	if !ok {
+		return
+	}
+
+	if err := checkDeletable(sessionID, "", ""); err != nil {
+		apiStorageError(w, err)
+		return
+	}
+	if err := deleteUser(sessionID); err != nil {
+		apiStorageError(w, err)
+		return
+	}
+
+	if cookie, err := r.Cookie(SessionCookieName); err == nil && cookie.Value == sessionID {
+		clearSessionCookie(w)
+	}
+	if _, err := r.Cookie(DeviceCookieName); err == nil {
+		clearDeviceCookie(w)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Альбомы
+
+// apiListAlbums возвращает страницу альбомов владельца
+func apiListAlbums(w http.ResponseWriter, r *http.Request) {
+	sessionID, ok := apiOwnedSession(w, r, "")
+	if !ok {
+		return
+	}
+
+	page, perPage, ok := apiPageParams(w, r)
+	if !ok {
+		return
+	}
+
+	albums, err := getUserAlbums(sessionID)
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	albums, pagination := paginate(albums, page, perPage)
+	resources := make([]AlbumResource, 0, len(albums))
+	for _, album := range albums {
+		resources = append(resources, albumResource(r, sessionID, album))
+	}
+	apiList(w, resources, pagination)
+}
+
+// albumUpdateRequest - изменяемые поля альбома
+type albumUpdateRequest struct {
+	Name    *string `json:"name"`
+	Private *bool   `json:"private"`
+}
+
+// apiCreateAlbum создает альбом, при необходимости сразу с названием
+func apiCreateAlbum(w http.ResponseWriter, r *http.Request) {
+	sessionID, ok := apiOwnedSession(w, r, ScopeUpload)
+	if !ok {
+		return
+	}
+
+	var req albumUpdateRequest
+	if !apiDecodeOptionalJSON(w, r, &req) {
+		return
+	}
+	if !validateAlbumUpdate(w, &req) {
+		return
+	}
+
+	albumID, err := createAlbum(sessionID)
+	if err != nil {
+		apiStorageError(w, err)
+		return
+	}
+
+	if req.Name != nil || req.Private != nil {
+		if err := updateAlbumMeta(sessionID, albumID, req.apply); err != nil {
+			apiInternalError(w, err)
+			return
+		}
+	}
+
+	apiData(w, http.StatusCreated, albumResource(r, sessionID, getAlbumInfo(sessionID, albumID)))
+}
+
+// apiGetAlbum возвращает альбом (приватный - только владельцу)
+func apiGetAlbum(w http.ResponseWriter, r *http.Request) {
+	sessionID, albumID, ok := apiVisibleAlbum(w, r)
+	if !ok {
+		return
+	}
+	apiData(w, http.StatusOK, albumResource(r, sessionID, getAlbumInfo(sessionID, albumID)))
+}
+
+// apiUpdateAlbum изменяет название и приватность альбома
+func apiUpdateAlbum(w http.ResponseWriter, r *http.Request) {
+	sessionID, albumID, ok := apiOwnedAlbum(w, r, ScopeUpload)
+	if !ok {
+		return
+	}
+
+	var req albumUpdateRequest
+	if !apiDecodeJSON(w, r, &req) || !validateAlbumUpdate(w, &req) {
+		return
+	}
+
+	if err := updateAlbumMeta(sessionID, albumID, req.apply); err != nil {
+		apiInternalError(w, err)
+		return
+	}
+	apiData(w, http.StatusOK, albumResource(r, sessionID, getAlbumInfo(sessionID, albumID)))
+}
+
+// apiDeleteAlbum удаляет альбом
+func apiDeleteAlbum(w http.ResponseWriter, r *http.Request) {
+	sessionID, albumID, ok := apiOwnedAlbum(w, r, ScopeDelete)
+	if !ok {
+		return
+	}
+
+	if err := checkDeletable(sessionID, albumID, ""); err != nil {
+		apiStorageError(w, err)
+		return
+	}
+	if err := deleteAlbum(sessionID, albumID); err != nil {
+		apiStorageError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateAlbumUpdate проверяет и нормализует поля альбома
+func validateAlbumUpdate(w http.ResponseWriter, req *albumUpdateRequest) bool {
+	if req.Name == nil {
+		return true
+	}
+
+	name := strings.TrimSpace(*req.Name)
+	if utf8.RuneCountInString(name) > MaxAlbumNameLen {
+		ErrorResponse(w, http.StatusBadRequest, ErrCodeBadRequest,
+			fmt.Sprintf("name must be at most %d characters", MaxAlbumNameLen))
+		return false
+	}
+	req.Name = &name
+	return true
+}
+
+// apply переносит изменения в метаданные альбома
+func (req *albumUpdateRequest) apply(meta *AlbumMeta) {
+	if req.Name != nil {
+		meta.Name = *req.Name
+	}
+	if req.Private != nil {
+		meta.Private = *req.Private
+	}
+}
+
+// Изображения
+
+// apiListImages возвращает страницу изображений альбома в порядке загрузки
+func apiListImages(w http.ResponseWriter, r *http.Request) {
+	sessionID, albumID, ok := apiVisibleAlbum(w, r)
+	if !ok {
+		return
+	}
+
+	page, perPage, ok := apiPageParams(w, r)
+	if !ok {
+		return
+	}
+
+	images, err := getUserImages(sessionID, albumID)
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	meta, err := loadAlbumMeta(sessionID, albumID)
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	images, pagination := paginate(images, page, perPage)
+	resources := make([]ImageResource, 0, len(images))
+	for _, img := range images {
+		resources = append(resources, imageResource(r, img, meta))
+	}
+	apiList(w, resources, pagination)
+}
+
+// apiUploadImages загружает изображения из поля image multipart формы
+func apiUploadImages(w http.ResponseWriter, r *http.Request) {
+	sessionID, albumID, ok := apiOwnedAlbum(w, r, ScopeUpload)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxArchiveSize)
+	if err := r.ParseMultipartForm(MaxFileSize); err != nil {
+		ErrorResponse(w, http.StatusBadRequest, ErrCodeBadRequest, "multipart form with an image field required")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	files := getUploadFiles(r)
+	if len(files) == 0 {
+		ErrorResponse(w, http.StatusBadRequest, ErrCodeBadRequest, "no files in the image field")
+		return
+	}
+
+	// Загрузка атомарна: сначала проверяются все файлы, и ни один не сохраняется,
+	// если хотя бы один не подходит
+	for _, fh := range files {
+		if err := checkUploadPart(fh); err != nil {
+			apiStorageError(w, fmt.Errorf("%s: %w", fh.Filename, err))
+			return
+		}
+	}
+
+	var uploaded []*ImageInfo
+	for _, fh := range files {
+		info, err := saveUploadPart(fh, sessionID, albumID)
+		if err != nil {
+			// Квота, место или сканер отказали посреди пачки: уже сохраненное откатывается
+			for _, img := range uploaded {
+				if err := deleteImage(sessionID, albumID, img.Filename); err != nil {
+					logger.Error(fmt.Sprintf("apiUploadImages: rollback of %s failed: %v", img.Filename, err))
+				}
+			}
+			apiStorageError(w, fmt.Errorf("%s: %w", fh.Filename, err))
+			return
+		}
+		uploaded = append(uploaded, info)
+	}
+
+	meta, err := loadAlbumMeta(sessionID, albumID)
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	resources := make([]ImageResource, 0, len(uploaded))
+	for _, img := range uploaded {
+		resource := imageResource(r, *img, meta)
+		resource.DeletionURL = deletionURL(r, img)
+		resources = append(resources, resource)
+	}
+	apiData(w, http.StatusCreated, resources)
+}
+
+// checkUploadPart проверяет размер и тип файла из multipart формы
+func checkUploadPart(fh *multipart.FileHeader) error {
+	file, err := fh.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = checkImageFile(file, fh.Size)
+	return err
+}
+
+// saveUploadPart сохраняет файл из multipart формы в альбом
+func saveUploadPart(fh *multipart.FileHeader, sessionID, albumID string) (*ImageInfo, error) {
+	file, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return saveImage(file, fh, sessionID, albumID)
+}
+
+// apiGetImage возвращает сведения об изображении
+func apiGetImage(w http.ResponseWriter, r *http.Request) {
+	sessionID, albumID, ok := apiVisibleAlbum(w, r)
+	if !ok {
+		return
+	}
+
+	img, ok := apiImage(w, r, sessionID, albumID)
+	if !ok {
+		return
+	}
+
+	meta, err := loadAlbumMeta(sessionID, albumID)
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+	apiData(w, http.StatusOK, imageResource(r, img, meta))
+}
+
+// imageUpdateRequest - изменяемые поля изображения
+type imageUpdateRequest struct {
+	OriginalName *string `json:"original_name"`
+}
+
+// apiUpdateImage изменяет исходное имя изображения
+func apiUpdateImage(w http.ResponseWriter, r *http.Request) {
+	sessionID, albumID, ok := apiOwnedAlbum(w, r, ScopeUpload)
+	if !ok {
+		return
+	}
+
+	img, ok := apiImage(w, r, sessionID, albumID)
+	if !ok {
+		return
+	}
+
+	var req imageUpdateRequest
+	if !apiDecodeJSON(w, r, &req) {
+		return
+	}
+
+	if req.OriginalName != nil {
+		name := strings.TrimSpace(*req.OriginalName)
+		if strings.ContainsAny(name, "/\\") || utf8.RuneCountInString(name) > MaxAlbumNameLen {
+			ErrorResponse(w, http.StatusBadRequest, ErrCodeBadRequest, "invalid original_name")
+			return
+		}
+		if err := updateAlbumMeta(sessionID, albumID, func(meta *AlbumMeta) {
+			imageMeta := meta.Images[img.Filename]
+			imageMeta.OriginalName = name
+			meta.Images[img.Filename] = imageMeta
+		}); err != nil {
+			apiInternalError(w, err)
+			return
+		}
+	}
+
+	meta, err := loadAlbumMeta(sessionID, albumID)
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+	apiData(w, http.StatusOK, imageResource(r, img, meta))
+}
+
+// apiDeleteImage удаляет изображение
+func apiDeleteImage(w http.ResponseWriter, r *http.Request) {
+	sessionID, albumID, ok := apiOwnedAlbum(w, r, ScopeDelete)
+	if !ok {
+		return
+	}
+
+	img, ok := apiImage(w, r, sessionID, albumID)
+	if !ok {
+		return
+	}
+
+	if err := checkDeletable(sessionID, albumID, img.Filename); err != nil {
+		apiStorageError(w, err)
+		return
+	}
+	if err := deleteImage(sessionID, albumID, img.Filename); err != nil {
+		apiStorageError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiNotFound отвечает на неизвестные роуты API
+func apiNotFound(w http.ResponseWriter, r *http.Request) {
+	ErrorResponse(w, http.StatusNotFound, ErrCodeNotFound, "route not found")
+}
+
+// Проверка доступа и параметров пути
+
+// apiRequireCaller аутентифицирует клиента и проверяет область действия токена
+func apiRequireCaller(w http.ResponseWriter, r *http.Request, scope string) (*Caller, bool) {
+	caller, err := authenticate(r)
+	switch {
+	case errors.Is(err, ErrNoCredentials):
+		ErrorResponse(w, http.StatusUnauthorized, ErrCodeUnauthorized,
+			"Authorization: Bearer token or session cookie required")
+		return nil, false
+	case errors.Is(err, ErrTokenExpired):
+		ErrorResponse(w, http.StatusUnauthorized, ErrCodeTokenExpired, err.Error())
+		return nil, false
+	case errors.Is(err, ErrInvalidToken):
+		ErrorResponse(w, http.StatusUnauthorized, ErrCodeInvalidToken, err.Error())
+		return nil, false
+	case err != nil:
+		apiInternalError(w, err)
+		return nil, false
+	}
+
+	if !caller.Can(scope) {
+		ErrorResponse(w, http.StatusForbidden, ErrCodeInsufficientScope,
+			fmt.Sprintf("token lacks the %q scope", scope))
+		return nil, false
+	}
+	if scope == ScopeUpload {
+		err := checkUploadPolicy(caller)
+		switch {
+		case errors.Is(err, ErrLoginRequired):
+			ErrorResponse(w, http.StatusForbidden, ErrCodeLoginRequired, err.Error())
+			return nil, false
+		case errors.Is(err, ErrInviteRequired):
+			ErrorResponse(w, http.StatusForbidden, ErrCodeInviteRequired, err.Error())
+			return nil, false
+		case err != nil:
+			apiInternalError(w, err)
+			return nil, false
+		}
+	}
+	return caller, true
+}
+
+// apiOwnedSession проверяет, что сессия из пути принадлежит клиенту
+func apiOwnedSession(w http.ResponseWriter, r *http.Request, scope string) (string, bool) {
+	sessionID := r.PathValue("session")
+	if !IsValidID(sessionID) {
+		ErrorResponse(w, http.StatusNotFound, ErrCodeSessionNotFound, "session not found")
+		return "", false
+	}
+
+	caller, ok := apiRequireCaller(w, r, scope)
+	if !ok {
+		return "", false
+	}
+	if caller.SessionID != sessionID {
+		ErrorResponse(w, http.StatusForbidden, ErrCodeForbidden, "session belongs to another user")
+		return "", false
+	}
+	return sessionID, true
+}
+
+// apiOwnedAlbum проверяет владение сессией и существование альбома
+func apiOwnedAlbum(w http.ResponseWriter, r *http.Request, scope string) (string, string, bool) {
+	sessionID, ok := apiOwnedSession(w, r, scope)
+	if !ok {
+		return "", "", false
+	}
+
+	albumID := r.PathValue("album")
+	if !albumExists(sessionID, albumID) {
+		ErrorResponse(w, http.StatusNotFound, ErrCodeAlbumNotFound, "album not found")
+		return "", "", false
+	}
+	return sessionID, albumID, true
+}
+
+// apiVisibleAlbum проверяет, что альбом существует и доступен клиенту
+func apiVisibleAlbum(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	sessionID := r.PathValue("session")
+	albumID := r.PathValue("album")
+
+	// Приватный альбом для чужих неотличим от несуществующего
+	if !IsValidID(sessionID) || !albumExists(sessionID, albumID) || !canViewAlbum(r, sessionID, albumID) {
+		ErrorResponse(w, http.StatusNotFound, ErrCodeAlbumNotFound, "album not found")
+		return "", "", false
+	}
+	return sessionID, albumID, true
+}
+
+// apiImage находит изображение из пути запроса
+func apiImage(w http.ResponseWriter, r *http.Request, sessionID, albumID string) (ImageInfo, bool) {
+	filename := r.PathValue("filename")
+	if !IsValidImageFilename(filename) {
+		ErrorResponse(w, http.StatusNotFound, ErrCodeImageNotFound, "image not found")
+		return ImageInfo{}, false
+	}
+
+	filePath := imagePath(sessionID, albumID, filename)
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		ErrorResponse(w, http.StatusNotFound, ErrCodeImageNotFound, "image not found")
+		return ImageInfo{}, false
+	}
+
+	return ImageInfo{
+		Filename: filename,
+		Path:     filePath,
+		Size:     stat.Size(),
+		UserID:   sessionID,
+		AlbumID:  albumID,
+	}, true
+}
+
+// albumExists проверяет наличие директории альбома
+func albumExists(sessionID, albumID string) bool {
+	if !IsValidID(albumID) {
+		return false
+	}
+	info, err := os.Stat(albumPath(sessionID, albumID))
+	return err == nil && info.IsDir()
+}
+
+// Формирование ответов
+
+// albumResource преобразует AlbumInfo в ответ API
+func albumResource(r *http.Request, sessionID string, album AlbumInfo) AlbumResource {
+	url := BaseURL(r) + "/" + sessionID + "/" + album.ID
+	return AlbumResource{
+		ID:         album.ID,
+		SessionID:  sessionID,
+		Name:       album.Name,
+		Private:    album.Private,
+		ImageCount: album.ImageCount,
+		CreatedAt:  album.CreatedAt,
+		URL:        url,
+		ZipURL:     url + ".zip",
+	}
+}
+
+// imageResource преобразует ImageInfo в ответ API
+func imageResource(r *http.Request, img ImageInfo, meta *AlbumMeta) ImageResource {
+	imageMeta := meta.Images[img.Filename]
+	uploadedAt := imageMeta.UploadedAt
+	if uploadedAt.IsZero() {
+		// Для изображений без метаданных используем время изменения файла
+		if stat, err := os.Stat(img.Path); err == nil {
+			uploadedAt = stat.ModTime()
+		}
+	}
+
+	return ImageResource{
+		Filename:     img.Filename,
+		AlbumID:      img.AlbumID,
+		SessionID:    img.UserID,
+		OriginalName: imageMeta.OriginalName,
+		Size:         img.Size,
+		UploadedAt:   uploadedAt,
+		URL:          BaseURL(r) + "/" + img.UserID + "/" + img.AlbumID + "/" + img.Filename,
+	}
+}
+
+// apiData отправляет ресурс в конверте {"data": ...}
+func apiData(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+// apiList отправляет страницу списка с информацией о пагинации
+func apiList(w http.ResponseWriter, data interface{}, pagination Pagination) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":       data,
+		"pagination": pagination,
+	})
+}
+
+// storageErrors - коды ответа для ошибок хранилища. Таблица общая для JSON API
+// и обработчиков форм (uploadErrorStatus), чтобы одна ошибка везде давала один код.
+var storageErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{ErrFileTooLarge, http.StatusRequestEntityTooLarge, ErrCodeFileTooLarge},
+	{ErrInvalidImageType, http.StatusUnsupportedMediaType, ErrCodeInvalidImageType},
+	{ErrContentRejected, http.StatusUnprocessableEntity, ErrCodeContentRejected},
+	{ErrInfectedFile, http.StatusUnprocessableEntity, ErrCodeInfectedFile},
+	{ErrScanUnavailable, http.StatusServiceUnavailable, ErrCodeScanUnavailable},
+	{ErrQuotaExceeded, http.StatusForbidden, ErrCodeQuotaExceeded},
+	{ErrInsufficientStorage, http.StatusInsufficientStorage, ErrCodeInsufficientStorage},
+	{ErrUnderReview, http.StatusConflict, ErrCodeUnderReview},
+	{ErrImageNotFound, http.StatusNotFound, ErrCodeImageNotFound},
+	{ErrAlbumNotFound, http.StatusNotFound, ErrCodeAlbumNotFound},
+	{ErrUserNotFound, http.StatusNotFound, ErrCodeSessionNotFound},
+}
+
+// apiStorageError переводит ошибки хранилища в коды API
+func apiStorageError(w http.ResponseWriter, err error) {
+	for _, e := range storageErrors {
+		if errors.Is(err, e.err) {
+			ErrorResponse(w, e.status, e.code, err.Error())
+			return
+		}
+	}
+	apiInternalError(w, err)
+}
+
+// apiInternalError логирует ошибку и скрывает подробности от клиента
+func apiInternalError(w http.ResponseWriter, err error) {
+	logger.Error("API error: " + err.Error())
+	ErrorResponse(w, http.StatusInternalServerError, ErrCodeInternal, "internal server error")
+}
+
+// apiDecodeJSON разбирает тело запроса
+func apiDecodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	return decodeJSONBody(w, r, v, false)
+}
+
+// apiDecodeOptionalJSON разбирает тело запроса, если оно есть; пустое тело оставляет v как есть.
+// Длина тела не проверяется: при chunked передаче ContentLength равен -1.
+func apiDecodeOptionalJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	return decodeJSONBody(w, r, v, true)
+}
+
+// decodeJSONBody разбирает JSON тело запроса и отвечает 400 при ошибке
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}, optional bool) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil && !(optional && err == io.EOF) {
+		ErrorResponse(w, http.StatusBadRequest, ErrCodeBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// apiPageParams читает параметры page и per_page
+func apiPageParams(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	page, perPage := 1, DefaultPageSize
+	query := r.URL.Query()
+
+	if v := query.Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			ErrorResponse(w, http.StatusBadRequest, ErrCodeBadRequest, "page must be a positive integer")
+			return 0, 0, false
+		}
+		page = n
+	}
+	if v := query.Get("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > MaxPageSize {
+			ErrorResponse(w, http.StatusBadRequest, ErrCodeBadRequest,
+				fmt.Sprintf("per_page must be between 1 and %d", MaxPageSize))
+			return 0, 0, false
+		}
+		perPage = n
+	}
+	// Смещение (page-1)*perPage должно помещаться в int
+	if page > math.MaxInt/perPage {
+		ErrorResponse(w, http.StatusBadRequest, ErrCodeBadRequest, "page is out of range")
+		return 0, 0, false
+	}
+	return page, perPage, true
+}
+
+// paginate вырезает запрошенную страницу из списка
+func paginate[T any](items []T, page, perPage int) ([]T, Pagination) {
+	total := len(items)
+	pagination := Pagination{
+		Page:       page,
+		PerPage:    perPage,
+		Total:      total,
+		TotalPages: (total + perPage - 1) / perPage,
+	}
+
+	start := (page - 1) * perPage
+	if start >= total {
+		return []T{}, pagination
+	}
+	end := start + perPage
+	if end > total {
+		end = total
+	}
+	return items[start:end], pagination
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ripx/client"
+)
+
+func TestAPIPageParams(t *testing.T) {
+	tests := []struct {
+		query       string
+		ok          bool
+		page, limit int
+	}{
+		{"", true, 1, DefaultPageSize},
+		{"page=3&per_page=10", true, 3, 10},
+		{"page=0", false, 0, 0},
+		{"page=-1", false, 0, 0},
+		{"page=x", false, 0, 0},
+		{fmt.Sprintf("per_page=%d", MaxPageSize+1), false, 0, 0},
+		{"page=9223372036854775807", false, 0, 0},
+		{"page=99999999999999999999", false, 0, 0},
+		{fmt.Sprintf("page=%d&per_page=%d", math.MaxInt/MaxPageSize+1, MaxPageSize), false, 0, 0},
+		{fmt.Sprintf("page=%d&per_page=%d", math.MaxInt/MaxPageSize, MaxPageSize), true, math.MaxInt / MaxPageSize, MaxPageSize},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		page, perPage, ok := apiPageParams(w, httptest.NewRequest("GET", "/api/v1/sessions?"+tt.query, nil))
+		if ok != tt.ok || page != tt.page || perPage != tt.limit {
+			t.Errorf("apiPageParams(%q) = %d, %d, %v, want %d, %d, %v", tt.query, page, perPage, ok, tt.page, tt.limit, tt.ok)
+		}
+		if !tt.ok && w.Code != http.StatusBadRequest {
+			t.Errorf("apiPageParams(%q) answered %d, want 400", tt.query, w.Code)
+		}
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5}
+	tests := []struct {
+		page, perPage int
+		want          int
+		totalPages    int
+	}{
+		{1, 2, 2, 3},
+		{3, 2, 1, 3},
+		{4, 2, 0, 3},
+		{math.MaxInt / 2, 2, 0, 3},
+	}
+	for _, tt := range tests {
+		got, pagination := paginate(items, tt.page, tt.perPage)
+		if len(got) != tt.want || pagination.TotalPages != tt.totalPages || pagination.Total != len(items) {
+			t.Errorf("paginate(page=%d, per_page=%d) = %v, %+v", tt.page, tt.perPage, got, pagination)
+		}
+	}
+}
+
+func TestAPIListImagesHugePage(t *testing.T) {
+	c := newTestClient(t)
+	album, err := c.CreateAlbum(context.Background(), client.AlbumUpdate{})
+	if err != nil {
+		t.Fatalf("CreateAlbum: %v", err)
+	}
+
+	url := fmt.Sprintf("%s/api/v1/sessions/%s/albums/%s/images?page=9223372036854775807", testServer.URL, c.SessionID(), album.ID)
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest || body.Error.Code != ErrCodeBadRequest {
+		t.Errorf("huge page: %d %q, want 400 %q", resp.StatusCode, body.Error.Code, ErrCodeBadRequest)
+	}
+}
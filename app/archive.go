@@ -0,0 +1,271 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"path"
+	"strings"
+)
+
+// Статусы обработки записей архива
+const (
+	ArchiveEntryOK       = "ok"
+	ArchiveEntryRejected = "rejected"
+	ArchiveEntrySkipped  = "skipped"
+)
+
+// ArchiveEntryResult описывает результат обработки одной записи архива
+type ArchiveEntryResult struct {
+	Archive     string `json:"archive"`
+	Name        string `json:"name"`
+	Status      string `json:"status"`
+	Filename    string `json:"filename,omitempty"`
+	DeletionURL string `json:"deletion_url,omitempty"`
+	Error       string `json:"error,omitempty"`
+
+	image *ImageInfo // сохраненное изображение, для построения ссылок в ответе
+}
+
+// errArchiveLimit прерывает распаковку при превышении общих лимитов
+var errArchiveLimit = errors.New("archive limits exceeded")
+
+// archiveKind определяет тип архива по имени файла ("" - не архив)
+func archiveKind(filename string) string {
+	name := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	}
+	return ""
+}
+
+// splitArchiveUploads отделяет архивы от обычных изображений
+func splitArchiveUploads(files []*multipart.FileHeader) (images, archives []*multipart.FileHeader) {
+	for _, fh := range files {
+		if archiveKind(fh.Filename) != "" {
+			archives = append(archives, fh)
+		} else {
+			images = append(images, fh)
+		}
+	}
+	return images, archives
+}
+
+// archiveExtractor проверяет и сохраняет записи архива, следя за лимитами
+type archiveExtractor struct {
+	archive    string
+	userID     string
+	albumID    string
+	entries    int
+	totalBytes int64
+	results    []ArchiveEntryResult
+}
+
+// processArchiveUploads распаковывает загруженные архивы в альбом
+func processArchiveUploads(archives []*multipart.FileHeader, userID, albumID string) []ArchiveEntryResult {
+	var results []ArchiveEntryResult
+	for _, fh := range archives {
+		ex := &archiveExtractor{archive: fh.Filename, userID: userID, albumID: albumID}
+		if err := ex.extract(fh); err != nil {
+			logger.Error(fmt.Sprintf("processArchiveUploads: %s: %v", fh.Filename, err))
+			ex.results = append(ex.results, ArchiveEntryResult{
+				Archive: fh.Filename,
+				Status:  ArchiveEntryRejected,
+				Error:   err.Error(),
+			})
+		}
+		results = append(results, ex.results...)
+	}
+	return results
+}
+
+// extract открывает архив и обходит его записи
+func (ex *archiveExtractor) extract(fh *multipart.FileHeader) error {
+	file, err := fh.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	switch archiveKind(fh.Filename) {
+	case "zip":
+		return ex.extractZip(file, fh.Size)
+	case "tar.gz":
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("invalid gzip stream: %w", err)
+		}
+		defer gz.Close()
+		return ex.extractTar(gz)
+	case "tar":
+		return ex.extractTar(file)
+	}
+	return fmt.Errorf("unsupported archive type")
+}
+
+// extractZip обрабатывает ZIP архив
+func (ex *archiveExtractor) extractZip(file multipart.File, size int64) error {
+	zr, err := zip.NewReader(file, size)
+	if err != nil {
+		return fmt.Errorf("invalid zip archive: %w", err)
+	}
+
+	for _, f := range zr.File {
+		if err := ex.countEntry(int64(f.UncompressedSize64)); err != nil {
+			return err
+		}
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if !ex.checkEntry(f.Name, int64(f.UncompressedSize64), f.FileInfo().Mode().IsRegular()) {
+			continue
+		}
+
+		// Защита от zip-бомб: подозрительно высокая степень сжатия
+		if f.CompressedSize64 > 0 && f.UncompressedSize64/f.CompressedSize64 > MaxArchiveRatio {
+			ex.reject(f.Name, "suspicious compression ratio")
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			ex.reject(f.Name, err.Error())
+			continue
+		}
+		ex.storeEntry(f.Name, rc)
+		rc.Close()
+	}
+	return nil
+}
+
+// extractTar обрабатывает TAR архив (в том числе распакованный из gzip)
+func (ex *archiveExtractor) extractTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid tar archive: %w", err)
+		}
+
+		// Размер из заголовка TAR равен реально распаковываемому объему,
+		// поэтому учитываем его и для пропускаемых записей
+		if err := ex.countEntry(hdr.Size); err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		if !ex.checkEntry(hdr.Name, hdr.Size, hdr.Typeflag == tar.TypeReg) {
+			continue
+		}
+		ex.storeEntry(hdr.Name, tr)
+	}
+}
+
+// countEntry учитывает запись в общих лимитах архива
+func (ex *archiveExtractor) countEntry(size int64) error {
+	ex.entries++
+	ex.totalBytes += size
+	if ex.entries > MaxArchiveEntries {
+		return fmt.Errorf("%w: more than %d entries", errArchiveLimit, MaxArchiveEntries)
+	}
+	if ex.totalBytes > MaxArchiveTotalSize {
+		return fmt.Errorf("%w: more than %d bytes uncompressed", errArchiveLimit, MaxArchiveTotalSize)
+	}
+	return nil
+}
+
+// checkEntry проверяет имя, тип и размер записи до ее чтения
+func (ex *archiveExtractor) checkEntry(name string, size int64, regular bool) bool {
+	if !isSafeArchivePath(name) {
+		ex.reject(name, "unsafe path")
+		return false
+	}
+
+	// Служебные файлы ОС (__MACOSX, ._file, .DS_Store) пропускаем молча
+	base := path.Base(name)
+	if strings.HasPrefix(base, ".") || strings.HasPrefix(name, "__MACOSX/") {
+		ex.results = append(ex.results, ArchiveEntryResult{Archive: ex.archive, Name: name, Status: ArchiveEntrySkipped})
+		return false
+	}
+
+	if !regular {
+		ex.reject(name, "not a regular file")
+		return false
+	}
+	if !IsImageFile(name) {
+		ex.reject(name, "not an image file")
+		return false
+	}
+	if size > MaxFileSize {
+		ex.reject(name, fmt.Sprintf("file too large: %d bytes", size))
+		return false
+	}
+	return true
+}
+
+// storeEntry читает запись с ограничением размера и сохраняет изображение
+func (ex *archiveExtractor) storeEntry(name string, r io.Reader) {
+	// Заголовкам архива не доверяем: читаем не больше MaxFileSize+1 байт
+	data, err := io.ReadAll(io.LimitReader(r, MaxFileSize+1))
+	if err != nil {
+		ex.reject(name, err.Error())
+		return
+	}
+	if len(data) > MaxFileSize {
+		ex.reject(name, "file too large")
+		return
+	}
+
+	info, err := storeImage(bytes.NewReader(data), int64(len(data)), name, ex.userID, ex.albumID)
+	if err != nil {
+		ex.reject(name, err.Error())
+		return
+	}
+	ex.results = append(ex.results, ArchiveEntryResult{
+		Archive:  ex.archive,
+		Name:     name,
+		Status:   ArchiveEntryOK,
+		Filename: info.Filename,
+		image:    info,
+	})
+}
+
+// reject записывает отклоненную запись
+func (ex *archiveExtractor) reject(name, reason string) {
+	ex.results = append(ex.results, ArchiveEntryResult{
+		Archive: ex.archive,
+		Name:    name,
+		Status:  ArchiveEntryRejected,
+		Error:   reason,
+	})
+}
+
+// isSafeArchivePath отсекает абсолютные пути и выход за пределы архива (zip-slip)
+func isSafeArchivePath(name string) bool {
+	if name == "" || strings.Contains(name, "\\") || strings.ContainsRune(name, 0) {
+		return false
+	}
+	if strings.HasPrefix(name, "/") {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"hash/crc32"
+	"io"
+	"mime/multipart"
+	"strings"
+	"testing"
+)
+
+// zipEntry - запись тестового ZIP архива; при raw содержимое пишется как есть с размерами из заголовка
+type zipEntry struct {
+	name string
+	data []byte
+	raw  *zip.FileHeader
+}
+
+// buildZip собирает ZIP архив в памяти
+func buildZip(t *testing.T, entries ...zipEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		var (
+			w   io.Writer
+			err error
+		)
+		if e.raw != nil {
+			w, err = zw.CreateRaw(e.raw)
+		} else {
+			w, err = zw.Create(e.name)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// tarEntry - запись тестового TAR архива; size переопределяет размер в заголовке без записи содержимого
+type tarEntry struct {
+	name     string
+	data     []byte
+	typeflag byte
+	size     int64
+}
+
+// buildTar собирает TAR архив в памяти
+func buildTar(t *testing.T, entries ...tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0o644, Size: int64(len(e.data)), Typeflag: e.typeflag}
+		if hdr.Typeflag == 0 {
+			hdr.Typeflag = tar.TypeReg
+		}
+		if hdr.Typeflag == tar.TypeSymlink {
+			hdr.Linkname, hdr.Size = "/etc/passwd", 0
+		}
+		if e.size > 0 {
+			// Заголовок без содержимого: распаковка должна остановиться до чтения
+			hdr.Size = e.size
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+			return buf.Bytes()
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// gzipBytes сжимает данные gzip
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// archiveUpload превращает архив в файл multipart формы, как его видит /upload
+func archiveUpload(t *testing.T, filename string, data []byte) *multipart.FileHeader {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("images", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := part.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	form, err := multipart.NewReader(&body, mw.Boundary()).ReadForm(MaxArchiveSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { form.RemoveAll() })
+	return form.File["images"][0]
+}
+
+// newTestAlbum создает альбом в новой сессии в обход HTTP
+func newTestAlbum(t *testing.T) (string, string) {
+	t.Helper()
+	sessionID := RandomID()
+	albumID, err := createAlbum(sessionID)
+	if err != nil {
+		t.Fatalf("createAlbum: %v", err)
+	}
+	return sessionID, albumID
+}
+
+// entryStatus - ожидаемый результат одной записи архива
+type entryStatus struct {
+	name   string
+	status string
+	reason string // подстрока ошибки
+}
+
+func TestIsSafeArchivePath(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"photo.png", true},
+		{"trip/day1/photo.png", true},
+		{"..photo.png", true},
+		{"", false},
+		{"../photo.png", false},
+		{"trip/../../photo.png", false},
+		{"trip/..", false},
+		{"/etc/photo.png", false},
+		{"..\\photo.png", false},
+		{"trip\\photo.png", false},
+		{"photo\x00.png", false},
+	}
+	for _, tt := range tests {
+		if got := isSafeArchivePath(tt.name); got != tt.want {
+			t.Errorf("isSafeArchivePath(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProcessArchiveUploads(t *testing.T) {
+	image := testPNG(t, 21)
+	// Миллион нулей сжимается примерно в тысячу раз
+	zeros := make([]byte, 1<<20)
+
+	tests := []struct {
+		name     string
+		filename string
+		data     func(t *testing.T) []byte
+		want     []entryStatus
+	}{
+		{
+			name:     "zip entries",
+			filename: "photos.zip",
+			data: func(t *testing.T) []byte {
+				return buildZip(t,
+					zipEntry{name: "trip/"},
+					zipEntry{name: "trip/ok.png", data: image},
+					zipEntry{name: "__MACOSX/trip/._ok.png", data: []byte("resource fork")},
+					zipEntry{name: "trip/.DS_Store", data: []byte("finder")},
+					zipEntry{name: "../evil.png", data: image},
+					zipEntry{name: "/abs.png", data: image},
+					zipEntry{name: "trip\\win.png", data: image},
+					zipEntry{name: "notes.txt", data: []byte("hello")},
+					zipEntry{name: "fake.png", data: []byte("not an image")},
+				)
+			},
+			want: []entryStatus{
+				{"trip/ok.png", ArchiveEntryOK, ""},
+				{"__MACOSX/trip/._ok.png", ArchiveEntrySkipped, ""},
+				{"trip/.DS_Store", ArchiveEntrySkipped, ""},
+				{"../evil.png", ArchiveEntryRejected, "unsafe path"},
+				{"/abs.png", ArchiveEntryRejected, "unsafe path"},
+				{"trip\\win.png", ArchiveEntryRejected, "unsafe path"},
+				{"notes.txt", ArchiveEntryRejected, "not an image file"},
+				{"fake.png", ArchiveEntryRejected, "invalid image type"},
+			},
+		},
+		{
+			name:     "zip bomb ratio",
+			filename: "bomb.zip",
+			data: func(t *testing.T) []byte {
+				return buildZip(t, zipEntry{name: "bomb.png", data: zeros}, zipEntry{name: "ok.png", data: image})
+			},
+			want: []entryStatus{
+				{"bomb.png", ArchiveEntryRejected, "suspicious compression ratio"},
+				{"ok.png", ArchiveEntryOK, ""},
+			},
+		},
+		{
+			name:     "zip entry over MaxFileSize",
+			filename: "big.zip",
+			data: func(t *testing.T) []byte {
+				return buildZip(t, zipEntry{raw: &zip.FileHeader{
+					Name: "big.png", Method: zip.Store,
+					CompressedSize64: MaxFileSize + 1, UncompressedSize64: MaxFileSize + 1,
+				}, data: make([]byte, MaxFileSize+1)})
+			},
+			want: []entryStatus{{"big.png", ArchiveEntryRejected, "file too large"}},
+		},
+		{
+			name:     "zip header understates size",
+			filename: "liar.zip",
+			data: func(t *testing.T) []byte {
+				data := append(append([]byte{}, image...), zeros...)
+				return buildZip(t, zipEntry{raw: &zip.FileHeader{
+					Name: "liar.png", Method: zip.Store, CRC32: crc32.ChecksumIEEE(data[:len(image)]),
+					CompressedSize64: uint64(len(data)), UncompressedSize64: uint64(len(image)),
+				}, data: data})
+			},
+			want: []entryStatus{{"liar.png", ArchiveEntryRejected, ""}},
+		},
+		{
+			name:     "zip total size",
+			filename: "huge.zip",
+			data: func(t *testing.T) []byte {
+				// Записи заявляют по 600MB, читать их не нужно
+				huge := func(name string) zipEntry {
+					return zipEntry{raw: &zip.FileHeader{Name: name, Method: zip.Store,
+						CompressedSize64: 1, UncompressedSize64: 600 << 20}, data: []byte{0}}
+				}
+				return buildZip(t, huge("a.png"), huge("b.png"))
+			},
+			want: []entryStatus{
+				{"a.png", ArchiveEntryRejected, "file too large"},
+				{"", ArchiveEntryRejected, "uncompressed"},
+			},
+		},
+		{
+			name:     "tar.gz entries",
+			filename: "photos.tar.gz",
+			data: func(t *testing.T) []byte {
+				return gzipBytes(t, buildTar(t,
+					tarEntry{name: "trip/", typeflag: tar.TypeDir},
+					tarEntry{name: "trip/ok.png", data: image},
+					tarEntry{name: "trip/link.png", typeflag: tar.TypeSymlink},
+					tarEntry{name: "trip/../../evil.png", data: image},
+					tarEntry{name: "big.png", data: make([]byte, MaxFileSize+1)},
+				))
+			},
+			want: []entryStatus{
+				{"trip/ok.png", ArchiveEntryOK, ""},
+				{"trip/link.png", ArchiveEntryRejected, "not a regular file"},
+				{"trip/../../evil.png", ArchiveEntryRejected, "unsafe path"},
+				{"big.png", ArchiveEntryRejected, "file too large"},
+			},
+		},
+		{
+			name:     "tar entry count",
+			filename: "many.tar",
+			data: func(t *testing.T) []byte {
+				entries := make([]tarEntry, MaxArchiveEntries+1)
+				for i := range entries {
+					entries[i] = tarEntry{name: "trip/", typeflag: tar.TypeDir}
+				}
+				return buildTar(t, entries...)
+			},
+			want: []entryStatus{{"", ArchiveEntryRejected, "more than 1000 entries"}},
+		},
+		{
+			name:     "tar total size",
+			filename: "huge.tar",
+			data: func(t *testing.T) []byte {
+				return buildTar(t, tarEntry{name: "huge.png", size: MaxArchiveTotalSize + 1})
+			},
+			want: []entryStatus{{"", ArchiveEntryRejected, "uncompressed"}},
+		},
+		{
+			name:     "broken gzip",
+			filename: "broken.tgz",
+			data:     func(t *testing.T) []byte { return []byte("not gzip") },
+			want:     []entryStatus{{"", ArchiveEntryRejected, "invalid gzip stream"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessionID, albumID := newTestAlbum(t)
+			results := processArchiveUploads([]*multipart.FileHeader{archiveUpload(t, tt.filename, tt.data(t))}, sessionID, albumID)
+			if len(results) != len(tt.want) {
+				t.Fatalf("got %d results, want %d: %+v", len(results), len(tt.want), results)
+			}
+			stored := 0
+			for i, want := range tt.want {
+				got := results[i]
+				if got.Archive != tt.filename || got.Name != want.name || got.Status != want.status ||
+					!strings.Contains(got.Error, want.reason) {
+					t.Errorf("result %d = %+v, want %+v", i, got, want)
+				}
+				if want.status == ArchiveEntryOK {
+					stored++
+					if got.Filename == "" || !IsValidImageFilename(got.Filename) {
+						t.Errorf("result %d has no stored filename: %+v", i, got)
+					}
+				}
+			}
+			if images, err := getUserImages(sessionID, albumID); err != nil || len(images) != stored {
+				t.Errorf("album has %d images (%v), want %d", len(images), err, stored)
+			}
+		})
+	}
+}
+
+func TestStoreEntryReadsAtMostMaxFileSize(t *testing.T) {
+	sessionID, albumID := newTestAlbum(t)
+	ex := &archiveExtractor{archive: "stream.tar", userID: sessionID, albumID: albumID}
+
+	// Источник длиннее заявленного: читается не больше MaxFileSize+1 байт
+	src := &countingReader{r: bytes.NewReader(make([]byte, 2*MaxFileSize))}
+	ex.storeEntry("endless.png", src)
+	if len(ex.results) != 1 || ex.results[0].Status != ArchiveEntryRejected || ex.results[0].Error != "file too large" {
+		t.Fatalf("results = %+v, want one rejected entry", ex.results)
+	}
+	if src.n != MaxFileSize+1 {
+		t.Errorf("read %d bytes, want %d", src.n, MaxFileSize+1)
+	}
+}
+
+// countingReader считает прочитанные байты
+type countingReader struct {
+	r *bytes.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// События журнала аудита
+const (
+	AuditUploadBlocked     = "upload_blocked"     // загрузка совпала с блоклистом
+	AuditUploadInfected    = "upload_infected"    // сканер нашел в загрузке вредоносный код
+	AuditRescanMatch       = "rescan_match"       // повторная проверка нашла совпадение среди загруженного
+	AuditBlocklistAdd      = "blocklist_add"      // администратор добавил хеш
+	AuditBlocklistRemove   = "blocklist_remove"   // администратор удалил хеш
+	AuditDiskEviction      = "disk_eviction"      // старые изображения удалены, чтобы освободить диск
+	AuditRetentionEviction = "retention_eviction" // альбомы удалены, чтобы уложиться в бюджет хранилища
+)
+
+// AuditEvent - запись журнала аудита
+type AuditEvent struct {
+	Time         time.Time `json:"time"`
+	Event        string    `json:"event"`
+	SessionID    string    `json:"session_id,omitempty"`
+	AlbumID      string    `json:"album_id,omitempty"`
+	Filename     string    `json:"filename,omitempty"`
+	OriginalName string    `json:"original_name,omitempty"`
+	Detail       string    `json:"detail,omitempty"`
+}
+
+// auditMutex сериализует дозапись в журнал
+var auditMutex sync.Mutex
+
+// recordAudit дописывает событие в StatePath/AuditLogFile (по JSON объекту в строке).
+// Журнал только растет; ротация остается на администраторе сервера.
+func recordAudit(event AuditEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		logger.Error(fmt.Sprintf("recordAudit: %v", err))
+		return
+	}
+
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	if err := EnsureDir(StatePath); err != nil {
+		logger.Error(fmt.Sprintf("recordAudit: %v", err))
+		return
+	}
+	file, err := os.OpenFile(filepath.Join(StatePath, AuditLogFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		logger.Error(fmt.Sprintf("recordAudit: %v", err))
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		logger.Error(fmt.Sprintf("recordAudit: %v", err))
+	}
+}
+
+// recentAudit возвращает последние n событий, новые первыми
+func recentAudit(n int) ([]AuditEvent, error) {
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+
+	file, err := os.Open(filepath.Join(StatePath, AuditLogFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []AuditEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event AuditEvent
+		if json.Unmarshal(scanner.Bytes(), &event) != nil {
+			continue
+		}
+		events = append(events, event)
+		if len(events) > n {
+			events = events[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events, nil
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Виды хешей в блоклисте
+const (
+	BlockSHA256 = "sha256" // точное совпадение файла
+	BlockPHash  = "phash"  // перцептивный dHash: совпадение с точностью до PHashMaxDistance бит
+)
+
+// Источники записей блоклиста
+const (
+	BlockSourceAdmin = "admin"
+	BlockSourceFile  = "file"
+)
+
+// ErrContentRejected - файл совпал с блоклистом. Текст намеренно не объясняет причину.
+var ErrContentRejected = errors.New("file rejected")
+
+// ErrInvalidBlockHash - строка не похожа на хеш блоклиста
+var ErrInvalidBlockHash = errors.New("expected sha256:<64 hex> or phash:<16 hex>")
+
+// imageFingerprint - хеши изображения для сверки с блоклистом.
+// PHash пуст, если изображение не удалось декодировать (например, WebP).
+type imageFingerprint struct {
+	SHA256 string
+	PHash  string
+}
+
+// fingerprintImage считает хеши и возвращает указатель src в начало
+func fingerprintImage(src io.ReadSeeker) (imageFingerprint, error) {
+	var fp imageFingerprint
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, src); err != nil {
+		return fp, err
+	}
+	fp.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return fp, err
+	}
+	fp.PHash = perceptualHash(src)
+	_, err := src.Seek(0, io.SeekStart)
+	return fp, err
+}
+
+// perceptualHash считает 64-битный dHash: изображение сводится к сетке 9x8 средних яркостей,
+// каждый бит - сравнение соседних ячеек строки. Хеш переживает пересжатие и масштабирование.
+func perceptualHash(src io.ReadSeeker) string {
+	config, _, err := image.DecodeConfig(src)
+	if err != nil || config.Width == 0 || config.Height == 0 || config.Width*config.Height > MaxPHashPixels {
+		return ""
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return ""
+	}
+	img, _, err := image.Decode(src)
+	if err != nil {
+		return ""
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	// Для яркости ячейки хватает примерно миллиона точек
+	step := max(1, int(math.Sqrt(float64(width*height)/1e6)))
+
+	var sum, count [8][9]float64
+	for y := 0; y < height; y += step {
+		cellY := y * 8 / height
+		for x := 0; x < width; x += step {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			cellX := x * 9 / width
+			sum[cellY][cellX] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+			count[cellY][cellX]++
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if sum[y][x]/max(count[y][x], 1) < sum[y][x+1]/max(count[y][x+1], 1) {
+				hash |= 1
+			}
+		}
+	}
+	return fmt.Sprintf("%016x", hash)
+}
+
+// phashDistance возвращает число различающихся бит двух dHash
+func phashDistance(a, b string) int {
+	x, errA := strconv.ParseUint(a, 16, 64)
+	y, errB := strconv.ParseUint(b, 16, 64)
+	if errA != nil || errB != nil {
+		return 64
+	}
+	return bits.OnesCount64(x ^ y)
+}
+
+// parseBlockHash разбирает хеш вида sha256:<hex>, phash:<hex> или голый hex,
+// вид которого определяется по длине
+func parseBlockHash(s string) (kind, hash string, err error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	kind, hash, found := strings.Cut(s, ":")
+	if !found {
+		hash = s
+		switch len(s) {
+		case 64:
+			kind = BlockSHA256
+		case 16:
+			kind = BlockPHash
+		}
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", "", ErrInvalidBlockHash
+	}
+	if (kind == BlockSHA256 && len(hash) == 64) || (kind == BlockPHash && len(hash) == 16) {
+		return kind, hash, nil
+	}
+	return "", "", ErrInvalidBlockHash
+}
+
+// BlockEntry - хеш заблокированного контента
+type BlockEntry struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	Hash      string    `json:"hash"`
+	Note      string    `json:"note,omitempty"`
+	Source    string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// matches сверяет отпечаток изображения с записью
+func (e *BlockEntry) matches(fp imageFingerprint) bool {
+	switch e.Kind {
+	case BlockSHA256:
+		return fp.SHA256 == e.Hash
+	case BlockPHash:
+		return fp.PHash != "" && phashDistance(fp.PHash, e.Hash) <= PHashMaxDistance
+	}
+	return false
+}
+
+// blocklistStore хранит записи администратора в StatePath/BlocklistStateFile
+// и записи из BlocklistFile, которые только читаются
+type blocklistStore struct {
+	mu          sync.Mutex
+	loaded      bool
+	entries     []*BlockEntry
+	fileEntries []*BlockEntry
+}
+
+var blocklist = &blocklistStore{}
+
+// load лениво читает записи администратора с диска (вызывается под мьютексом)
+func (s *blocklistStore) load() error {
+	if s.loaded {
+		return nil
+	}
+	if err := loadState(BlocklistStateFile, &s.entries); err != nil {
+		return err
+	}
+	for _, entry := range s.entries {
+		entry.Source = BlockSourceAdmin
+	}
+	s.loaded = true
+	return nil
+}
+
+// save записывает записи администратора на диск (вызывается под мьютексом)
+func (s *blocklistStore) save() error {
+	return saveState(BlocklistStateFile, s.entries)
+}
+
+// LoadFile перечитывает BlocklistFile: по хешу в строке, после хеша может идти заметка,
+// строки с # пропускаются
+func (s *blocklistStore) LoadFile() error {
+	if BlocklistFile == "" {
+		return nil
+	}
+	file, err := os.Open(BlocklistFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var entries []*BlockEntry
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		value, note, _ := strings.Cut(text, " ")
+		kind, hash, err := parseBlockHash(value)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", BlocklistFile, line, err)
+		}
+		entries = append(entries, &BlockEntry{
+			ID:     fmt.Sprintf("file:%d", line),
+			Kind:   kind,
+			Hash:   hash,
+			Note:   strings.TrimSpace(note),
+			Source: BlockSourceFile,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fileEntries = entries
+	return nil
+}
+
+// Match возвращает первую запись, с которой совпал отпечаток, или nil
+func (s *blocklistStore) Match(fp imageFingerprint) (*BlockEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	for _, entry := range append(slices.Clip(s.entries), s.fileEntries...) {
+		if entry.matches(fp) {
+			copied := *entry
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+// List возвращает записи администратора и записи из файла
+func (s *blocklistStore) List() ([]BlockEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	list := make([]BlockEntry, 0, len(s.entries)+len(s.fileEntries))
+	for _, entry := range append(slices.Clip(s.entries), s.fileEntries...) {
+		list = append(list, *entry)
+	}
+	return list, nil
+}
+
+// Add добавляет хеш; уже заблокированный хеш не дублируется
+func (s *blocklistStore) Add(kind, hash, note string) (*BlockEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	for _, entry := range s.entries {
+		if entry.Kind == kind && entry.Hash == hash {
+			return entry, nil
+		}
+	}
+
+	id, err := RandomToken(8)
+	if err != nil {
+		return nil, err
+	}
+	entry := &BlockEntry{
+		ID:        id,
+		Kind:      kind,
+		Hash:      hash,
+		Note:      note,
+		Source:    BlockSourceAdmin,
+		CreatedAt: time.Now(),
+	}
+	s.entries = append(s.entries, entry)
+	if err := s.save(); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Remove удаляет запись администратора; записи из файла меняются только в файле
+func (s *blocklistStore) Remove(id string) (*BlockEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	index := slices.IndexFunc(s.entries, func(entry *BlockEntry) bool { return entry.ID == id })
+	if index < 0 {
+		return nil, nil
+	}
+	entry := s.entries[index]
+	s.entries = slices.Delete(s.entries, index, index+1)
+	return entry, s.save()
+}
+
+// blockStoredImage добавляет в блоклист хеши загруженного изображения
+func blockStoredImage(sessionID, albumID, filename, note string) error {
+	path, err := resolveStoragePath(sessionID, albumID, filename)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	fp, err := fingerprintImage(file)
+	if err != nil {
+		return err
+	}
+
+	hashes := map[string]string{BlockSHA256: fp.SHA256, BlockPHash: fp.PHash}
+	for _, kind := range []string{BlockSHA256, BlockPHash} {
+		if hashes[kind] == "" {
+			continue
+		}
+		entry, err := blocklist.Add(kind, hashes[kind], note)
+		if err != nil {
+			return err
+		}
+		recordAudit(AuditEvent{
+			Event:     AuditBlocklistAdd,
+			SessionID: sessionID,
+			AlbumID:   albumID,
+			Filename:  filename,
+			Detail:    fmt.Sprintf("%s entry %s", entry.Kind, entry.ID),
+		})
+	}
+	return nil
+}
+
+// checkBlocklist сверяет загрузку с блоклистом. Совпадение записывается в журнал аудита,
+// а клиент получает ErrContentRejected без подробностей.
+func checkBlocklist(fp imageFingerprint, originalName, userID, albumID string) error {
+	entry, err := blocklist.Match(fp)
+	if err != nil || entry == nil {
+		return err
+	}
+
+	recordAudit(AuditEvent{
+		Event:        AuditUploadBlocked,
+		SessionID:    userID,
+		AlbumID:      albumID,
+		OriginalName: originalName,
+		Detail:       fmt.Sprintf("%s %s matched %s entry %s", BlockSHA256, fp.SHA256, entry.Kind, entry.ID),
+	})
+	logger.Info(fmt.Sprintf("Upload to %s/%s blocked by blocklist entry %s", userID, albumID, entry.ID))
+	return ErrContentRejected
+}
+
+// RescanStatus - состояние повторной проверки загруженного по блоклисту
+type RescanStatus struct {
+	Running  bool
+	Started  time.Time
+	Finished time.Time
+	Scanned  int
+	Matched  int
+	Error    string
+}
+
+var (
+	rescanStatus   RescanStatus
+	rescanStatusMu sync.Mutex
+)
+
+// getRescanStatus возвращает копию состояния повторной проверки
+func getRescanStatus() RescanStatus {
+	rescanStatusMu.Lock()
+	defer rescanStatusMu.Unlock()
+	return rescanStatus
+}
+
+// startRescan запускает повторную проверку в фоне; false, если она уже идет
+func startRescan() bool {
+	rescanStatusMu.Lock()
+	defer rescanStatusMu.Unlock()
+	if rescanStatus.Running {
+		return false
+	}
+	rescanStatus = RescanStatus{Running: true, Started: time.Now()}
+	go rescanStorage()
+	return true
+}
+
+// rescanStorage перечитывает BlocklistFile и сверяет с блоклистом все загруженные изображения.
+// Совпавшие скрываются через очередь модерации, решение по ним принимает администратор.
+func rescanStorage() {
+	scanned, matched, err := 0, 0, blocklist.LoadFile()
+	if err == nil {
+		err = forEachStoredImage(func(img ImageInfo, meta ImageMeta) error {
+			scanned++
+			fp, err := storedFingerprint(img, meta)
+			if err != nil {
+				logger.Error(fmt.Sprintf("rescanStorage: %s: %v", img.Path, err))
+				return nil
+			}
+			entry, err := blocklist.Match(fp)
+			if err != nil || entry == nil {
+				return err
+			}
+
+			matched++
+			recordAudit(AuditEvent{
+				Event:        AuditRescanMatch,
+				SessionID:    img.UserID,
+				AlbumID:      img.AlbumID,
+				Filename:     img.Filename,
+				OriginalName: meta.OriginalName,
+				Detail:       fmt.Sprintf("matched %s entry %s", entry.Kind, entry.ID),
+			})
+			return moderation.Flag(img.UserID, img.AlbumID, img.Filename, Report{
+				Reason:    ReportReasonBlocklist,
+				Details:   strings.TrimSpace(entry.Kind + " " + entry.ID + " " + entry.Note),
+				CreatedAt: time.Now(),
+			})
+		})
+	}
+
+	rescanStatusMu.Lock()
+	defer rescanStatusMu.Unlock()
+	rescanStatus.Running = false
+	rescanStatus.Finished = time.Now()
+	rescanStatus.Scanned = scanned
+	rescanStatus.Matched = matched
+	if err != nil {
+		rescanStatus.Error = err.Error()
+		logger.Error(fmt.Sprintf("Blocklist rescan failed: %v", err))
+		return
+	}
+	logger.Info(fmt.Sprintf("Blocklist rescan: %d images checked, %d matched", scanned, matched))
+}
+
+// storedFingerprint берет хеши из метаданных, а для изображений, загруженных до блоклиста,
+// считает их и сохраняет
+func storedFingerprint(img ImageInfo, meta ImageMeta) (imageFingerprint, error) {
+	if meta.SHA256 != "" {
+		return imageFingerprint{SHA256: meta.SHA256, PHash: meta.PHash}, nil
+	}
+
+	file, err := os.Open(img.Path)
+	if err != nil {
+		return imageFingerprint{}, err
+	}
+	defer file.Close()
+	fp, err := fingerprintImage(file)
+	if err != nil {
+		return fp, err
+	}
+
+	if err := updateAlbumMeta(img.UserID, img.AlbumID, func(meta *AlbumMeta) {
+		if imageMeta, ok := meta.Images[img.Filename]; ok {
+			imageMeta.SHA256, imageMeta.PHash = fp.SHA256, fp.PHash
+			meta.Images[img.Filename] = imageMeta
+		}
+	}); err != nil {
+		logger.Error(fmt.Sprintf("storedFingerprint: failed to save hashes for %s: %v", img.Path, err))
+	}
+	return fp, nil
+}
+
+// forEachStoredImage обходит все изображения всех сессий
+func forEachStoredImage(fn func(img ImageInfo, meta ImageMeta) error) error {
+	entries, err := os.ReadDir(DataPath)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || isHiddenName(entry.Name()) || !IsValidID(entry.Name()) {
+			continue
+		}
+		albums, err := getUserAlbums(entry.Name())
+		if err != nil {
+			return err
+		}
+		for _, album := range albums {
+			meta, err := loadAlbumMeta(entry.Name(), album.ID)
+			if err != nil {
+				return err
+			}
+			images, err := getUserImages(entry.Name(), album.ID)
+			if err != nil {
+				return err
+			}
+			for _, img := range images {
+				if err := fn(img, meta.Images[img.Filename]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -4,13 +4,36 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 )
 
+// CleanupStatus - состояние фоновой очистки для админки
+type CleanupStatus struct {
+	LastRun      time.Time
+	LastDuration time.Duration
+	LastError    string
+	NextRun      time.Time
+}
+
+var (
+	cleanupStatus   CleanupStatus
+	cleanupStatusMu sync.Mutex
+)
+
+// getCleanupStatus возвращает копию состояния очистки
+func getCleanupStatus() CleanupStatus {
+	cleanupStatusMu.Lock()
+	defer cleanupStatusMu.Unlock()
+	return cleanupStatus
+}
+
 // startCleanupWorker запускает фоновый процесс очистки старых изображений
 func startCleanupWorker(ctx context.Context) {
 	ticker := time.NewTicker(CleanupInterval)
 	defer ticker.Stop()
+	setNextCleanup(time.Now().Add(CleanupInterval))
 
 	for {
 		select {
@@ -18,19 +41,51 @@ func startCleanupWorker(ctx context.Context) {
 			return // Graceful shutdown
 		case <-ticker.C:
 			performCleanup()
+			setNextCleanup(time.Now().Add(CleanupInterval))
+		case <-cleanupRequests:
+			// Места на диске мало: обычная очистка раньше срока, затем вытеснение старых изображений
+			performCleanup()
 		}
 	}
 }
 
+// setNextCleanup запоминает время следующего прохода очистки
+func setNextCleanup(next time.Time) {
+	cleanupStatusMu.Lock()
+	defer cleanupStatusMu.Unlock()
+	cleanupStatus.NextRun = next
+}
+
 // performCleanup выполняет очистку
 func performCleanup() {
-	if err := cleanupOldImages(); err != nil {
+	started := time.Now()
+	var errs []string
+
+	if RetentionPolicy == RetentionBySize {
+		if err := enforceStorageBudget(); err != nil {
+			logger.Error("Failed to enforce storage budget: " + err.Error())
+			errs = append(errs, err.Error())
+		}
+	} else if err := cleanupOldImages(); err != nil {
 		logger.Error("Failed to cleanup old images: " + err.Error())
+		errs = append(errs, err.Error())
+	}
+
+	if err := evictForDiskSpace(); err != nil {
+		logger.Error("Failed to free disk space: " + err.Error())
+		errs = append(errs, err.Error())
 	}
 
 	if err := removeEmptyDirectories(); err != nil {
 		logger.Error("Failed to remove empty directories: " + err.Error())
+		errs = append(errs, err.Error())
 	}
+
+	cleanupStatusMu.Lock()
+	defer cleanupStatusMu.Unlock()
+	cleanupStatus.LastRun = started
+	cleanupStatus.LastDuration = time.Since(started)
+	cleanupStatus.LastError = strings.Join(errs, "; ")
 }
 
 // cleanupOldImages удаляет старые изображения
@@ -46,13 +101,20 @@ func cleanupOldImages() error {
 		return err
 	}
 
+	// Скрытые модератором изображения хранятся до решения по жалобе
+	quarantined, err := moderation.QuarantinedPaths()
+	if err != nil {
+		return err
+	}
+
 	for _, entry := range entries {
-		if !entry.IsDir() {
+		// Служебная директория сервера (StatePath) не относится к пользователям
+		if !entry.IsDir() || isHiddenName(entry.Name()) {
 			continue
 		}
 
 		userDir := filepath.Join(DataPath, entry.Name())
-		if err := cleanupUserImages(userDir); err != nil {
+		if err := cleanupUserImages(userDir, quarantined); err != nil {
 			logger.Error("Failed to cleanup user images in " + userDir + ": " + err.Error())
 		}
 	}
@@ -60,18 +122,43 @@ func cleanupOldImages() error {
 	return nil
 }
 
-// cleanupUserImages очищает старые изображения в директории пользователя
-func cleanupUserImages(userDir string) error {
+// cleanupUserImages очищает старые изображения в альбомах пользователя, кроме путей из keep
+func cleanupUserImages(userDir string, keep map[string]bool) error {
 	return processDir(userDir, func(entry os.DirEntry) bool {
-		return !entry.IsDir()
+		return entry.IsDir() && !isHiddenName(entry.Name())
+	}, func(albumDir string, info os.FileInfo) error {
+		cleanupAlbumImages(albumDir, keep)
+		return nil
+	})
+}
+
+// cleanupAlbumImages удаляет старые изображения альбома. Служебные файлы не трогаются:
+// метаданные (приватность, ключи удаления, закрепление) удаляются только вместе с последним
+// изображением, иначе альбом со скрытым до решения по жалобе файлом стал бы открытым.
+func cleanupAlbumImages(albumDir string, keep map[string]bool) {
+	var removed []string
+	processDir(albumDir, func(entry os.DirEntry) bool {
+		return !entry.IsDir() && IsImageFile(entry.Name())
 	}, func(filePath string, info os.FileInfo) error {
-		if isImageOld(info.ModTime()) {
-			if err := os.Remove(filePath); err != nil {
-				logger.Error("Failed to remove old image " + filePath + ": " + err.Error())
-			}
+		if !isImageOld(info.ModTime()) || keep[filePath] {
+			return nil
+		}
+		if err := os.Remove(filePath); err != nil {
+			logger.Error("Failed to remove old image " + filePath + ": " + err.Error())
+			return nil
 		}
+		TotalImageCount.Add(-1)
+		removed = append(removed, info.Name())
 		return nil
 	})
+	if len(removed) == 0 {
+		return
+	}
+
+	sessionID, albumID := filepath.Base(filepath.Dir(albumDir)), filepath.Base(albumDir)
+	if err := pruneAlbumMeta(sessionID, albumID, removed); err != nil {
+		logger.Error("Failed to update metadata of " + albumDir + ": " + err.Error())
+	}
 }
 
 // removeEmptyDirectories удаляет пустые директории
@@ -88,7 +175,8 @@ func removeEmptyDirectories() error {
 	}
 
 	for _, entry := range entries {
-		if !entry.IsDir() {
+		// Служебная директория сервера (StatePath) не относится к пользователям
+		if !entry.IsDir() || isHiddenName(entry.Name()) {
 			continue
 		}
 
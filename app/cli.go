@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"ripx/client"
+)
+
+// CLIConfig - настройки консольного клиента из файла конфигурации
+type CLIConfig struct {
+	Server string `json:"server"`
+	Token  string `json:"token"`
+	Format string `json:"format"`
+}
+
+// uploadResult - итог загрузки одного файла
+type uploadResult struct {
+	Path        string `json:"file"`
+	Name        string `json:"name"`
+	URL         string `json:"url,omitempty"`
+	DeletionURL string `json:"deletion_url,omitempty"`
+	Filename    string `json:"filename,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// runUploadCommand выполняет `ripx upload [флаги] файлы...` и возвращает код выхода
+func runUploadCommand(args []string) int {
+	flags := flag.NewFlagSet("upload", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: ripx upload [flags] <file|glob|dir>...")
+		flags.PrintDefaults()
+	}
+	configPath := flags.String("config", "", "config file (default "+defaultCLIConfigPath()+")")
+	server := flags.String("server", "", "server URL, overrides the config file")
+	token := flags.String("token", "", "API token, overrides the config file")
+	albumID := flags.String("album", "", "upload into an existing album ID instead of creating one")
+	albumName := flags.String("name", "", "name of the new album")
+	private := flags.Bool("private", false, "make the new album private")
+	format := flags.String("format", "", "output format: plain, markdown, bbcode or json")
+	parallel := flags.Int("parallel", DefaultUploadParallel, "number of simultaneous uploads")
+	retries := flags.Int("retries", DefaultUploadRetries, "retries per file on network and server errors")
+	noClipboard := flags.Bool("no-clipboard", false, "do not copy the output to the clipboard")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := loadCLIConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ripx: %v\n", err)
+		return 1
+	}
+	if *server != "" {
+		cfg.Server = *server
+	}
+	if *token != "" {
+		cfg.Token = *token
+	}
+	if *format != "" {
+		cfg.Format = *format
+	}
+	if cfg.Format == "" {
+		cfg.Format = "plain"
+	}
+
+	switch {
+	case cfg.Server == "":
+		fmt.Fprintln(os.Stderr, "ripx: server URL is not set, use -server or the config file")
+		return 2
+	case cfg.Token == "":
+		fmt.Fprintln(os.Stderr, "ripx: no API token, use -token or the config file")
+		return 2
+	case !isValidOutputFormat(cfg.Format):
+		fmt.Fprintf(os.Stderr, "ripx: unknown format %q\n", cfg.Format)
+		return 2
+	case *albumID != "" && !IsValidID(*albumID):
+		fmt.Fprintf(os.Stderr, "ripx: invalid album ID %q\n", *albumID)
+		return 2
+	case *parallel < 1 || *retries < 0:
+		fmt.Fprintln(os.Stderr, "ripx: -parallel must be positive and -retries non-negative")
+		return 2
+	}
+
+	paths, err := expandUploadArgs(flags.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ripx: %v\n", err)
+		return 1
+	}
+	if len(paths) == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	// Файлы, которые сервер все равно отклонит, отсекаем до загрузки
+	results := make([]uploadResult, len(paths))
+	var valid []int
+	for i, path := range paths {
+		results[i] = uploadResult{Path: path, Name: filepath.Base(path)}
+		if err := validateLocalImage(path); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		valid = append(valid, i)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	c := client.New(cfg.Server, client.WithToken(cfg.Token))
+
+	if len(valid) > 0 {
+		album := *albumID
+		if album == "" {
+			params := client.AlbumUpdate{}
+			if *albumName != "" {
+				params.Name = albumName
+			}
+			if *private {
+				params.Private = private
+			}
+			created, err := c.CreateAlbum(ctx, params)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ripx: creating album: %v\n", err)
+				return 1
+			}
+			album = created.ID
+			fmt.Fprintf(os.Stderr, "album: %s\n", created.URL)
+		}
+
+		uploadFiles(ctx, c, album, results, valid, *parallel, *retries)
+	}
+
+	output := formatUploadResults(results, cfg.Format)
+	fmt.Print(output)
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+			if cfg.Format != "json" {
+				fmt.Fprintf(os.Stderr, "failed %s: %s\n", result.Path, result.Error)
+			}
+		}
+	}
+
+	if !*noClipboard && failed < len(results) {
+		if err := copyToClipboard(output); err != nil {
+			logger.Debug("clipboard: " + err.Error())
+		}
+	}
+
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// defaultCLIConfigPath возвращает путь к конфигурации клиента по умолчанию
+func defaultCLIConfigPath() string {
+	if path := os.Getenv("RIPX_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return CLIConfigFile
+	}
+	return filepath.Join(dir, CLIConfigFile)
+}
+
+// loadCLIConfig читает конфигурацию клиента; отсутствующий файл по умолчанию не ошибка
+func loadCLIConfig(path string) (CLIConfig, error) {
+	var cfg CLIConfig
+	explicit := path != ""
+	if !explicit {
+		path = defaultCLIConfigPath()
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// expandUploadArgs раскрывает шаблоны и директории в список файлов
+func expandUploadArgs(args []string) ([]string, error) {
+	var paths []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	for _, arg := range args {
+		matches := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			var err error
+			matches, err = filepath.Glob(arg)
+			if err != nil {
+				return nil, fmt.Errorf("bad pattern %q: %w", arg, err)
+			}
+			matches = slices.DeleteFunc(matches, func(match string) bool { return isHiddenGlobMatch(arg, match) })
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %q", arg)
+			}
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				add(match)
+				continue
+			}
+
+			// Из директорий берем все файлы, кроме скрытых
+			err = filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if path != match && isHiddenName(d.Name()) {
+					if d.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if d.Type().IsRegular() {
+					add(path)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return paths, nil
+}
+
+// isHiddenGlobMatch сообщает, что шаблон подставил скрытый элемент пути, который сам явно не называл.
+// Такие совпадения пропускаются, как в shell и при обходе директорий
+func isHiddenGlobMatch(pattern, match string) bool {
+	patternParts := strings.Split(filepath.ToSlash(pattern), "/")
+	matchParts := strings.Split(filepath.ToSlash(match), "/")
+	// Glob сохраняет элементы пути после первого шаблона, поэтому сравниваем с конца
+	for i := 1; i <= min(len(patternParts), len(matchParts)); i++ {
+		part := patternParts[len(patternParts)-i]
+		if strings.ContainsAny(part, "*?[") && !isHiddenName(part) && isHiddenName(matchParts[len(matchParts)-i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateLocalImage проверяет файл теми же правилами, что и сервер при загрузке
+func validateLocalImage(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() > MaxFileSize {
+		return ErrFileTooLarge
+	}
+	if _, ok := validateImageType(file); !ok {
+		return ErrInvalidImageType
+	}
+	return nil
+}
+
+// uploadFiles загружает файлы с индексами indexes в parallel потоков
+func uploadFiles(ctx context.Context, c *client.Client, albumID string, results []uploadResult, indexes []int, parallel, retries int) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(parallel, len(indexes)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				img, err := uploadWithRetry(ctx, c, albumID, results[i].Path, retries)
+				if err != nil {
+					results[i].Error = err.Error()
+					continue
+				}
+				results[i].URL = img.URL
+				results[i].DeletionURL = img.DeletionURL
+				results[i].Filename = img.Filename
+				fmt.Fprintf(os.Stderr, "uploaded %s\n", results[i].Path)
+			}
+		}()
+	}
+
+	for _, i := range indexes {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// uploadWithRetry повторяет загрузку при сетевых ошибках и ошибках сервера 5xx/429
+func uploadWithRetry(ctx context.Context, c *client.Client, albumID, path string, retries int) (*client.Image, error) {
+	for attempt := 0; ; attempt++ {
+		img, err := c.UploadFile(ctx, albumID, path, nil)
+		if err == nil || attempt >= retries || !isRetryableUploadError(err) {
+			return img, err
+		}
+
+		delay := time.Duration(attempt+1) * time.Second
+		logger.Debug(fmt.Sprintf("upload %s failed, retrying in %s: %v", path, delay, err))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// isRetryableUploadError отличает временные сбои от отказов, которые повтор не исправит
+func isRetryableUploadError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var apiErr *client.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 500 || apiErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// isValidOutputFormat проверяет формат вывода
+func isValidOutputFormat(format string) bool {
+	switch format {
+	case "plain", "markdown", "bbcode", "json":
+		return true
+	}
+	return false
+}
+
+// formatUploadResults выводит ссылки на загруженные файлы в выбранном формате
+func formatUploadResults(results []uploadResult, format string) string {
+	if format == "json" {
+		data, _ := json.MarshalIndent(results, "", "  ")
+		return string(data) + "\n"
+	}
+
+	var b strings.Builder
+	for _, result := range results {
+		if result.URL == "" {
+			continue
+		}
+		switch format {
+		case "markdown":
+			fmt.Fprintf(&b, "![%s](%s)\n", result.Name, result.URL)
+		case "bbcode":
+			fmt.Fprintf(&b, "[img]%s[/img]\n", result.URL)
+		default:
+			fmt.Fprintln(&b, result.URL)
+		}
+	}
+	return b.String()
+}
+
+// copyToClipboard копирует текст в буфер обмена первой найденной утилитой
+func copyToClipboard(text string) error {
+	var candidates [][]string
+	switch runtime.GOOS {
+	case "darwin":
+		candidates = [][]string{{"pbcopy"}}
+	case "windows":
+		candidates = [][]string{{"clip"}}
+	default:
+		candidates = [][]string{
+			{"wl-copy"},
+			{"xclip", "-selection", "clipboard"},
+			{"xsel", "--clipboard", "--input"},
+		}
+	}
+
+	for _, candidate := range candidates {
+		if _, err := exec.LookPath(candidate[0]); err != nil {
+			continue
+		}
+		cmd := exec.Command(candidate[0], candidate[1:]...)
+		cmd.Stdin = bytes.NewBufferString(text)
+		cmd.Stderr = io.Discard
+		return cmd.Run()
+	}
+	return errors.New("no clipboard utility found")
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"ripx/client"
+)
+
+// writeTestFiles создает файлы в dir; пути с "/" создают поддиректории
+func writeTestFiles(t *testing.T, dir string, files map[string][]byte) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// captureStdout выполняет fn и возвращает то, что она напечатала в stdout
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = old }()
+
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+	fn()
+	w.Close()
+	return <-done
+}
+
+func TestRunUploadCommandValidation(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string][]byte{
+		"empty.json":  []byte("{}"),
+		"broken.json": []byte("{"),
+		"photo.png":   testPNG(t, 50),
+	})
+	t.Setenv("RIPX_CONFIG", filepath.Join(dir, "missing.json"))
+	config := filepath.Join(dir, "empty.json")
+	photo := filepath.Join(dir, "photo.png")
+	server := []string{"-config", config, "-server", "http://127.0.0.1:1", "-token", "ripx_x", "-no-clipboard"}
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"unknown flag", []string{"-bogus"}, 2},
+		{"no server", []string{"-config", config, "-token", "ripx_x", photo}, 2},
+		{"no token", []string{"-config", config, "-server", "http://127.0.0.1:1", photo}, 2},
+		{"unknown format", append(slices.Clone(server), "-format", "html", photo), 2},
+		{"invalid album", append(slices.Clone(server), "-album", "../x", photo), 2},
+		{"zero parallel", append(slices.Clone(server), "-parallel", "0", photo), 2},
+		{"negative retries", append(slices.Clone(server), "-retries", "-1", photo), 2},
+		{"no files", server, 2},
+		{"missing explicit config", []string{"-config", filepath.Join(dir, "missing.json"), photo}, 1},
+		{"broken config", []string{"-config", filepath.Join(dir, "broken.json"), photo}, 1},
+		{"missing file", append(slices.Clone(server), filepath.Join(dir, "nope.png")), 1},
+		{"no glob match", append(slices.Clone(server), filepath.Join(dir, "*.gif")), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := runUploadCommand(tt.args); code != tt.want {
+				t.Errorf("runUploadCommand(%q) = %d, want %d", tt.args, code, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandUploadArgs(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string][]byte{
+		"a.png":        nil,
+		"b.jpg":        nil,
+		"notes.txt":    nil,
+		".hidden.png":  nil,
+		"sub/c.png":    nil,
+		".git/d.png":   nil,
+		"sub/.e.png":   nil,
+		"other/f.webp": nil,
+	})
+	at := func(names ...string) []string {
+		var paths []string
+		for _, name := range names {
+			paths = append(paths, filepath.Join(dir, filepath.FromSlash(name)))
+		}
+		return paths
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+		err  bool
+	}{
+		{"file", at("a.png"), at("a.png"), false},
+		{"directory skips hidden entries", at("sub"), at("sub/c.png"), false},
+		{"whole tree", []string{dir}, at("a.png", "b.jpg", "notes.txt", "other/f.webp", "sub/c.png"), false},
+		{"glob", at("*.jpg"), at("b.jpg"), false},
+		{"glob skips hidden files", at("*.png"), at("a.png"), false},
+		{"glob over directories", at("*/*.png"), at("sub/c.png"), false},
+		{"glob naming hidden files", at(".*.png"), at(".hidden.png"), false},
+		{"duplicates once", append(at("a.png"), dir), at("a.png", "b.jpg", "notes.txt", "other/f.webp", "sub/c.png"), false},
+		{"no match", at("*.gif"), nil, true},
+		{"bad pattern", at("[a.png"), nil, true},
+		{"missing file", at("missing.png"), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandUploadArgs(tt.args)
+			if (err != nil) != tt.err {
+				t.Fatalf("expandUploadArgs(%q) error = %v, want error %v", tt.args, err, tt.err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expandUploadArgs(%q) = %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunUploadCommand(t *testing.T) {
+	owner := newTestClient(t)
+	token, err := owner.CreateToken(context.Background(), client.TokenCreate{Name: "cli", Scopes: []string{client.ScopeUpload}})
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string][]byte{
+		"one.png":  testPNG(t, 51),
+		"two.png":  testPNG(t, 52),
+		"fake.png": []byte("not an image"),
+	})
+	t.Setenv("RIPX_CONFIG", filepath.Join(dir, "missing.json"))
+
+	var code int
+	output := captureStdout(t, func() {
+		code = runUploadCommand([]string{"-server", testServer.URL, "-token", token.Secret,
+			"-name", "from cli", "-format", "json", "-no-clipboard", "-retries", "0", dir})
+	})
+	// Один файл отклонен локально, поэтому код выхода 1
+	if code != 1 {
+		t.Errorf("exit code %d, want 1", code)
+	}
+
+	var results []uploadResult
+	if err := json.Unmarshal([]byte(output), &results); err != nil {
+		t.Fatalf("invalid JSON output %q: %v", output, err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3: %+v", len(results), results)
+	}
+	for _, result := range results {
+		switch result.Name {
+		case "fake.png":
+			if result.Error != ErrInvalidImageType.Error() || result.URL != "" {
+				t.Errorf("fake.png: %+v, want a local rejection", result)
+			}
+		default:
+			if result.Error != "" || result.DeletionURL == "" || !strings.HasPrefix(result.URL, testServer.URL+"/"+owner.SessionID()+"/") {
+				t.Errorf("%s: %+v, want an uploaded image", result.Name, result)
+				continue
+			}
+			resp, err := http.Get(result.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("GET %s: %d", result.URL, resp.StatusCode)
+			}
+		}
+	}
+
+	albums, _, err := owner.ListAlbums(context.Background(), 1, 100)
+	if err != nil {
+		t.Fatalf("ListAlbums: %v", err)
+	}
+	if len(albums) != 1 || albums[0].Name != "from cli" || albums[0].ImageCount != 2 {
+		t.Errorf("albums after upload: %+v", albums)
+	}
+}
+
+func TestFormatUploadResults(t *testing.T) {
+	results := []uploadResult{
+		{Name: "a.png", URL: "http://host/s/a/1.png"},
+		{Name: "b.png", Error: "failed"},
+	}
+	tests := map[string]string{
+		"plain":    "http://host/s/a/1.png\n",
+		"markdown": "![a.png](http://host/s/a/1.png)\n",
+		"bbcode":   "[img]http://host/s/a/1.png[/img]\n",
+	}
+	for format, want := range tests {
+		if got := formatUploadResults(results, format); got != want {
+			t.Errorf("format %s: %q, want %q", format, got, want)
+		}
+	}
+}
+
+func TestIsRetryableUploadError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("connection reset"), true},
+		{&client.Error{StatusCode: http.StatusBadGateway}, true},
+		{&client.Error{StatusCode: http.StatusTooManyRequests}, true},
+		{&client.Error{StatusCode: http.StatusUnsupportedMediaType}, false},
+		{&client.Error{StatusCode: http.StatusUnauthorized}, false},
+		{fmt.Errorf("upload: %w", context.Canceled), false},
+	}
+	for _, tt := range tests {
+		if got := isRetryableUploadError(tt.err); got != tt.want {
+			t.Errorf("isRetryableUploadError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ripx/client"
+)
+
+// newTestClient создает клиент с новой сессией на testServer
+func newTestClient(t *testing.T) *client.Client {
+	t.Helper()
+	c := client.New(testServer.URL)
+	if _, err := c.CreateSession(context.Background()); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	return c
+}
+
+func TestClientAlbumLifecycle(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient(t)
+
+	album, err := c.CreateAlbum(ctx, client.AlbumUpdate{Name: client.String("trip")})
+	if err != nil {
+		t.Fatalf("CreateAlbum: %v", err)
+	}
+	if album.Name != "trip" || album.SessionID != c.SessionID() {
+		t.Fatalf("CreateAlbum returned %+v", album)
+	}
+
+	album, err = c.UpdateAlbum(ctx, album.ID, client.AlbumUpdate{Name: client.String("trip 2024")})
+	if err != nil || album.Name != "trip 2024" {
+		t.Fatalf("UpdateAlbum: %+v, %v", album, err)
+	}
+
+	// Upload с известным размером сообщает о прогрессе до полного объема
+	content := testPNG(t, 4)
+	var calls int
+	var lastSent, lastTotal int64
+	img, err := c.Upload(ctx, album.ID, "first.png", bytes.NewReader(content), int64(len(content)), func(sent, total int64) {
+		calls++
+		lastSent, lastTotal = sent, total
+	})
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if calls == 0 || lastSent != int64(len(content)) || lastTotal != int64(len(content)) {
+		t.Errorf("progress: %d calls, last %d/%d, want %d", calls, lastSent, lastTotal, len(content))
+	}
+	if img.OriginalName != "first.png" || img.Size != int64(len(content)) || img.DeletionURL == "" {
+		t.Errorf("Upload returned %+v", img)
+	}
+
+	// UploadFile берет имя с диска
+	path := filepath.Join(t.TempDir(), "second.png")
+	if err := os.WriteFile(path, testPNG(t, 5), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.UploadFile(ctx, album.ID, path, nil); err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+
+	albums, pagination, err := c.ListAlbums(ctx, 0, 0)
+	if err != nil || len(albums) != 1 || albums[0].ImageCount != 2 || pagination.Total != 1 {
+		t.Fatalf("ListAlbums: %+v, %+v, %v", albums, pagination, err)
+	}
+
+	// Изображения возвращаются в порядке загрузки, по одному на страницу
+	images, pagination, err := c.ListImages(ctx, album.ID, 1, 1)
+	if err != nil {
+		t.Fatalf("ListImages: %v", err)
+	}
+	if len(images) != 1 || images[0].Filename != img.Filename || pagination.Total != 2 || pagination.TotalPages != 2 {
+		t.Fatalf("ListImages page 1: %+v, %+v", images, pagination)
+	}
+
+	renamed, err := c.RenameImage(ctx, album.ID, img.Filename, "renamed.png")
+	if err != nil || renamed.OriginalName != "renamed.png" {
+		t.Fatalf("RenameImage: %+v, %v", renamed, err)
+	}
+
+	if err := c.DeleteImage(ctx, album.ID, img.Filename); err != nil {
+		t.Fatalf("DeleteImage: %v", err)
+	}
+	if _, err := c.GetImage(ctx, album.ID, img.Filename); !errors.Is(err, client.ErrImageNotFound) {
+		t.Errorf("GetImage after delete: %v, want ErrImageNotFound", err)
+	}
+
+	if err := c.DeleteAlbum(ctx, album.ID); err != nil {
+		t.Fatalf("DeleteAlbum: %v", err)
+	}
+	if _, err := c.GetAlbum(ctx, album.ID); !errors.Is(err, client.ErrAlbumNotFound) {
+		t.Errorf("GetAlbum after delete: %v, want ErrAlbumNotFound", err)
+	}
+
+	if err := c.DeleteSession(ctx); err != nil {
+		t.Fatalf("DeleteSession: %v", err)
+	}
+}
+
+func TestClientTokenAuth(t *testing.T) {
+	ctx := context.Background()
+	owner := newTestClient(t)
+	album, err := owner.CreateAlbum(ctx, client.AlbumUpdate{})
+	if err != nil {
+		t.Fatalf("CreateAlbum: %v", err)
+	}
+
+	token, err := owner.CreateToken(ctx, client.TokenCreate{Name: "ci", Scopes: []string{client.ScopeUpload}})
+	if err != nil || token.Secret == "" {
+		t.Fatalf("CreateToken: %+v, %v", token, err)
+	}
+
+	// Клиент с одним токеном узнает свою сессию у сервера
+	bot := client.New(testServer.URL, client.WithToken(token.Secret))
+	content := testPNG(t, 6)
+	if _, err := bot.Upload(ctx, album.ID, "bot.png", bytes.NewReader(content), -1, nil); err != nil {
+		t.Fatalf("Upload with scoped token: %v", err)
+	}
+	if bot.SessionID() != owner.SessionID() {
+		t.Errorf("token client session %q, want %q", bot.SessionID(), owner.SessionID())
+	}
+
+	// Без области delete удаление запрещено, а управлять токенами может только владелец
+	if err := bot.DeleteAlbum(ctx, album.ID); !errors.Is(err, client.ErrInsufficientScope) {
+		t.Errorf("DeleteAlbum with upload token: %v, want ErrInsufficientScope", err)
+	}
+	if _, err := bot.ListTokens(ctx); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("ListTokens with upload token: %v, want ErrForbidden", err)
+	}
+
+	if err := owner.RevokeToken(ctx, token.ID); err != nil {
+		t.Fatalf("RevokeToken: %v", err)
+	}
+	_, err = bot.CurrentSession(ctx)
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized || !errors.Is(err, client.ErrInvalidToken) {
+		t.Errorf("CurrentSession with revoked token: %v, want 401 invalid_token", err)
+	}
+}
+
+func TestClientTypedErrors(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient(t)
+	album, err := c.CreateAlbum(ctx, client.AlbumUpdate{})
+	if err != nil {
+		t.Fatalf("CreateAlbum: %v", err)
+	}
+
+	_, err = c.Upload(ctx, album.ID, "notes.txt", bytes.NewReader([]byte("plain text")), -1, nil)
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnsupportedMediaType || !errors.Is(err, client.ErrInvalidImageType) {
+		t.Errorf("Upload of text: %v, want 415 invalid_image_type", err)
+	}
+
+	big := bytes.Repeat([]byte{0}, MaxFileSize+1)
+	copy(big, testPNG(t, 7))
+	if _, err := c.Upload(ctx, album.ID, "big.png", bytes.NewReader(big), int64(len(big)), nil); !errors.Is(err, client.ErrFileTooLarge) {
+		t.Errorf("Upload of %d bytes: %v, want ErrFileTooLarge", len(big), err)
+	}
+
+	if _, err := c.GetAlbum(ctx, "zzzzz"); !errors.Is(err, client.ErrAlbumNotFound) {
+		t.Errorf("GetAlbum of unknown album: %v, want ErrAlbumNotFound", err)
+	}
+
+	anonymous := client.New(testServer.URL, client.WithToken("ripx_unknown"))
+	if _, err := anonymous.CurrentSession(ctx); !errors.Is(err, client.ErrInvalidToken) {
+		t.Errorf("CurrentSession with unknown token: %v, want ErrInvalidToken", err)
+	}
+}
@@ -1,15 +1,23 @@
 package main
 
-import "time"
+import (
+	"os"
+	"time"
+)
 
 // Server configuration
 const (
 	ServerAddr = "0.0.0.0:8000"
 )
 
+// Корень хранилища. Переменные, а не константы, чтобы тесты работали во временной директории
+var (
+	DataPath  = "/data"
+	StatePath = DataPath + "/.ripx" // служебные данные сервера (токены и т.п.)
+)
+
 // File system configuration
 const (
-	DataPath      = "/data"
 	TemplatesPath = "templates"
 	StaticPath    = "templates/static"
 	ChangelogPath = "../changelog.md"
@@ -19,6 +27,14 @@ const (
 	MaxFileSize     = 10 * 1024 * 1024 // 10MB
 )
 
+// Archive upload configuration
+const (
+	MaxArchiveSize      = 512 * 1024 * 1024  // 512MB - размер загружаемого архива
+	MaxArchiveTotalSize = 1024 * 1024 * 1024 // 1GB - суммарный распакованный объем
+	MaxArchiveEntries   = 1000               // максимум записей в архиве
+	MaxArchiveRatio     = 100                // максимальная степень сжатия записи ZIP
+)
+
 // MIME types and extensions
 var (
 	AllowedImageTypes = map[string]bool{
@@ -36,14 +52,230 @@ var (
 	}
 )
 
+// API configuration
+const (
+	APIPrefix       = "/api/v1"
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+	MaxAlbumNameLen = 100
+)
+
+// API token configuration
+const (
+	TokenPrefix     = "ripx_"
+	TokensStateFile = "tokens.json"
+)
+
 // Session configuration
 const (
 	SessionCookieName = "session_id"
 	SessionMaxAge     = 86400 * 30 // 30 days
+	SessionIDAttempts = 16         // попыток найти свободный ID новой сессии
+
+	// Заголовки для загрузки без cookie (curl, скрипты)
+	AlbumHeaderName       = "X-Album-ID"
+	DeletionURLHeaderName = "X-Deletion-URL"
+)
+
+// Device linking and recovery configuration
+const (
+	DeviceCookieName  = "ripx_device"
+	OwnerCookieName   = "ripx_owner"
+	DeviceMaxAge      = 86400 * 365 // 1 year
+	SessionsStateFile = "sessions.json"
+	RecoveryCodeLen   = 20               // символов base32, ~100 бит
+	PairingCodeLen    = 8                // символов base32, код живет PairingCodeTTL
+	PairingCodeTTL    = 10 * time.Minute // время жизни кода привязки устройства
+)
+
+// Account configuration. Регистрацию можно выключить переменной RIPX_ACCOUNTS=false,
+// тогда сервер работает только с анонимными сессиями.
+var AccountsEnabled = os.Getenv("RIPX_ACCOUNTS") != "false"
+
+const (
+	AccountsStateFile = "accounts.json"
+	MinUsernameLen    = 3
+	MaxUsernameLen    = 32
+	MinPasswordLen    = 8
+	MaxPasswordLen    = 256
+
+	// Параметры argon2id (рекомендация RFC 9106 для ограниченной памяти)
+	Argon2Time    = 3
+	Argon2Memory  = 64 * 1024 // KiB
+	Argon2Threads = 2
+	Argon2KeyLen  = 32
+	Argon2SaltLen = 16
+
+	// Одновременных вычислений argon2; каждое занимает Argon2Memory, остальные ждут очереди
+	Argon2MaxConcurrent = 4
+)
+
+// OpenID Connect configuration. Вход через провайдера включается переменной RIPX_OIDC_ISSUER;
+// RIPX_REQUIRE_LOGIN=true разрешает загрузку только вошедшим в аккаунт, просмотр остается публичным.
+var (
+	OIDCIssuer        = os.Getenv("RIPX_OIDC_ISSUER")
+	OIDCClientID      = os.Getenv("RIPX_OIDC_CLIENT_ID")
+	OIDCClientSecret  = os.Getenv("RIPX_OIDC_CLIENT_SECRET")
+	OIDCRedirectURL   = os.Getenv("RIPX_OIDC_REDIRECT_URL") // по умолчанию BaseURL + OIDCCallbackPath
+	OIDCScopes        = envOr("RIPX_OIDC_SCOPES", "openid profile email")
+	OIDCGroupsClaim   = envOr("RIPX_OIDC_GROUPS_CLAIM", "groups")
+	OIDCAllowedGroups = splitList(os.Getenv("RIPX_OIDC_ALLOWED_GROUPS")) // пусто - пускать всех
+
+	RequireLogin = os.Getenv("RIPX_REQUIRE_LOGIN") == "true"
+)
+
+// Trusted reverse-proxy authentication. Если задан RIPX_PROXY_AUTH_HEADER, владельца определяет
+// этот заголовок, но только в запросах с адресов из RIPX_TRUSTED_PROXIES (CIDR или IP через запятую).
+// RIPX_PROXY_AUTH_FALLBACK задает поведение без заголовка: anonymous - обычные сессии, deny - 401.
+var (
+	ProxyAuthHeader   = os.Getenv("RIPX_PROXY_AUTH_HEADER")
+	TrustedProxies    = splitList(os.Getenv("RIPX_TRUSTED_PROXIES"))
+	ProxyAuthFallback = envOr("RIPX_PROXY_AUTH_FALLBACK", ProxyFallbackAnonymous)
+)
+
+const (
+	ProxyFallbackAnonymous = "anonymous"
+	ProxyFallbackDeny      = "deny"
+	ProxyAuthIssuer        = "proxy" // Account.Issuer аккаунтов, созданных по заголовку прокси
+	MaxProxyUserLen        = 256
+)
+
+const (
+	OIDCLoginPath    = "/auth/oidc/login"
+	OIDCCallbackPath = "/auth/oidc/callback"
+	OIDCStateCookie  = "ripx_oidc_state"
+	OIDCLoginTTL     = 10 * time.Minute // время на вход у провайдера
+)
+
+// CSRF protection. RIPX_TRUSTED_ORIGINS - дополнительные источники (https://host[:port] через запятую),
+// которым разрешено отправлять изменяющие запросы, например отдельный домен админки.
+var TrustedOrigins = splitList(os.Getenv("RIPX_TRUSTED_ORIGINS"))
+
+const (
+	CSRFStateFile  = "csrf.json"
+	CSRFHeaderName = "X-CSRF-Token"
+	CSRFFormField  = "csrf_token"
+)
+
+// Invite-only mode. С RIPX_INVITE_ONLY=true загружать и создавать альбомы могут только
+// сессии, активировавшие приглашение; просмотр остается публичным.
+var InviteOnly = os.Getenv("RIPX_INVITE_ONLY") == "true"
+
+const (
+	InvitesStateFile = "invites.json"
+	InviteCodeLen    = 12 // символов base32, ~60 бит
+)
+
+// Admin configuration. Администрирование (приглашения и т.п.) доступно по Basic auth
+// с RIPX_ADMIN_USER и RIPX_ADMIN_PASSWORD; без них оно выключено. Имени по умолчанию нет,
+// чтобы для входа нужно было угадывать обе части.
+var (
+	AdminUser     = os.Getenv("RIPX_ADMIN_USER")
+	AdminPassword = os.Getenv("RIPX_ADMIN_PASSWORD")
+)
+
+const (
+	AdminRealm         = "ripx admin"
+	AdminPageSize      = 50          // сессий на странице админки
+	AdminRecentUploads = 24          // последних загрузок на главной странице админки
+	AdminScanCacheTTL  = time.Minute // сколько главная страница админки показывает прошлый обход хранилища
+)
+
+// Moderation configuration. Жалобы посетителей попадают в очередь модерации админки.
+const (
+	ModerationStateFile = "moderation.json"
+	MaxReportDetailsLen = 1000
+	MaxReportsPerItem   = 50 // больше жалоб на одно изображение не записывается
+)
+
+// Content blocklist. Загрузки сверяются по SHA-256 и перцептивному хешу со списком из админки
+// и из файла RIPX_BLOCKLIST_FILE (хеш в строке, затем необязательная заметка; # - комментарий).
+var BlocklistFile = os.Getenv("RIPX_BLOCKLIST_FILE")
+
+const (
+	BlocklistStateFile = "blocklist.json"
+	AuditLogFile       = "audit.jsonl"
+	PHashMaxDistance   = 6          // из 64 бит; при меньшем расстоянии изображения считаются одинаковыми
+	MaxPHashPixels     = 50_000_000 // большие изображения сверяются только по SHA-256
+	AdminAuditEntries  = 50         // событий аудита на странице блоклиста
+)
+
+// Upload scanning. С RIPX_CLAMD_ADDR (unix:/path или [tcp:]host:port) каждая загрузка до записи
+// на диск проверяется clamd. RIPX_SCAN_FAIL_POLICY решает, что делать, если clamd недоступен:
+// closed - отклонять загрузки, open - принимать непроверенными.
+var (
+	ClamdAddr      = os.Getenv("RIPX_CLAMD_ADDR")
+	ScanFailPolicy = envOr("RIPX_SCAN_FAIL_POLICY", ScanFailClosed)
+	ScanTimeout    = envOr("RIPX_SCAN_TIMEOUT", "30s")
+)
+
+const ClamdChunkSize = 64 * 1024 // размер блока INSTREAM
+
+// Rate limiting. Бюджеты задаются как "N/период" (token bucket: N запросов подряд, затем
+// N за период) отдельно для адреса клиента и для сессии; "off" выключает ограничение класса.
+// Адрес клиента за доверенным прокси берется из X-Forwarded-For.
+var (
+	RateLimitUpload = envOr("RIPX_RATE_LIMIT_UPLOAD", "60/1m")
+	RateLimitAlbum  = envOr("RIPX_RATE_LIMIT_ALBUM", "20/1m")
+	RateLimitDelete = envOr("RIPX_RATE_LIMIT_DELETE", "60/1m")
+	RateLimitView   = envOr("RIPX_RATE_LIMIT_VIEW", "300/1m")
+	RateLimitAuth   = envOr("RIPX_RATE_LIMIT_AUTH", "10/1m")
+)
+
+const (
+	RateLimitOff           = "off"
+	RateLimitPruneInterval = time.Minute // как часто забывать восстановившиеся бюджеты
+)
+
+// Storage quotas. Ограничения на владельца (сессию или аккаунт): объем изображений
+// (байты или с суффиксом, например 500MB или 1GiB), число изображений и альбомов.
+// 0 - без ограничения; администратор может задать владельцу свою квоту.
+var (
+	QuotaBytes  = envOr("RIPX_QUOTA_BYTES", "0")
+	QuotaImages = envOr("RIPX_QUOTA_IMAGES", "0")
+	QuotaAlbums = envOr("RIPX_QUOTA_ALBUMS", "0")
+)
+
+const QuotasStateFile = "quotas.json"
+
+// Disk watermarks. Ниже RIPX_DISK_LOW_WATERMARK свободного места в DataPath (процент диска
+// или размер) загрузки отклоняются и очистка запускается раньше срока. С RIPX_DISK_HIGH_WATERMARK
+// самые старые изображения удаляются, пока свободное место не поднимется до этой границы.
+var (
+	DiskLowWatermark  = envOr("RIPX_DISK_LOW_WATERMARK", "5%")
+	DiskHighWatermark = os.Getenv("RIPX_DISK_HIGH_WATERMARK")
 )
 
+const (
+	DiskWatermarkOff    = "off"
+	DiskCheckInterval   = time.Minute
+	DiskCleanupCooldown = 10 * time.Minute // ранняя очистка запускается не чаще
+)
+
+// Retention policy. По умолчанию (age) очистка удаляет изображения старше CleanupDuration.
+// С size она держит хранилище в пределах RIPX_STORAGE_BUDGET, удаляя альбомы целиком: давно не
+// открывавшиеся (RIPX_EVICTION_ORDER=accessed) или самые старые (oldest). RIPX_EVICTION_FAIR=true
+// сначала забирает альбомы у владельца, занимающего больше всех; RIPX_RETENTION_DRY_RUN=true
+// только пишет в лог, что было бы удалено. Закрепленные в админке альбомы не удаляются.
+var (
+	RetentionPolicy = envOr("RIPX_RETENTION_POLICY", RetentionByAge)
+	StorageBudget   = os.Getenv("RIPX_STORAGE_BUDGET")
+	EvictionOrder   = envOr("RIPX_EVICTION_ORDER", EvictLeastAccessed)
+	EvictionFair    = os.Getenv("RIPX_EVICTION_FAIR") == "true"
+	RetentionDryRun = os.Getenv("RIPX_RETENTION_DRY_RUN") == "true"
+)
+
+const AccessStateFile = "access.json"
+
 // Cleanup configuration
 const (
 	CleanupDuration = 1440 * time.Hour // 60 days
 	CleanupInterval = 24 * time.Hour   // 24 hours
 )
+
+// Command-line uploader configuration
+const (
+	CLIConfigFile         = "ripx/config.json" // относительно os.UserConfigDir()
+	DefaultUploadParallel = 4
+	DefaultUploadRetries  = 3
+)
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// csrfRequest отправляет POST с заданными заголовками, не подставляя CSRF токен и не следуя редиректам
+func csrfRequest(t *testing.T, b *testBrowser, path string, header http.Header) int {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, testServer.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	c := &http.Client{
+		Jar:           b.client.Jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestCSRFCookieRequests(t *testing.T) {
+	b := newIntegrationBrowser(t)
+	path := "/api/v1/sessions/" + b.sessionID() + "/albums"
+	token := csrfToken(b.sessionID())
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   int
+	}{
+		{"no token", http.Header{}, http.StatusForbidden},
+		{"wrong token", http.Header{CSRFHeaderName: {csrfToken(RandomID())}}, http.StatusForbidden},
+		{"token", http.Header{CSRFHeaderName: {token}}, http.StatusCreated},
+		{"same origin", http.Header{CSRFHeaderName: {token}, "Origin": {testServer.URL}}, http.StatusCreated},
+		{"cross-origin Origin", http.Header{CSRFHeaderName: {token}, "Origin": {"https://evil.example"}}, http.StatusForbidden},
+		{"cross-site fetch", http.Header{CSRFHeaderName: {token}, "Sec-Fetch-Site": {"cross-site"}}, http.StatusForbidden},
+		// Старые браузеры без Origin и Sec-Fetch-Site проверяются по Referer
+		{"same-origin Referer", http.Header{CSRFHeaderName: {token}, "Referer": {testServer.URL + "/album"}}, http.StatusCreated},
+		{"cross-origin Referer", http.Header{CSRFHeaderName: {token}, "Referer": {"https://evil.example/page"}}, http.StatusForbidden},
+		{"broken Referer", http.Header{CSRFHeaderName: {token}, "Referer": {"not a url"}}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := csrfRequest(t, b, path, tt.header); status != tt.want {
+				t.Errorf("POST %s: %d, want %d", path, status, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSRFBearerBypass(t *testing.T) {
+	c := newTestClient(t)
+	path := "/api/v1/sessions/" + c.SessionID() + "/albums"
+	nobody := newTestBrowser(t)
+
+	// Bearer токен браузер сам не подставит, поэтому CSRF токен не нужен
+	if status := csrfRequest(t, nobody, path, http.Header{"Authorization": {"Bearer " + c.Token()}}); status != http.StatusCreated {
+		t.Errorf("bearer POST without CSRF token: %d, want 201", status)
+	}
+	// Но межсайтовый запрос отклоняется и с токеном
+	header := http.Header{"Authorization": {"Bearer " + c.Token()}, "Origin": {"https://evil.example"}}
+	if status := csrfRequest(t, nobody, path, header); status != http.StatusForbidden {
+		t.Errorf("cross-origin bearer POST: %d, want 403", status)
+	}
+	// Пустой Bearer не освобождает запрос с cookie от проверки
+	b := newIntegrationBrowser(t)
+	path = "/api/v1/sessions/" + b.sessionID() + "/albums"
+	if status := csrfRequest(t, b, path, http.Header{"Authorization": {"Bearer "}}); status != http.StatusForbidden {
+		t.Errorf("cookie POST with an empty bearer: %d, want 403", status)
+	}
+}
+
+func TestCSRFAdminBasicAuth(t *testing.T) {
+	useAdmin(t, "root", "secret")
+	sessionID, albumID := newTestAlbum(t)
+	path := "/admin/sessions/" + sessionID + "/albums/" + albumID + "/pin"
+	basic := func(extra ...string) http.Header {
+		req, _ := http.NewRequest(http.MethodPost, "/", nil)
+		req.SetBasicAuth("root", "secret")
+		for i := 0; i+1 < len(extra); i += 2 {
+			req.Header.Set(extra[i], extra[i+1])
+		}
+		return req.Header
+	}
+
+	// Basic auth браузер подставляет сам, поэтому межсайтовые запросы отклоняются
+	admin := newTestBrowser(t)
+	for name, header := range map[string]http.Header{
+		"cross-origin Origin":  basic("Origin", "https://evil.example"),
+		"cross-site fetch":     basic("Sec-Fetch-Site", "cross-site"),
+		"cross-origin Referer": basic("Referer", "https://evil.example/page"),
+	} {
+		if status := csrfRequest(t, admin, path, header); status != http.StatusForbidden {
+			t.Errorf("admin POST with %s: %d, want 403", name, status)
+		}
+	}
+
+	// С cookie сессии нужен и CSRF токен
+	if status, _ := admin.do("GET", "/", nil); status != http.StatusOK {
+		t.Fatalf("GET /: %d", status)
+	}
+	if status := csrfRequest(t, admin, path, basic()); status != http.StatusForbidden {
+		t.Errorf("admin POST with a session cookie and no CSRF token: %d, want 403", status)
+	}
+	if meta, _ := loadAlbumMeta(sessionID, albumID); meta == nil || meta.Pinned {
+		t.Fatal("rejected admin request pinned the album")
+	}
+
+	header := basic(CSRFHeaderName, csrfToken(admin.sessionID()), "Origin", testServer.URL)
+	if status := csrfRequest(t, admin, path, header); status != http.StatusSeeOther {
+		t.Errorf("same-origin admin POST: %d, want 303", status)
+	}
+	if meta, _ := loadAlbumMeta(sessionID, albumID); meta == nil || !meta.Pinned {
+		t.Error("admin POST did not pin the album")
+	}
+}
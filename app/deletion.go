@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+)
+
+// DeletionPathPrefix - префикс ссылок удаления: /delete/{session}/{album}/{filename}/{key}
+const DeletionPathPrefix = "/delete/"
+
+// registerDeletionRoutes регистрирует страницу подтверждения и само удаление по ссылке
+func registerDeletionRoutes(mux *http.ServeMux) {
+	handleRoute(mux, "GET "+DeletionPathPrefix+"{session}/{album}/{filename}/{key}", deletionPageHandler)
+	handleRoute(mux, "POST "+DeletionPathPrefix+"{session}/{album}/{filename}/{key}", deletionHandler)
+}
+
+// deletionURL возвращает ссылку удаления только что загруженного изображения.
+// Ключ известен лишь в момент загрузки, поэтому позже ссылку получить нельзя.
+func deletionURL(r *http.Request, img *ImageInfo) string {
+	if img.DeleteKey == "" {
+		return ""
+	}
+	return BaseURL(r) + DeletionPathPrefix + img.UserID + "/" + img.AlbumID + "/" + img.Filename + "/" + img.DeleteKey
+}
+
+// checkDeletionKey сверяет ключ удаления с хешем из метаданных изображения
+func checkDeletionKey(sessionID, albumID, filename, key string) bool {
+	if !IsValidID(sessionID) || !albumExists(sessionID, albumID) || !IsValidImageFilename(filename) || key == "" {
+		return false
+	}
+
+	meta, err := loadAlbumMeta(sessionID, albumID)
+	if err != nil {
+		logger.Error(fmt.Sprintf("checkDeletionKey: failed to load metadata: %v", err))
+		return false
+	}
+	hash := meta.Images[filename].DeleteHash
+	if hash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashToken(key)), []byte(hash)) == 1
+}
+
+// deletionPageHandler показывает страницу подтверждения: GET не должен ничего удалять,
+// иначе изображение удалят превью ссылок в мессенджерах
+func deletionPageHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID, albumID, filename := r.PathValue("session"), r.PathValue("album"), r.PathValue("filename")
+	if redirectMergedSession(w, r, sessionID) {
+		return
+	}
+	if !checkDeletionKey(sessionID, albumID, filename, r.PathValue("key")) {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Превью приватного альбома посторонним не отдается, поэтому показываем его только для открытых
+	imageURL := ""
+	if !isAlbumPrivate(sessionID, albumID) {
+		imageURL = "/" + sessionID + "/" + albumID + "/" + filename
+	}
+	renderDeletionPage(w, r, imageURL, false)
+}
+
+// deletionHandler удаляет изображение по ключу без сессии владельца
+func deletionHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID, albumID, filename := r.PathValue("session"), r.PathValue("album"), r.PathValue("filename")
+	if redirectMergedSession(w, r, sessionID) {
+		return
+	}
+	if !checkDeletionKey(sessionID, albumID, filename, r.PathValue("key")) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := checkDeletable(sessionID, albumID, filename); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err := deleteImage(sessionID, albumID, filename); err != nil {
+		http.Error(w, fmt.Sprintf("Error deleting image: %v", err), http.StatusInternalServerError)
+		return
+	}
+	logger.Info(fmt.Sprintf("Image %s/%s/%s deleted by deletion URL", sessionID, albumID, filename))
+	renderDeletionPage(w, r, "", true)
+}
+
+// renderDeletionPage отображает страницу удаления
+func renderDeletionPage(w http.ResponseWriter, r *http.Request, imageURL string, deleted bool) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	// Браузер с сессией отправит форму вместе с cookie, поэтому ей нужен CSRF токен
+	sessionID, _ := resolveSession(r)
+	data := struct {
+		ImageURL        string
+		Deleted         bool
+		CSRFToken       string
+		TotalImageCount int64
+	}{
+		ImageURL:        imageURL,
+		Deleted:         deleted,
+		CSRFToken:       csrfToken(sessionID),
+		TotalImageCount: TotalImageCount.Load(),
+	}
+	if err := renderTemplate(w, "delete.html", data); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// requestDeletion отправляет запрос к ссылке удаления без cookie, как открывший ее посторонний
+func requestDeletion(t *testing.T, method, path string) int {
+	t.Helper()
+	req, err := http.NewRequest(method, testServer.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestDeletionURL(t *testing.T) {
+	sessionID, albumID := newTestAlbum(t)
+	img := storeTestImage(t, sessionID, albumID, 70)
+	other := storeTestImage(t, sessionID, albumID, 71)
+	if img.DeleteKey == "" {
+		t.Fatal("storeImage returned no deletion key")
+	}
+	path := DeletionPathPrefix + sessionID + "/" + albumID + "/" + img.Filename + "/" + img.DeleteKey
+
+	// В метаданных только хеш ключа
+	meta, err := loadAlbumMeta(sessionID, albumID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Images[img.Filename].DeleteHash != hashToken(img.DeleteKey) {
+		t.Error("metadata does not hold the hash of the deletion key")
+	}
+	raw, err := os.ReadFile(filepath.Join(albumPath(sessionID, albumID), AlbumMetaFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), img.DeleteKey) {
+		t.Error("metadata contains the plain deletion key")
+	}
+
+	// GET только показывает подтверждение
+	for range 2 {
+		if status := requestDeletion(t, "GET", path); status != http.StatusOK {
+			t.Fatalf("GET deletion page: %d, want 200", status)
+		}
+	}
+	if _, err := os.Stat(img.Path); err != nil {
+		t.Fatalf("GET deleted the image: %v", err)
+	}
+
+	// Чужой, неверный или пустой ключ не подходит
+	wrongKeys := []string{
+		DeletionPathPrefix + sessionID + "/" + albumID + "/" + img.Filename + "/" + other.DeleteKey,
+		DeletionPathPrefix + sessionID + "/" + albumID + "/" + img.Filename + "/" + strings.ToUpper(img.DeleteKey),
+		DeletionPathPrefix + sessionID + "/" + albumID + "/" + img.Filename + "/" + hashToken(img.DeleteKey),
+		DeletionPathPrefix + sessionID + "/" + albumID + "/" + other.Filename + "/" + img.DeleteKey,
+		DeletionPathPrefix + sessionID + "/" + RandomID() + "/" + img.Filename + "/" + img.DeleteKey,
+	}
+	for _, wrong := range wrongKeys {
+		for _, method := range []string{"GET", "POST"} {
+			if status := requestDeletion(t, method, wrong); status != http.StatusNotFound {
+				t.Errorf("%s %s: %d, want 404", method, wrong, status)
+			}
+		}
+	}
+	for _, kept := range []*ImageInfo{img, other} {
+		if _, err := os.Stat(kept.Path); err != nil {
+			t.Fatalf("wrong key deleted %s: %v", kept.Filename, err)
+		}
+	}
+
+	// POST с верным ключом удаляет, после чего ссылка больше не работает
+	if status := requestDeletion(t, "POST", path); status != http.StatusOK {
+		t.Fatalf("POST deletion: %d, want 200", status)
+	}
+	if _, err := os.Stat(img.Path); !os.IsNotExist(err) {
+		t.Errorf("image still exists after deletion: %v", err)
+	}
+	if _, err := os.Stat(other.Path); err != nil {
+		t.Errorf("deletion removed another image: %v", err)
+	}
+	if status := requestDeletion(t, "POST", path); status != http.StatusNotFound {
+		t.Errorf("second POST deletion: %d, want 404", status)
+	}
+}
@@ -0,0 +1,706 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ошибки привязки устройств
+var (
+	ErrInvalidCode    = errors.New("invalid or expired code")
+	ErrOwnerNotProven = errors.New("only the browser that created the session or a linked device can claim it")
+)
+
+// Device - браузер или программа, привязанные к сессии секретом из cookie DeviceCookieName
+type Device struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Hash       string    `json:"hash"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+// SessionRecord - учетные данные сессии. Пока у сессии нет устройств, она не закреплена
+// и ей, как и раньше, владеет любой, кто знает ID, но закрепить ее может только создавший ее
+// браузер (см. sessionOwnerKey). После выдачи кода восстановления или привязки второго
+// устройства войти можно только с привязанного устройства или по токену.
+// Домашняя сессия аккаунта закреплена всегда, даже без устройств. Сессия, объединенная
+// с сессией аккаунта, остается записью со ссылкой MergedInto. InviteID сессию не закрепляет.
+type SessionRecord struct {
+	AccountID         string     `json:"account_id,omitempty"`
+	InviteID          string     `json:"invite_id,omitempty"` // приглашение, активированное сессией
+	RecoveryHash      string     `json:"recovery_hash,omitempty"`
+	RecoveryCreatedAt *time.Time `json:"recovery_created_at,omitempty"`
+	Devices           []*Device  `json:"devices,omitempty"`
+	MergedInto        string     `json:"merged_into,omitempty"`
+}
+
+// pairingCode - одноразовый код привязки устройства, хранится только в памяти
+type pairingCode struct {
+	SessionID string
+	ExpiresAt time.Time
+}
+
+// sessionStore хранит записи сессий в StatePath/SessionsStateFile
+type sessionStore struct {
+	mu       sync.Mutex
+	loaded   bool
+	records  map[string]*SessionRecord
+	pairings map[string]pairingCode // ключ - хеш кода
+}
+
+var sessions = &sessionStore{}
+
+// load лениво читает записи с диска (вызывается под мьютексом)
+func (s *sessionStore) load() error {
+	if s.loaded {
+		return nil
+	}
+	s.records = map[string]*SessionRecord{}
+	s.pairings = map[string]pairingCode{}
+	if err := loadState(SessionsStateFile, &s.records); err != nil {
+		return err
+	}
+	s.loaded = true
+	return nil
+}
+
+// save записывает записи на диск (вызывается под мьютексом)
+func (s *sessionStore) save() error {
+	return saveState(SessionsStateFile, s.records)
+}
+
+// record возвращает запись сессии, создавая пустую (вызывается под мьютексом)
+func (s *sessionStore) record(sessionID string) *SessionRecord {
+	rec, ok := s.records[sessionID]
+	if !ok {
+		rec = &SessionRecord{}
+		s.records[sessionID] = rec
+	}
+	return rec
+}
+
+// IsClaimed сообщает, закреплена ли сессия за устройствами
+func (s *sessionStore) IsClaimed(sessionID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		logger.Error("Failed to load sessions: " + err.Error())
+		// При ошибке чтения не пускаем по одному ID сессии
+		return true
+	}
+	rec, ok := s.records[sessionID]
+	return ok && (len(rec.Devices) > 0 || rec.AccountID != "" || rec.MergedInto != "")
+}
+
+// SetAccount закрепляет сессию за аккаунтом
+func (s *sessionStore) SetAccount(sessionID, accountID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+	s.record(sessionID).AccountID = accountID
+	return s.save()
+}
+
+// SetInvite отмечает, что сессия активировала приглашение
+func (s *sessionStore) SetInvite(sessionID, inviteID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+	s.record(sessionID).InviteID = inviteID
+	return s.save()
+}
+
+// Invited сообщает, активировала ли сессия приглашение
+func (s *sessionStore) Invited(sessionID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		logger.Error("Failed to load sessions: " + err.Error())
+		return false
+	}
+	rec, ok := s.records[sessionID]
+	return ok && rec.InviteID != ""
+}
+
+// MergedInto возвращает сессию, в которую была перенесена sessionID, или ""
+func (s *sessionStore) MergedInto(sessionID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		logger.Error("Failed to load sessions: " + err.Error())
+		return ""
+	}
+	if rec, ok := s.records[sessionID]; ok {
+		return rec.MergedInto
+	}
+	return ""
+}
+
+// Merge переносит устройства и приглашение сессии from в сессию into и оставляет у from ссылку на into.
+// Код восстановления и коды привязки from перестают действовать.
+func (s *sessionStore) Merge(from, into string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	for hash, pairing := range s.pairings {
+		if pairing.SessionID == from {
+			delete(s.pairings, hash)
+		}
+	}
+
+	old := s.record(from)
+	target := s.record(into)
+	target.Devices = append(target.Devices, old.Devices...)
+	if target.InviteID == "" {
+		target.InviteID = old.InviteID
+	}
+	s.records[from] = &SessionRecord{MergedInto: into}
+	return s.save()
+}
+
+// AuthenticateDevice находит устройство по секрету из cookie
+func (s *sessionStore) AuthenticateDevice(secret string) (string, *Device, error) {
+	hash := hashToken(secret)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return "", nil, err
+	}
+
+	for sessionID, rec := range s.records {
+		for _, device := range rec.Devices {
+			if device.Hash != hash {
+				continue
+			}
+
+			// Время последнего входа сохраняем не чаще раза в минуту
+			if now := time.Now(); now.Sub(device.LastSeenAt) > time.Minute {
+				device.LastSeenAt = now
+				if err := s.save(); err != nil {
+					logger.Error("Failed to save device usage: " + err.Error())
+				}
+			}
+
+			found := *device
+			return sessionID, &found, nil
+		}
+	}
+	return "", nil, ErrInvalidToken
+}
+
+// AddDevice привязывает к сессии новое устройство и возвращает его секрет
+func (s *sessionStore) AddDevice(sessionID, name string) (*Device, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, "", err
+	}
+	return s.addDevice(sessionID, name)
+}
+
+// addDevice - AddDevice без блокировки
+func (s *sessionStore) addDevice(sessionID, name string) (*Device, string, error) {
+	id, err := RandomToken(8)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := RandomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	device := &Device{ID: id, Name: name, Hash: hashToken(secret), CreatedAt: now, LastSeenAt: now}
+	rec := s.record(sessionID)
+	rec.Devices = append(rec.Devices, device)
+	if err := s.save(); err != nil {
+		return nil, "", err
+	}
+	return device, secret, nil
+}
+
+// ListDevices возвращает устройства сессии
+func (s *sessionStore) ListDevices(sessionID string) ([]Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	list := []Device{}
+	if rec, ok := s.records[sessionID]; ok {
+		for _, device := range rec.Devices {
+			list = append(list, *device)
+		}
+	}
+	return list, nil
+}
+
+// RevokeDevice отвязывает устройство от сессии
+func (s *sessionStore) RevokeDevice(sessionID, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return false, err
+	}
+
+	rec, ok := s.records[sessionID]
+	if !ok {
+		return false, nil
+	}
+	before := len(rec.Devices)
+	rec.Devices = slices.DeleteFunc(rec.Devices, func(d *Device) bool { return d.ID == id })
+	if len(rec.Devices) == before {
+		return false, nil
+	}
+	return true, s.save()
+}
+
+// SetRecoveryCode выпускает новый код восстановления; предыдущий перестает действовать
+func (s *sessionStore) SetRecoveryCode(sessionID string) (string, error) {
+	code, err := randomCode(RecoveryCodeLen)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	rec := s.record(sessionID)
+	rec.RecoveryHash = hashToken(normalizeCode(code))
+	rec.RecoveryCreatedAt = &now
+	if err := s.save(); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// CreatePairingCode выпускает одноразовый код привязки устройства
+func (s *sessionStore) CreatePairingCode(sessionID string) (string, time.Time, error) {
+	code, err := randomCode(PairingCodeLen)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return "", time.Time{}, err
+	}
+
+	// Заодно выбрасываем истекшие коды
+	now := time.Now()
+	for hash, pairing := range s.pairings {
+		if now.After(pairing.ExpiresAt) {
+			delete(s.pairings, hash)
+		}
+	}
+
+	expiresAt := now.Add(PairingCodeTTL)
+	s.pairings[hashToken(normalizeCode(code))] = pairingCode{SessionID: sessionID, ExpiresAt: expiresAt}
+	return code, expiresAt, nil
+}
+
+// Redeem привязывает новое устройство по коду привязки или коду восстановления
+func (s *sessionStore) Redeem(code, deviceName string) (string, *Device, string, error) {
+	hash := hashToken(normalizeCode(code))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return "", nil, "", err
+	}
+
+	sessionID := s.sessionByCode(hash)
+	if sessionID == "" {
+		return "", nil, "", ErrInvalidCode
+	}
+
+	device, secret, err := s.addDevice(sessionID, deviceName)
+	if err != nil {
+		return "", nil, "", err
+	}
+	return sessionID, device, secret, nil
+}
+
+// SessionByCode находит сессию по коду привязки или восстановления, не привязывая устройство.
+// Код привязки при этом расходуется.
+func (s *sessionStore) SessionByCode(code string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return "", err
+	}
+
+	sessionID := s.sessionByCode(hashToken(normalizeCode(code)))
+	if sessionID == "" {
+		return "", ErrInvalidCode
+	}
+	return sessionID, nil
+}
+
+// sessionByCode ищет сессию по хешу кода (вызывается под мьютексом)
+func (s *sessionStore) sessionByCode(hash string) string {
+	if pairing, ok := s.pairings[hash]; ok {
+		delete(s.pairings, hash)
+		if time.Now().Before(pairing.ExpiresAt) {
+			return pairing.SessionID
+		}
+	}
+	for id, rec := range s.records {
+		if rec.RecoveryHash != "" && rec.RecoveryHash == hash {
+			return id
+		}
+	}
+	return ""
+}
+
+// DeleteSession удаляет запись сессии вместе с устройствами и кодами
+func (s *sessionStore) DeleteSession(sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	for hash, pairing := range s.pairings {
+		if pairing.SessionID == sessionID {
+			delete(s.pairings, hash)
+		}
+	}
+	// Ссылки объединенных сессий теперь ведут в никуда
+	_, changed := s.records[sessionID]
+	for id, rec := range s.records {
+		if rec.MergedInto == sessionID {
+			delete(s.records, id)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	delete(s.records, sessionID)
+	return s.save()
+}
+
+// codeAlphabet - base32 Крокфорда: без I, L, O и U, которые легко перепутать
+const codeAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// randomCode генерирует код из n символов, разбитый дефисами на группы по 4
+func randomCode(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for i, c := range buf {
+		if i > 0 && i%4 == 0 {
+			b.WriteByte('-')
+		}
+		b.WriteByte(codeAlphabet[int(c)%len(codeAlphabet)])
+	}
+	return b.String(), nil
+}
+
+// normalizeCode приводит введенный код к каноническому виду: без дефисов и пробелов,
+// в верхнем регистре, с исправлением похожих символов
+func normalizeCode(code string) string {
+	replacer := strings.NewReplacer("-", "", " ", "", "O", "0", "I", "1", "L", "1")
+	return replacer.Replace(strings.ToUpper(strings.TrimSpace(code)))
+}
+
+// deviceName коротко описывает браузер и систему по User-Agent
+func deviceName(r *http.Request) string {
+	ua := r.UserAgent()
+
+	browser := "Unknown browser"
+	for _, candidate := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+		{"Go-http-client", "Go client"},
+	} {
+		if strings.Contains(ua, candidate.token) {
+			browser = candidate.name
+			break
+		}
+	}
+
+	for _, candidate := range []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(ua, candidate.token) {
+			return browser + ", " + candidate.name
+		}
+	}
+	return browser
+}
+
+// setDeviceCookie выдает браузеру секрет устройства
+func setDeviceCookie(w http.ResponseWriter, secret string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     DeviceCookieName,
+		Value:    secret,
+		Path:     "/",
+		MaxAge:   DeviceMaxAge,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// clearDeviceCookie удаляет секрет устройства у браузера
+func clearDeviceCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     DeviceCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// resolveSession определяет сессию браузера без API токена: сначала по заголовку доверенного
+// прокси, затем по cookie устройства, затем по cookie сессии. ID сессии виден в каждой ссылке
+// на альбом, поэтому в заголовке он не принимается. Закрепленные сессии принимаются только с устройства.
+func resolveSession(r *http.Request) (sessionID, deviceID string) {
+	if user := proxyUser(r); user != "" {
+		id, err := proxySession(user)
+		if err != nil {
+			logger.Error(fmt.Sprintf("resolveSession: proxy user %s: %v", user, err))
+			return "", ""
+		}
+		return id, ""
+	}
+
+	if cookie, err := r.Cookie(DeviceCookieName); err == nil && cookie.Value != "" {
+		if id, device, err := sessions.AuthenticateDevice(cookie.Value); err == nil {
+			return id, device.ID
+		}
+	}
+
+	if cookie, err := r.Cookie(SessionCookieName); err == nil && IsValidID(cookie.Value) && !sessions.IsClaimed(cookie.Value) {
+		return cookie.Value, ""
+	}
+	return "", ""
+}
+
+// sessionOwnerKey возвращает ключ владельца сессии: HMAC от ID на ключе CSRF токенов
+// с отдельным префиксом. Ключ получает в cookie только браузер, создавший сессию,
+// поэтому знание ID из ссылки на альбом не дает закрепить чужую сессию за собой.
+func sessionOwnerKey(sessionID string) string {
+	mac := hmac.New(sha256.New, csrfKey)
+	mac.Write([]byte("owner:" + sessionID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// setNewSessionCookies выдает cookie только что созданной сессии вместе с ключом ее владельца
+func setNewSessionCookies(w http.ResponseWriter, sessionID string) {
+	setSessionCookie(w, sessionID)
+	http.SetCookie(w, &http.Cookie{
+		Name:     OwnerCookieName,
+		Value:    sessionOwnerKey(sessionID),
+		Path:     "/",
+		MaxAge:   SessionMaxAge,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// provesOwnership сообщает, доказал ли клиент владение сессией, а не только знание ее ID:
+// привязанное устройство, токен владельца, пользователь доверенного прокси или ключ владельца
+func provesOwnership(r *http.Request, caller *Caller) bool {
+	if caller.DeviceID != "" || (caller.Token != nil && caller.Token.Owner) || proxyUser(r) != "" {
+		return true
+	}
+	cookie, err := r.Cookie(OwnerCookieName)
+	return err == nil && hmac.Equal([]byte(cookie.Value), []byte(sessionOwnerKey(caller.SessionID)))
+}
+
+// claimSession привязывает текущий браузер как устройство перед закреплением сессии,
+// чтобы владелец не потерял к ней доступ
+func claimSession(w http.ResponseWriter, r *http.Request, caller *Caller) error {
+	if caller.DeviceID != "" {
+		return nil
+	}
+	device, secret, err := sessions.AddDevice(caller.SessionID, deviceName(r))
+	if err != nil {
+		return err
+	}
+	caller.DeviceID = device.ID
+	setDeviceCookie(w, secret)
+	return nil
+}
+
+// DeviceResource - представление устройства в API (без хеша)
+type DeviceResource struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"`
+}
+
+func deviceResource(device Device, currentID string) DeviceResource {
+	return DeviceResource{
+		ID:         device.ID,
+		Name:       device.Name,
+		CreatedAt:  device.CreatedAt,
+		LastSeenAt: device.LastSeenAt,
+		Current:    device.ID == currentID,
+	}
+}
+
+// apiListDevices возвращает устройства сессии
+func apiListDevices(w http.ResponseWriter, r *http.Request) {
+	caller, ok := apiSessionOwner(w, r)
+	if !ok {
+		return
+	}
+
+	list, err := sessions.ListDevices(caller.SessionID)
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	resources := make([]DeviceResource, 0, len(list))
+	for _, device := range list {
+		resources = append(resources, deviceResource(device, caller.DeviceID))
+	}
+	apiData(w, http.StatusOK, resources)
+}
+
+// deviceRedeemRequest - код привязки или восстановления
+type deviceRedeemRequest struct {
+	Code string `json:"code"`
+}
+
+// apiRedeemDevice привязывает текущий браузер к сессии по коду; авторизация не нужна
+func apiRedeemDevice(w http.ResponseWriter, r *http.Request) {
+	var req deviceRedeemRequest
+	if !apiDecodeJSON(w, r, &req) {
+		return
+	}
+
+	sessionID, device, secret, err := sessions.Redeem(req.Code, deviceName(r))
+	if errors.Is(err, ErrInvalidCode) {
+		ErrorResponse(w, http.StatusBadRequest, ErrCodeInvalidCode, err.Error())
+		return
+	}
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	logger.Info(fmt.Sprintf("Device %s linked to session %s", device.ID, sessionID))
+	setDeviceCookie(w, secret)
+	setSessionCookie(w, sessionID)
+	apiData(w, http.StatusCreated, map[string]interface{}{
+		"session_id": sessionID,
+		"device":     deviceResource(*device, device.ID),
+	})
+}
+
+// apiRevokeDevice отвязывает устройство; отвязанный браузер получит новую пустую сессию
+func apiRevokeDevice(w http.ResponseWriter, r *http.Request) {
+	caller, ok := apiSessionOwner(w, r)
+	if !ok {
+		return
+	}
+
+	id := r.PathValue("id")
+	revoked, err := sessions.RevokeDevice(caller.SessionID, id)
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+	if !revoked {
+		ErrorResponse(w, http.StatusNotFound, ErrCodeDeviceNotFound, "device not found")
+		return
+	}
+
+	if id == caller.DeviceID {
+		clearDeviceCookie(w)
+		clearSessionCookie(w)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiClaimSession закрепляет сессию за текущим браузером, если он доказал владение ею
+func apiClaimSession(w http.ResponseWriter, r *http.Request, caller *Caller) bool {
+	if !provesOwnership(r, caller) {
+		ErrorResponse(w, http.StatusForbidden, ErrCodeForbidden, ErrOwnerNotProven.Error())
+		return false
+	}
+	if err := claimSession(w, r, caller); err != nil {
+		apiInternalError(w, err)
+		return false
+	}
+	return true
+}
+
+// apiCreateRecoveryCode выпускает код восстановления и закрепляет сессию
+func apiCreateRecoveryCode(w http.ResponseWriter, r *http.Request) {
+	caller, ok := apiSessionOwner(w, r)
+	if !ok || !apiClaimSession(w, r, caller) {
+		return
+	}
+
+	code, err := sessions.SetRecoveryCode(caller.SessionID)
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+	apiData(w, http.StatusCreated, map[string]string{"code": code})
+}
+
+// apiCreatePairingCode выпускает короткоживущий код привязки второго устройства
+func apiCreatePairingCode(w http.ResponseWriter, r *http.Request) {
+	caller, ok := apiSessionOwner(w, r)
+	if !ok || !apiClaimSession(w, r, caller) {
+		return
+	}
+
+	code, expiresAt, err := sessions.CreatePairingCode(caller.SessionID)
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+	apiData(w, http.StatusCreated, map[string]interface{}{
+		"code":       code,
+		"expires_at": expiresAt,
+		"url":        BaseURL(r) + "/?link=" + code,
+	})
+}
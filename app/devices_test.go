@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// useSessionID подставляет браузеру cookie сессии, как это может сделать любой, кто видел ссылку на альбом
+func (b *testBrowser) useSessionID(sessionID string) {
+	u, _ := url.Parse(testServer.URL)
+	b.client.Jar.SetCookies(u, []*http.Cookie{{Name: SessionCookieName, Value: sessionID, Path: "/"}})
+}
+
+func TestClaimRequiresOwnership(t *testing.T) {
+	owner := newTestBrowser(t)
+	if status, body := owner.do("POST", "/api/v1/sessions", nil); status != http.StatusCreated {
+		t.Fatalf("create session: %d %s", status, body)
+	}
+	sessionID := owner.sessionID()
+
+	// Второй браузер знает только ID: пользоваться несвязанной сессией он может, закрепить ее - нет
+	intruder := newTestBrowser(t)
+	intruder.useSessionID(sessionID)
+	if status, body := intruder.do("GET", "/api/v1/session", nil); status != http.StatusOK {
+		t.Fatalf("unclaimed session by ID: %d %s", status, body)
+	}
+	for _, path := range []string{"/api/v1/recovery-code", "/api/v1/pairing-codes"} {
+		if status, body := intruder.do("POST", path, nil); status != http.StatusForbidden {
+			t.Errorf("POST %s by ID only: %d %s, want 403", path, status, body)
+		}
+	}
+	if sessions.IsClaimed(sessionID) {
+		t.Fatal("session was claimed without proof of ownership")
+	}
+
+	// Ключ владельца от другой сессии не подходит
+	other := newTestBrowser(t)
+	if status, body := other.do("POST", "/api/v1/sessions", nil); status != http.StatusCreated {
+		t.Fatalf("create session: %d %s", status, body)
+	}
+	other.useSessionID(sessionID)
+	if status, body := other.do("POST", "/api/v1/recovery-code", nil); status != http.StatusForbidden {
+		t.Errorf("recovery code with another session's owner key: %d %s, want 403", status, body)
+	}
+
+	// Создатель закрепляет сессию, после чего одного ID уже недостаточно
+	if status, body := owner.do("POST", "/api/v1/recovery-code", nil); status != http.StatusCreated {
+		t.Fatalf("recovery code by the creator: %d %s", status, body)
+	}
+	if !sessions.IsClaimed(sessionID) {
+		t.Fatal("session is not claimed after issuing a recovery code")
+	}
+	if status, _ := intruder.do("GET", "/api/v1/session", nil); status != http.StatusUnauthorized {
+		t.Errorf("claimed session by ID only: %d, want 401", status)
+	}
+	if status, body := owner.do("GET", "/api/v1/session", nil); status != http.StatusOK {
+		t.Errorf("claimed session from the owner device: %d %s", status, body)
+	}
+}
+
+func TestPageSessionCanBeClaimed(t *testing.T) {
+	// Сессия, выданная при первом открытии страницы, тоже получает ключ владельца
+	b := newTestBrowser(t)
+	if status, _ := b.do("GET", "/", nil); status != http.StatusOK {
+		t.Fatalf("GET /: %d", status)
+	}
+	if b.sessionID() == "" {
+		t.Fatal("no session cookie after the first page view")
+	}
+	if status, body := b.do("POST", "/api/v1/pairing-codes", nil); status != http.StatusCreated {
+		t.Errorf("pairing code for a page session: %d %s", status, body)
+	}
+}
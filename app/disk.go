@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ошибки проверки свободного места
+var (
+	ErrInsufficientStorage  = errors.New("not enough free disk space, try again later")
+	errDiskStatsUnsupported = errors.New("disk space statistics are not supported on this platform")
+)
+
+// watermark - граница свободного места: доля диска в процентах или размер
+type watermark struct {
+	percent float64
+	bytes   byteSize
+}
+
+// parseWatermark разбирает границу вида "5%" или "2GiB"; "off" или пустое значение - границы нет
+func parseWatermark(value string) (watermark, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == DiskWatermarkOff {
+		return watermark{}, nil
+	}
+	if number, found := strings.CutSuffix(value, "%"); found {
+		percent, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+		if err != nil || percent <= 0 || percent >= 100 {
+			return watermark{}, fmt.Errorf("invalid percentage %q", value)
+		}
+		return watermark{percent: percent}, nil
+	}
+	bytes, err := parseByteSize(value)
+	if err != nil {
+		return watermark{}, err
+	}
+	return watermark{bytes: bytes}, nil
+}
+
+// set сообщает, задана ли граница
+func (w watermark) set() bool {
+	return w.percent > 0 || w.bytes > 0
+}
+
+// threshold возвращает границу в байтах для диска размером total
+func (w watermark) threshold(total byteSize) byteSize {
+	if w.percent > 0 {
+		return byteSize(float64(total) * w.percent / 100)
+	}
+	return w.bytes
+}
+
+// DiskStatus - свободное место в DataPath для health и админки
+type DiskStatus struct {
+	Free          byteSize
+	Total         byteSize
+	LowWatermark  byteSize // ниже нее загрузки отклоняются
+	HighWatermark byteSize // до нее освобождается место; 0 - вытеснение выключено
+	Low           bool
+	CheckedAt     time.Time
+	Error         string
+
+	LastEviction time.Time
+	Evicted      int      // изображений удалено последним вытеснением
+	EvictedSize  byteSize // и сколько они занимали
+}
+
+var (
+	diskStatus   DiskStatus
+	diskStatusMu sync.Mutex
+
+	lowWatermark, highWatermark watermark
+)
+
+// diskMonitoringEnabled сообщает, следит ли сервер за свободным местом
+func diskMonitoringEnabled() bool {
+	return lowWatermark.set()
+}
+
+// setupDiskWatermarks читает границы свободного места при запуске
+func setupDiskWatermarks() error {
+	var err error
+	if lowWatermark, err = parseWatermark(DiskLowWatermark); err != nil {
+		return fmt.Errorf("RIPX_DISK_LOW_WATERMARK: %w", err)
+	}
+	if highWatermark, err = parseWatermark(DiskHighWatermark); err != nil {
+		return fmt.Errorf("RIPX_DISK_HIGH_WATERMARK: %w", err)
+	}
+	if highWatermark.set() && !lowWatermark.set() {
+		return errors.New("RIPX_DISK_HIGH_WATERMARK requires RIPX_DISK_LOW_WATERMARK")
+	}
+	if !lowWatermark.set() {
+		return nil
+	}
+
+	status := checkDisk()
+	if status.Error != "" {
+		if _, _, err := diskSpace(DataPath); errors.Is(err, errDiskStatsUnsupported) {
+			logger.Info("Disk watermarks disabled: " + err.Error())
+			lowWatermark, highWatermark = watermark{}, watermark{}
+			return nil
+		}
+		return fmt.Errorf("failed to check free space in %s: %s", DataPath, status.Error)
+	}
+	if status.HighWatermark > 0 && status.HighWatermark <= status.LowWatermark {
+		return errors.New("RIPX_DISK_HIGH_WATERMARK must be above RIPX_DISK_LOW_WATERMARK")
+	}
+	logger.Info(fmt.Sprintf("Disk watermarks: %s free of %s, uploads stop below %s", status.Free, status.Total, status.LowWatermark))
+	return nil
+}
+
+// checkDisk измеряет свободное место и обновляет diskStatus
+func checkDisk() DiskStatus {
+	free, total, err := diskSpace(DataPath)
+
+	diskStatusMu.Lock()
+	defer diskStatusMu.Unlock()
+	diskStatus.CheckedAt = time.Now()
+	if err != nil {
+		diskStatus.Error = err.Error()
+		return diskStatus
+	}
+	diskStatus.Error = ""
+	diskStatus.Free, diskStatus.Total = byteSize(free), byteSize(total)
+	diskStatus.LowWatermark = lowWatermark.threshold(diskStatus.Total)
+	diskStatus.HighWatermark = highWatermark.threshold(diskStatus.Total)
+	diskStatus.Low = diskStatus.Free < diskStatus.LowWatermark
+	return diskStatus
+}
+
+// getDiskStatus возвращает копию последнего измерения
+func getDiskStatus() DiskStatus {
+	diskStatusMu.Lock()
+	defer diskStatusMu.Unlock()
+	return diskStatus
+}
+
+// checkDiskSpace проверяет перед записью, что после файла размером size место
+// не опустится ниже нижней границы. Если размер диска узнать не удалось, загрузка не блокируется.
+func checkDiskSpace(size int64) error {
+	if !diskMonitoringEnabled() {
+		return nil
+	}
+	status := checkDisk()
+	if status.Error != "" {
+		logger.Error("checkDiskSpace: " + status.Error)
+		return nil
+	}
+	if status.Free-byteSize(size) < status.LowWatermark {
+		requestCleanup()
+		return ErrInsufficientStorage
+	}
+	return nil
+}
+
+// startDiskMonitor периодически проверяет свободное место и запускает очистку раньше срока
+func startDiskMonitor(ctx context.Context) {
+	if !diskMonitoringEnabled() {
+		return
+	}
+	ticker := time.NewTicker(DiskCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			status := checkDisk()
+			if status.Error != "" {
+				logger.Error("Disk monitor: " + status.Error)
+				continue
+			}
+			if status.Low {
+				requestCleanup()
+			}
+		}
+	}
+}
+
+var (
+	// cleanupRequests будит cleanup worker раньше расписания
+	cleanupRequests = make(chan struct{}, 1)
+
+	lastCleanupRequest   time.Time
+	lastCleanupRequestMu sync.Mutex
+)
+
+// requestCleanup просит cleanup worker пройтись по хранилищу сейчас. Не чаще
+// DiskCleanupCooldown, чтобы полный диск не превратил очистку в непрерывный обход.
+func requestCleanup() {
+	lastCleanupRequestMu.Lock()
+	defer lastCleanupRequestMu.Unlock()
+	if time.Since(lastCleanupRequest) < DiskCleanupCooldown {
+		return
+	}
+	lastCleanupRequest = time.Now()
+
+	select {
+	case cleanupRequests <- struct{}{}:
+		logger.Info("Free disk space below the low watermark, starting early cleanup")
+	default:
+	}
+}
+
+// storedImage - файл изображения, который можно вытеснить
+type storedImage struct {
+	sessionID, albumID, filename string
+	size                         int64
+	modTime                      time.Time
+}
+
+// evictForDiskSpace удаляет самые старые изображения, пока свободное место не поднимется
+// до верхней границы. Работает, только если верхняя граница задана и место уже ниже нижней.
+func evictForDiskSpace() error {
+	if !highWatermark.set() {
+		return nil
+	}
+	status := checkDisk()
+	if status.Error != "" || !status.Low {
+		return nil
+	}
+
+	images, err := evictionCandidates()
+	if err != nil {
+		return err
+	}
+
+	evicted, freed := 0, byteSize(0)
+	for _, img := range images {
+		if status.Free >= status.HighWatermark {
+			break
+		}
+		if err := deleteImage(img.sessionID, img.albumID, img.filename); err != nil {
+			logger.Error(fmt.Sprintf("evictForDiskSpace: %s/%s/%s: %v", img.sessionID, img.albumID, img.filename, err))
+			continue
+		}
+		evicted++
+		freed += byteSize(img.size)
+		if status = checkDisk(); status.Error != "" {
+			break
+		}
+	}
+
+	diskStatusMu.Lock()
+	diskStatus.LastEviction, diskStatus.Evicted, diskStatus.EvictedSize = time.Now(), evicted, freed
+	diskStatusMu.Unlock()
+
+	if evicted > 0 {
+		recordAudit(AuditEvent{Event: AuditDiskEviction, Detail: fmt.Sprintf("%d images, %s", evicted, freed)})
+	}
+	logger.Info(fmt.Sprintf("Disk eviction removed %d images (%s), %s free", evicted, freed, status.Free))
+	if status.Free < status.HighWatermark {
+		return fmt.Errorf("disk eviction stopped at %s free, below the high watermark %s", status.Free, status.HighWatermark)
+	}
+	return nil
+}
+
+// evictionCandidates возвращает изображения всех альбомов, старые первыми. Скрытые модератором
+// изображения хранятся до решения по жалобе, закрепленные альбомы тоже не вытесняются.
+func evictionCandidates() ([]storedImage, error) {
+	quarantined, err := moderation.QuarantinedPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := os.ReadDir(DataPath)
+	if err != nil {
+		return nil, err
+	}
+	var images []storedImage
+	for _, session := range sessions {
+		if !session.IsDir() || isHiddenName(session.Name()) {
+			continue
+		}
+		albums, err := os.ReadDir(filepath.Join(DataPath, session.Name()))
+		if err != nil {
+			continue
+		}
+		for _, album := range albums {
+			if !album.IsDir() {
+				continue
+			}
+			if meta, err := loadAlbumMeta(session.Name(), album.Name()); err == nil && meta.Pinned {
+				continue
+			}
+			files, err := os.ReadDir(albumPath(session.Name(), album.Name()))
+			if err != nil {
+				continue
+			}
+			for _, file := range files {
+				path := imagePath(session.Name(), album.Name(), file.Name())
+				if file.IsDir() || !IsImageFile(file.Name()) || quarantined[path] {
+					continue
+				}
+				info, err := file.Info()
+				if err != nil {
+					continue
+				}
+				images = append(images, storedImage{
+					sessionID: session.Name(),
+					albumID:   album.Name(),
+					filename:  file.Name(),
+					size:      info.Size(),
+					modTime:   info.ModTime(),
+				})
+			}
+		}
+	}
+
+	sort.Slice(images, func(i, j int) bool { return images[i].modTime.Before(images[j].modTime) })
+	return images, nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+// diskSpace на этой платформе не реализован, границы свободного места выключаются
+func diskSpace(path string) (free, total uint64, err error) {
+	return 0, 0, errDiskStatsUnsupported
+}
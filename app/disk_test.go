@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"ripx/client"
+)
+
+// useWatermarks задает границы свободного места на время теста
+func useWatermarks(t *testing.T, low, high watermark) {
+	t.Helper()
+	oldLow, oldHigh := lowWatermark, highWatermark
+	lowWatermark, highWatermark = low, high
+	t.Cleanup(func() {
+		lowWatermark, highWatermark = oldLow, oldHigh
+		checkDisk()
+	})
+}
+
+func TestParseWatermark(t *testing.T) {
+	tests := []struct {
+		value string
+		want  watermark
+		err   bool
+	}{
+		{"", watermark{}, false},
+		{"off", watermark{}, false},
+		{"5%", watermark{percent: 5}, false},
+		{" 12.5 % ", watermark{percent: 12.5}, false},
+		{"2GiB", watermark{bytes: 2 << 30}, false},
+		{"500MB", watermark{bytes: 500_000_000}, false},
+		{"0%", watermark{}, true},
+		{"100%", watermark{}, true},
+		{"-1%", watermark{}, true},
+		{"%", watermark{}, true},
+		{"many", watermark{}, true},
+		{"5 parsecs", watermark{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseWatermark(tt.value)
+		if (err != nil) != tt.err {
+			t.Errorf("parseWatermark(%q) error = %v, want error %v", tt.value, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseWatermark(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+
+	if got := (watermark{percent: 10}).threshold(1000); got != 100 {
+		t.Errorf("10%% of 1000 bytes = %d, want 100", got)
+	}
+	if got := (watermark{bytes: 300}).threshold(1000); got != 300 {
+		t.Errorf("fixed threshold = %d, want 300", got)
+	}
+}
+
+func TestCheckDiskSpace(t *testing.T) {
+	// Граница выше любого реального диска: места всегда мало
+	useWatermarks(t, watermark{bytes: 1 << 60}, watermark{})
+	if err := checkDiskSpace(1); !errors.Is(err, ErrInsufficientStorage) {
+		t.Fatalf("checkDiskSpace below the watermark: %v, want ErrInsufficientStorage", err)
+	}
+	if status := getDiskStatus(); !status.Low || status.Error != "" {
+		t.Errorf("disk status %+v, want low", status)
+	}
+
+	c := newTestClient(t)
+	album, err := c.CreateAlbum(context.Background(), client.AlbumUpdate{})
+	if err != nil {
+		t.Fatalf("CreateAlbum: %v", err)
+	}
+	if status, body := putRequest(t, c.Token(), "shot.png", album.ID, testPNG(t, 90)); status != http.StatusInsufficientStorage {
+		t.Errorf("PUT below the watermark: %d %s, want 507", status, body)
+	}
+
+	// Выключенная граница загрузки не блокирует
+	useWatermarks(t, watermark{}, watermark{})
+	if err := checkDiskSpace(1 << 60); err != nil {
+		t.Errorf("checkDiskSpace without watermarks: %v", err)
+	}
+}
+
+func TestEvictionCandidates(t *testing.T) {
+	sessionID, albumID := newTestAlbum(t)
+	oldest := storeTestImage(t, sessionID, albumID, 91)
+	newer := storeTestImage(t, sessionID, albumID, 92)
+	hidden := storeTestImage(t, sessionID, albumID, 93)
+	ageFile(t, oldest.Path)
+	quarantineTestImage(t, hidden)
+
+	pinnedAlbum, err := createAlbum(sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned := storeTestImage(t, sessionID, pinnedAlbum, 94)
+	if err := updateAlbumMeta(sessionID, pinnedAlbum, func(meta *AlbumMeta) { meta.Pinned = true }); err != nil {
+		t.Fatal(err)
+	}
+
+	images, err := evictionCandidates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	position := map[string]int{}
+	for i, img := range images {
+		if img.sessionID == sessionID {
+			position[img.filename] = i
+		}
+		if i > 0 && img.modTime.Before(images[i-1].modTime) {
+			t.Fatalf("candidates are not sorted oldest first at %d", i)
+		}
+	}
+
+	if _, ok := position[hidden.Filename]; ok {
+		t.Error("quarantined image is an eviction candidate")
+	}
+	if _, ok := position[pinned.Filename]; ok {
+		t.Error("image of a pinned album is an eviction candidate")
+	}
+	oldestAt, okOldest := position[oldest.Filename]
+	newerAt, okNewer := position[newer.Filename]
+	if !okOldest || !okNewer {
+		t.Fatalf("ordinary images missing from candidates: %v", position)
+	}
+	if oldestAt > newerAt {
+		t.Error("older image comes after the newer one")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// handleAlbumZip отдает все изображения альбома одним ZIP архивом.
+// Архив пишется прямо в ответ, без буферизации в памяти.
+func handleAlbumZip(w http.ResponseWriter, r *http.Request, sessionID, albumID string) {
+	logger.Debug(fmt.Sprintf("handleAlbumZip: sessionID=%s, albumID=%s", sessionID, albumID))
+
+	if _, err := os.Stat(albumPath(sessionID, albumID)); os.IsNotExist(err) || !canViewAlbum(r, sessionID, albumID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	// getUserImages уже сортирует изображения в порядке загрузки
+	images, err := getUserImages(sessionID, albumID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	meta, err := loadAlbumMeta(sessionID, albumID)
+	if err != nil {
+		logger.Error(fmt.Sprintf("handleAlbumZip: failed to load metadata: %v", err))
+		meta = &AlbumMeta{Images: map[string]ImageMeta{}}
+	}
+
+	archiveName := albumID
+	if meta.Name != "" {
+		archiveName = meta.Name
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": archiveName + ".zip",
+	}))
+
+	albumAccess.Touch(sessionID, albumID)
+	hidden := moderation.QuarantinedInAlbum(sessionID, albumID)
+	zw := zip.NewWriter(w)
+	usedNames := make(map[string]bool)
+	for _, img := range images {
+		if hidden[img.Filename] {
+			continue
+		}
+		name := zipEntryName(img.Filename, meta.Images[img.Filename].OriginalName, usedNames)
+		if err := writeZipEntry(zw, img.Path, name); err != nil {
+			// Заголовки уже отправлены, остается только прервать архив
+			logger.Error(fmt.Sprintf("handleAlbumZip: failed to write %s: %v", img.Path, err))
+			return
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		logger.Error(fmt.Sprintf("handleAlbumZip: failed to finish archive: %v", err))
+	}
+}
+
+// writeZipEntry копирует файл в архив без повторного сжатия
+func writeZipEntry(zw *zip.Writer, filePath, name string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Изображения уже сжаты, поэтому сохраняем их как есть
+	entry, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: info.ModTime(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(entry, file)
+	return err
+}
+
+// zipEntryName выбирает имя записи: исходное имя файла, если оно известно,
+// с расширением сохраненного файла и без повторов внутри архива.
+// Исходное имя пришло от клиента, поэтому от него берется только очищенное базовое имя
+func zipEntryName(filename, originalName string, used map[string]bool) string {
+	ext := filepath.Ext(filename)
+	originalName = cleanOriginalName(originalName)
+	base := strings.TrimSuffix(originalName, filepath.Ext(originalName))
+	if base == "" || base == "." || base == ".." {
+		base = strings.TrimSuffix(filename, ext)
+	}
+
+	name := base + ext
+	for i := 2; used[name]; i++ {
+		name = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	used[name] = true
+	return name
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestAlbumZipEntryNames(t *testing.T) {
+	sessionID, albumID := newTestAlbum(t)
+
+	// Исходные имена в метаданных могли попасть туда до очистки или через правку вручную
+	originalNames := []string{`..\..\evil.png`, "../../etc/passwd.png", "..", "tab\tname.png", ""}
+	want := map[string]bool{"evil.png": true, "passwd.png": true, "tabname.png": true}
+	fallback := map[string]bool{}
+	for i, name := range originalNames {
+		img := storeTestImage(t, sessionID, albumID, 30+i)
+		if err := updateAlbumMeta(sessionID, albumID, func(meta *AlbumMeta) {
+			imageMeta := meta.Images[img.Filename]
+			imageMeta.OriginalName = name
+			meta.Images[img.Filename] = imageMeta
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if name == ".." || name == "" {
+			fallback[img.Filename] = true
+		}
+	}
+	for filename := range fallback {
+		want[filename] = true
+	}
+
+	resp, err := http.Get(testServer.URL + "/" + sessionID + "/" + albumID + ".zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET album zip: %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		if strings.ContainsAny(f.Name, `/\`) || strings.HasPrefix(f.Name, ".") {
+			t.Errorf("unsafe entry name %q", f.Name)
+		}
+		if !want[f.Name] {
+			t.Errorf("unexpected entry name %q", f.Name)
+		}
+	}
+	if len(names) != len(want) {
+		sort.Strings(names)
+		t.Errorf("entries %q, want %d entries", names, len(want))
+	}
+}
+
+func TestZipEntryName(t *testing.T) {
+	used := map[string]bool{}
+	tests := []struct {
+		filename, originalName, want string
+	}{
+		{"abc123.png", "holiday.jpeg", "holiday.png"},
+		{"abc124.png", "holiday.png", "holiday (2).png"},
+		{"abc125.png", `C:\Users\me\shot.png`, "shot.png"},
+		{"abc126.png", "dir/.png", "abc126.png"},
+		{"abc127.png", "..png", "abc127.png"},
+		{"abc128.png", "bell\a.png", "bell.png"},
+	}
+	for _, tt := range tests {
+		if got := zipEntryName(tt.filename, tt.originalName, used); got != tt.want {
+			t.Errorf("zipEntryName(%q, %q) = %q, want %q", tt.filename, tt.originalName, got, tt.want)
+		}
+	}
+}
@@ -1,12 +1,13 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"os"
-	"strings"
 	"sync"
 	"time"
 )
@@ -38,17 +39,39 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 		albums = []AlbumInfo{}
 	}
 
+	// Аккаунт, которому принадлежит сессия, если на сервере можно войти
+	var account *Account
+	if accountsAvailable() {
+		if account, err = accounts.FindBySession(sessionID); err != nil {
+			logger.Error(fmt.Sprintf("indexHandler: failed to load account: %v", err))
+		}
+	}
+
 	// Подготавливаем данные для шаблона
 	data := struct {
 		Albums          []AlbumInfo
 		HasAlbums       bool
 		SessionID       string
-		TotalImageCount int
+		AccountsEnabled bool
+		OIDCEnabled     bool
+		Account         *Account
+		CanUpload       bool
+		InviteRequired  bool
+		CSRFToken       string
+		TotalImageCount int64
+		Quota           *quotaView
 	}{
 		Albums:          albums,
 		HasAlbums:       len(albums) > 0,
 		SessionID:       sessionID,
-		TotalImageCount: TotalImageCount,
+		AccountsEnabled: AccountsEnabled,
+		OIDCEnabled:     oidcEnabled(),
+		Account:         account,
+		CanUpload:       checkUploadPolicy(&Caller{SessionID: sessionID}) == nil,
+		InviteRequired:  InviteOnly && !sessions.Invited(sessionID),
+		CSRFToken:       csrfToken(sessionID),
+		TotalImageCount: TotalImageCount.Load(),
+		Quota:           loadQuotaView(sessionID),
 	}
 
 	// Отображаем страницу
@@ -65,17 +88,31 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sessionID := getSessionID(w, r)
+	caller, ok := requireCaller(w, r, ScopeUpload)
+	if !ok {
+		return
+	}
+	sessionID := caller.SessionID
 	logger.Debug(fmt.Sprintf("uploadHandler: sessionID=%s", sessionID))
 
-	// Ограничиваем размер запроса
+	// Ограничиваем размер запроса (архивы могут быть больше одного изображения)
+	r.Body = http.MaxBytesReader(w, r.Body, MaxArchiveSize)
 	if err := r.ParseMultipartForm(MaxFileSize); err != nil {
 		http.Error(w, "Error parsing form", http.StatusBadRequest)
 		return
 	}
+	defer r.MultipartForm.RemoveAll()
 
 	// Получаем ID альбома
-	albumID := getAlbumID(r, sessionID)
+	albumID, err := getAlbumID(r, sessionID)
+	if errors.Is(err, ErrAlbumNotFound) {
+		http.Error(w, "Album not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error creating album: %v", err), uploadErrorStatus(err, http.StatusInternalServerError))
+		return
+	}
 
 	// Проверяем файлы
 	files := getUploadFiles(r)
@@ -85,14 +122,42 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Обрабатываем файлы
-	if err := processUpload(files, sessionID, albumID); err != nil {
-		http.Error(w, fmt.Sprintf("Upload failed: %v", err), http.StatusInternalServerError)
+	images, archives := splitArchiveUploads(files)
+	uploaded, err := processUpload(images, sessionID, albumID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Upload failed: %v", err), uploadErrorStatus(err, http.StatusInternalServerError))
 		return
 	}
+	entries := processArchiveUploads(archives, sessionID, albumID)
 
 	// Проверяем, является ли запрос XHR (технический/фоновый)
 	if r.Header.Get("X-Requested-With") == "XMLHttpRequest" || r.Header.Get("Accept") == "application/json" {
-		w.WriteHeader(http.StatusNoContent)
+		// Ссылки удаления можно получить только сейчас, поэтому отдаем их вместе со ссылками
+		type uploadedImage struct {
+			Filename    string `json:"filename"`
+			URL         string `json:"url"`
+			DeletionURL string `json:"deletion_url,omitempty"`
+		}
+		result := make([]uploadedImage, 0, len(uploaded))
+		for _, img := range uploaded {
+			result = append(result, uploadedImage{
+				Filename:    img.Filename,
+				URL:         BaseURL(r) + "/" + sessionID + "/" + albumID + "/" + img.Filename,
+				DeletionURL: deletionURL(r, img),
+			})
+		}
+		for i := range entries {
+			if entries[i].image != nil {
+				entries[i].DeletionURL = deletionURL(r, entries[i].image)
+			}
+		}
+
+		SuccessResponse(w, map[string]interface{}{
+			"album_id":   albumID,
+			"session_id": sessionID,
+			"images":     result,
+			"entries":    entries,
+		})
 		return
 	}
 
@@ -102,23 +167,28 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 
 // contentHandler обрабатывает отдачу изображений или страницы альбома
 func contentHandler(w http.ResponseWriter, r *http.Request) {
-	path := strings.TrimPrefix(r.URL.Path, "/")
-	if path == "" {
+	route, err := parseContentPath(r)
+	if err != nil {
+		logger.Debug(fmt.Sprintf("contentHandler: rejected path %q", r.URL.EscapedPath()))
 		http.NotFound(w, r)
 		return
 	}
 
-	parts := strings.SplitN(path, "/", 3)
+	// Альбомы объединенной сессии переехали в сессию аккаунта
+	if redirectMergedSession(w, r, route.SessionID) {
+		return
+	}
 
-	switch len(parts) {
-	case 2:
-		// Страница альбома
-		handleAlbumPage(w, r, parts[0], parts[1])
-	case 3:
+	switch {
+	case route.Zip:
+		// Альбом целиком одним архивом
+		handleAlbumZip(w, r, route.SessionID, route.AlbumID)
+	case route.Filename != "":
 		// Файл изображения
-		handleImageFile(w, r, parts[0], parts[1], parts[2])
+		handleImageFile(w, r, route.SessionID, route.AlbumID, route.Filename)
 	default:
-		http.NotFound(w, r)
+		// Страница альбома
+		handleAlbumPage(w, r, route.SessionID, route.AlbumID)
 	}
 }
 
@@ -131,8 +201,19 @@ func handleAlbumPage(w http.ResponseWriter, r *http.Request, sessionID, albumID
 	currentSessionID := getSessionID(w, r)
 	isOwner := currentSessionID == sessionID
 
+	// Приватный альбом для чужих выглядит как несуществующий
+	isPrivate := isAlbumPrivate(sessionID, albumID)
+	if isPrivate && !isOwner {
+		http.NotFound(w, r)
+		return
+	}
+
 	images, _ := getUserImages(sessionID, albumID)
 	logger.Debug(fmt.Sprintf("handleAlbumPage: images_count=%d", len(images)))
+	album := getAlbumInfo(sessionID, albumID)
+	if albumExists(sessionID, albumID) {
+		albumAccess.Touch(sessionID, albumID)
+	}
 
 	data := struct {
 		Images          []ImageInfo
@@ -140,16 +221,26 @@ func handleAlbumPage(w http.ResponseWriter, r *http.Request, sessionID, albumID
 		SessionID       string
 		OwnerSessionID  string
 		AlbumID         string
+		AlbumName       string
 		IsOwner         bool
-		TotalImageCount int
+		CanUpload       bool
+		IsPrivate       bool
+		Quarantined     map[string]bool
+		CSRFToken       string
+		TotalImageCount int64
 	}{
 		Images:          images,
 		HasImages:       len(images) > 0,
 		SessionID:       currentSessionID,
 		OwnerSessionID:  sessionID,
 		AlbumID:         albumID,
+		AlbumName:       album.Name,
 		IsOwner:         isOwner,
-		TotalImageCount: TotalImageCount,
+		CanUpload:       isOwner && checkUploadPolicy(&Caller{SessionID: sessionID}) == nil,
+		IsPrivate:       isPrivate,
+		Quarantined:     moderation.QuarantinedInAlbum(sessionID, albumID),
+		CSRFToken:       csrfToken(currentSessionID),
+		TotalImageCount: TotalImageCount.Load(),
 	}
 
 	if err := renderTemplate(w, "album.html", data); err != nil {
@@ -159,13 +250,25 @@ func handleAlbumPage(w http.ResponseWriter, r *http.Request, sessionID, albumID
 
 // handleImageFile обрабатывает отдачу файла изображения
 func handleImageFile(w http.ResponseWriter, r *http.Request, sessionID, albumID, filename string) {
-	filePath := imagePath(sessionID, albumID, filename)
+	filePath, err := resolveStoragePath(sessionID, albumID, filename)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
 
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+	if info, err := os.Stat(filePath); err != nil || !info.Mode().IsRegular() || !canViewAlbum(r, sessionID, albumID) {
 		http.NotFound(w, r)
 		return
 	}
 
+	// Скрытое модератором изображение недоступно никому, включая владельца
+	if moderation.Quarantined(sessionID, albumID, filename) {
+		w.Header().Set("Cache-Control", "no-store")
+		http.Error(w, "Unavailable For Legal Reasons", http.StatusUnavailableForLegalReasons)
+		return
+	}
+
+	albumAccess.Touch(sessionID, albumID)
 	http.ServeFile(w, r, filePath)
 }
 
@@ -176,15 +279,23 @@ func deleteImageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sessionID := getSessionID(w, r)
+	caller, ok := requireCaller(w, r, ScopeDelete)
+	if !ok {
+		return
+	}
+	sessionID := caller.SessionID
 	albumID := r.FormValue("album_id")
 	filename := r.FormValue("filename")
 
-	if albumID == "" || filename == "" {
-		http.Error(w, "album_id and filename required", http.StatusBadRequest)
+	if !IsValidID(albumID) || !IsValidImageFilename(filename) {
+		http.Error(w, "valid album_id and filename required", http.StatusBadRequest)
 		return
 	}
 
+	if err := checkDeletable(sessionID, albumID, filename); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err := deleteImage(sessionID, albumID, filename); err != nil {
 		http.Error(w, fmt.Sprintf("Error deleting image: %v", err), http.StatusInternalServerError)
 		return
@@ -200,14 +311,22 @@ func deleteAlbumHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sessionID := getSessionID(w, r)
+	caller, ok := requireCaller(w, r, ScopeDelete)
+	if !ok {
+		return
+	}
+	sessionID := caller.SessionID
 	albumID := r.FormValue("album_id")
 
-	if albumID == "" {
-		http.Error(w, "album_id required", http.StatusBadRequest)
+	if !IsValidID(albumID) {
+		http.Error(w, "valid album_id required", http.StatusBadRequest)
 		return
 	}
 
+	if err := checkDeletable(sessionID, albumID, ""); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err := deleteAlbum(sessionID, albumID); err != nil {
 		http.Error(w, fmt.Sprintf("Error deleting album: %v", err), http.StatusInternalServerError)
 		return
@@ -216,6 +335,41 @@ func deleteAlbumHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+// albumPrivacyHandler переключает приватность альбома
+func albumPrivacyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	caller, ok := requireCaller(w, r, ScopeUpload)
+	if !ok {
+		return
+	}
+	sessionID := caller.SessionID
+	albumID := r.FormValue("album_id")
+	private := r.FormValue("private") == "true"
+
+	if !IsValidID(albumID) {
+		http.Error(w, "album_id required", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := os.Stat(albumPath(sessionID, albumID)); os.IsNotExist(err) {
+		http.Error(w, "Album not found", http.StatusNotFound)
+		return
+	}
+
+	if err := updateAlbumMeta(sessionID, albumID, func(meta *AlbumMeta) {
+		meta.Private = private
+	}); err != nil {
+		http.Error(w, fmt.Sprintf("Error updating album: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/"+sessionID+"/"+albumID, http.StatusSeeOther)
+}
+
 // deleteUserHandler обрабатывает удаление профиля пользователя
 func deleteUserHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -223,22 +377,24 @@ func deleteUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sessionID := getSessionID(w, r)
+	caller, ok := requireCaller(w, r, ScopeDelete)
+	if !ok {
+		return
+	}
+	sessionID := caller.SessionID
 
+	if err := checkDeletable(sessionID, "", ""); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err := deleteUser(sessionID); err != nil {
 		http.Error(w, fmt.Sprintf("Error deleting user data: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	// Очищаем cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     SessionCookieName,
-		Value:    "",
-		Path:     "/",
-		MaxAge:   -1,
-		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
-	})
+	clearSessionCookie(w)
+	clearDeviceCookie(w)
 
 	SuccessResponse(w, map[string]string{"message": "Profile deleted successfully"})
 }
@@ -250,16 +406,20 @@ func createAlbumHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sessionID := getSessionID(w, r)
+	caller, ok := requireCaller(w, r, ScopeUpload)
+	if !ok {
+		return
+	}
+	sessionID := caller.SessionID
 
 	albumID, err := createAlbum(sessionID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error creating album: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Error creating album: %v", err), uploadErrorStatus(err, http.StatusInternalServerError))
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprintf(w, `{"album_id": "%s", "session_id": "%s"}`, albumID, sessionID)
+	json.NewEncoder(w).Encode(map[string]string{"album_id": albumID, "session_id": sessionID})
 }
 
 // changelogCache хранит содержимое ченджлога в памяти
@@ -329,18 +489,15 @@ func changelogHandler(w http.ResponseWriter, r *http.Request) {
 // Вспомогательные функции
 
 // getAlbumID получает или создает ID альбома
-func getAlbumID(r *http.Request, sessionID string) string {
+func getAlbumID(r *http.Request, sessionID string) (string, error) {
 	albumID := r.FormValue("album_id")
-	if albumID != "" {
-		return albumID
+	if albumID == "" {
+		return createAlbum(sessionID)
 	}
-
-	// Создаем новый альбом если не указан
-	newAlbumID, err := createAlbum(sessionID)
-	if err != nil {
-		return ""
+	if !albumExists(sessionID, albumID) {
+		return "", ErrAlbumNotFound
 	}
-	return newAlbumID
+	return albumID, nil
 }
 
 // getUploadFiles извлекает файлы из запроса
@@ -357,15 +514,16 @@ func getUploadFiles(r *http.Request) []*multipart.FileHeader {
 	return files
 }
 
-// processUpload обрабатывает загрузку файлов параллельно
-func processUpload(files []*multipart.FileHeader, sessionID, albumID string) error {
+// processUpload обрабатывает загрузку файлов параллельно и возвращает сохраненные изображения
+func processUpload(files []*multipart.FileHeader, sessionID, albumID string) ([]*ImageInfo, error) {
 	logger.Debug(fmt.Sprintf("processUpload: starting, files_count=%d, sessionID=%s, albumID=%s", len(files), sessionID, albumID))
 	var wg sync.WaitGroup
 	errs := make(chan error, len(files))
+	saved := make([]*ImageInfo, len(files))
 
-	for _, fileHeader := range files {
+	for i, fileHeader := range files {
 		wg.Add(1)
-		go func(fh *multipart.FileHeader) {
+		go func(i int, fh *multipart.FileHeader) {
 			defer wg.Done()
 			file, err := fh.Open()
 			if err != nil {
@@ -374,26 +532,37 @@ func processUpload(files []*multipart.FileHeader, sessionID, albumID string) err
 			}
 			defer file.Close()
 
-			_, err = saveImage(file, fh, sessionID, albumID)
+			info, err := saveImage(file, fh, sessionID, albumID)
 			if err != nil {
-				errs <- fmt.Errorf("error saving file %s: %v", fh.Filename, err)
+				errs <- fmt.Errorf("error saving file %s: %w", fh.Filename, err)
 				return
 			}
-		}(fileHeader)
+			saved[i] = info
+		}(i, fileHeader)
 	}
 
 	wg.Wait()
 	close(errs)
 
-	var uploadErrors []string
+	var uploadErrors []error
 	for err := range errs {
-		uploadErrors = append(uploadErrors, err.Error())
+		uploadErrors = append(uploadErrors, err)
 	}
 
 	if len(uploadErrors) > 0 {
-		return fmt.Errorf("%v", strings.Join(uploadErrors, "; "))
+		return nil, errors.Join(uploadErrors...)
+	}
+	return saved, nil
+}
+
+// uploadErrorStatus выбирает код ответа для ошибки загрузки в обработчиках без JSON API
+func uploadErrorStatus(err error, fallback int) int {
+	for _, e := range storageErrors {
+		if errors.Is(err, e.err) {
+			return e.status
+		}
 	}
-	return nil
+	return fallback
 }
 
 // renderTemplate рендерит HTML шаблон из кеша
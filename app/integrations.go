@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Роуты интеграций со сторонними программами снятия скриншотов
+const (
+	IntegrationUploadPath = "/integrations/upload"
+	ShareXConfigPath      = "/integrations/sharex.sxcu"
+	FlameshotScriptPath   = "/integrations/flameshot.sh"
+)
+
+// IntegrationUploadResponse - ответ загрузки в формате, который ждут ShareX и подобные программы
+type IntegrationUploadResponse struct {
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	DeletionURL  string `json:"deletion_url"`
+	AlbumURL     string `json:"album_url"`
+}
+
+// registerIntegrationRoutes регистрирует роуты интеграций
+func registerIntegrationRoutes(mux *http.ServeMux) {
+	handleRoute(mux, "POST "+IntegrationUploadPath, integrationUploadHandler)
+	handleRoute(mux, "POST "+ShareXConfigPath, shareXConfigHandler)
+	handleRoute(mux, "POST "+FlameshotScriptPath, flameshotScriptHandler)
+}
+
+// integrationUploadHandler принимает одно изображение из поля image по токену
+// и отвечает ссылками в плоском JSON
+func integrationUploadHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := apiRequireCaller(w, r, ScopeUpload)
+	if !ok {
+		return
+	}
+	sessionID := caller.SessionID
+
+	albumID := r.Header.Get(AlbumHeaderName)
+	if albumID == "" {
+		albumID = r.URL.Query().Get("album")
+	}
+	if albumID != "" && !albumExists(sessionID, albumID) {
+		ErrorResponse(w, http.StatusNotFound, ErrCodeAlbumNotFound, "album not found")
+		return
+	}
+
+	// Запас сверх MaxFileSize на служебные части multipart формы
+	r.Body = http.MaxBytesReader(w, r.Body, MaxFileSize+64*1024)
+	if err := r.ParseMultipartForm(MaxFileSize); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			ErrorResponse(w, http.StatusRequestEntityTooLarge, ErrCodeFileTooLarge, ErrFileTooLarge.Error())
+			return
+		}
+		ErrorResponse(w, http.StatusBadRequest, ErrCodeBadRequest, "multipart form with an image field required")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	files := getUploadFiles(r)
+	if len(files) != 1 {
+		ErrorResponse(w, http.StatusBadRequest, ErrCodeBadRequest, "exactly one file in the image field required")
+		return
+	}
+
+	if albumID == "" {
+		var err error
+		albumID, err = createAlbum(sessionID)
+		if err != nil {
+			apiStorageError(w, err)
+			return
+		}
+	}
+
+	file, err := files[0].Open()
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+	defer file.Close()
+
+	info, err := saveImage(file, files[0], sessionID, albumID)
+	if err != nil {
+		apiStorageError(w, err)
+		return
+	}
+
+	albumURL := BaseURL(r) + "/" + sessionID + "/" + albumID
+	imageURL := albumURL + "/" + info.Filename
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(IntegrationUploadResponse{
+		URL:          imageURL,
+		ThumbnailURL: imageURL,
+		DeletionURL:  deletionURL(r, info),
+		AlbumURL:     albumURL,
+	})
+}
+
+// integrationTarget выпускает токен для программы и определяет альбом для скриншотов.
+// Если альбом не выбран, создается новый альбом с названием по умолчанию.
+func integrationTarget(w http.ResponseWriter, r *http.Request, tool string) (secret, albumID string, ok bool) {
+	caller, ok := requireCaller(w, r, "")
+	if !ok {
+		return "", "", false
+	}
+	if !caller.IsOwner() {
+		http.Error(w, "Tokens cannot create integrations", http.StatusForbidden)
+		return "", "", false
+	}
+	sessionID := caller.SessionID
+
+	albumID = r.FormValue("album")
+	if albumID != "" && !albumExists(sessionID, albumID) {
+		http.Error(w, "Album not found", http.StatusNotFound)
+		return "", "", false
+	}
+	if albumID == "" {
+		var err error
+		albumID, err = createAlbum(sessionID)
+		if err == nil {
+			err = updateAlbumMeta(sessionID, albumID, func(meta *AlbumMeta) { meta.Name = "Screenshots" })
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error creating album: %v", err), uploadErrorStatus(err, http.StatusInternalServerError))
+			return "", "", false
+		}
+	}
+
+	_, secret, err := tokens.Create(sessionID, tool, []string{ScopeUpload, ScopeDelete}, nil)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error creating token: %v", err), http.StatusInternalServerError)
+		return "", "", false
+	}
+	return secret, albumID, true
+}
+
+// shareXConfigHandler отдает файл custom uploader для ShareX с новым токеном
+func shareXConfigHandler(w http.ResponseWriter, r *http.Request) {
+	secret, albumID, ok := integrationTarget(w, r, "ShareX")
+	if !ok {
+		return
+	}
+
+	config := map[string]interface{}{
+		"Version":         "15.0.0",
+		"Name":            "ripx (" + r.Host + ")",
+		"DestinationType": "ImageUploader",
+		"RequestMethod":   "POST",
+		"RequestURL":      BaseURL(r) + IntegrationUploadPath,
+		"Parameters":      map[string]string{"album": albumID},
+		"Headers":         map[string]string{"Authorization": "Bearer " + secret},
+		"Body":            "MultipartFormData",
+		"FileFormName":    "image",
+		"URL":             "{json:url}",
+		"ThumbnailURL":    "{json:thumbnail_url}",
+		"DeletionURL":     "{json:deletion_url}",
+		"ErrorMessage":    "{json:error.message}",
+	}
+
+	setAttachment(w, "ripx.sxcu")
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(config)
+}
+
+// flameshotScriptHandler отдает shell скрипт для Flameshot и других программ,
+// которые умеют сохранять скриншот в файл или stdout
+func flameshotScriptHandler(w http.ResponseWriter, r *http.Request) {
+	secret, albumID, ok := integrationTarget(w, r, "Flameshot")
+	if !ok {
+		return
+	}
+
+	uploadURL := BaseURL(r) + IntegrationUploadPath + "?album=" + url.QueryEscape(albumID)
+	script := fmt.Sprintf(`#!/bin/sh
+# ripx uploader for Flameshot. Bind it to a hotkey, or pass a file to upload it:
+#   ripx-upload.sh            - take a screenshot with flameshot gui
+#   ripx-upload.sh shot.png   - upload an existing file
+set -e
+
+RIPX_URL=%s
+RIPX_TOKEN=%s
+
+if [ -n "$1" ]; then
+  file="$1"
+else
+  file=$(mktemp "${TMPDIR:-/tmp}/ripx-XXXXXX.png")
+  trap 'rm -f "$file"' EXIT
+  flameshot gui --raw > "$file"
+  [ -s "$file" ] || exit 0
+fi
+
+response=$(curl -sS -H "Authorization: Bearer $RIPX_TOKEN" -F "image=@$file" "$RIPX_URL")
+url=$(printf '%%s' "$response" | sed -n 's/.*"url":"\([^"]*\)".*/\1/p')
+if [ -z "$url" ]; then
+  echo "ripx: upload failed: $response" >&2
+  exit 1
+fi
+
+printf '%%s' "$url" | (wl-copy 2>/dev/null || xclip -selection clipboard 2>/dev/null || pbcopy 2>/dev/null || true)
+command -v notify-send >/dev/null && notify-send "ripx" "$url"
+echo "$url"
+`, shellQuote(uploadURL), shellQuote(secret))
+
+	setAttachment(w, "ripx-upload.sh")
+	w.Header().Set("Content-Type", "text/x-shellscript; charset=utf-8")
+	w.Write([]byte(script))
+}
+
+// setAttachment просит браузер сохранить ответ как файл
+func setAttachment(w http.ResponseWriter, filename string) {
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// shellQuote заключает строку в одинарные кавычки для sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// newIntegrationBrowser создает браузер с новой сессией
+func newIntegrationBrowser(t *testing.T) *testBrowser {
+	t.Helper()
+	b := newTestBrowser(t)
+	if status, body := b.do("POST", "/api/v1/sessions", nil); status != http.StatusCreated {
+		t.Fatalf("create session: %d %s", status, body)
+	}
+	return b
+}
+
+// checkIntegrationToken проверяет, что токен принадлежит сессии и может только загружать и удалять
+func checkIntegrationToken(t *testing.T, secret, sessionID string) {
+	t.Helper()
+	token, err := tokens.Authenticate(secret)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if token.SessionID != sessionID || token.Owner || !slices.Equal(token.Scopes, []string{ScopeUpload, ScopeDelete}) {
+		t.Errorf("integration token %+v, want scopes upload and delete of session %s", token, sessionID)
+	}
+}
+
+// integrationUpload загружает изображение так, как это делают ShareX и скрипт Flameshot
+func integrationUpload(t *testing.T, uploadURL, secret string, content []byte) (int, IntegrationUploadResponse) {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("image", "shot.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	mw.Close()
+
+	req, err := http.NewRequest(http.MethodPost, uploadURL, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+secret)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var result IntegrationUploadResponse
+	json.NewDecoder(resp.Body).Decode(&result)
+	return resp.StatusCode, result
+}
+
+func TestShareXConfig(t *testing.T) {
+	b := newIntegrationBrowser(t)
+	albumID, err := createAlbum(b.sessionID())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, body := b.do("POST", ShareXConfigPath+"?album="+albumID, nil)
+	if status != http.StatusOK {
+		t.Fatalf("sharex config: %d %s", status, body)
+	}
+	var config struct {
+		RequestURL   string
+		Parameters   map[string]string
+		Headers      map[string]string
+		FileFormName string
+	}
+	if err := json.Unmarshal(body, &config); err != nil {
+		t.Fatalf("invalid .sxcu: %v", err)
+	}
+	if config.RequestURL != testServer.URL+IntegrationUploadPath || config.Parameters["album"] != albumID || config.FileFormName != "image" {
+		t.Errorf("unexpected config %+v", config)
+	}
+	secret, ok := strings.CutPrefix(config.Headers["Authorization"], "Bearer ")
+	if !ok {
+		t.Fatalf("no bearer token in %q", config.Headers["Authorization"])
+	}
+	checkIntegrationToken(t, secret, b.sessionID())
+
+	// Загрузка с настройками из файла
+	status, result := integrationUpload(t, config.RequestURL+"?album="+config.Parameters["album"], secret, testPNG(t, 60))
+	if status != http.StatusCreated || !strings.HasPrefix(result.URL, testServer.URL+"/"+b.sessionID()+"/"+albumID+"/") || result.DeletionURL == "" {
+		t.Errorf("upload with .sxcu settings: %d %+v", status, result)
+	}
+
+	// Токен из файла не управляет токенами сессии
+	if status := getWithToken(t, "/api/v1/tokens", "Bearer "+secret); status != http.StatusForbidden {
+		t.Errorf("list tokens with integration token: %d, want 403", status)
+	}
+	if status, _ := b.do("POST", ShareXConfigPath+"?album="+RandomID(), nil); status != http.StatusNotFound {
+		t.Errorf("sharex config for an unknown album: %d, want 404", status)
+	}
+}
+
+func TestFlameshotScript(t *testing.T) {
+	b := newIntegrationBrowser(t)
+
+	// Без выбранного альбома создается новый альбом для скриншотов
+	status, body := b.do("POST", FlameshotScriptPath, nil)
+	if status != http.StatusOK {
+		t.Fatalf("flameshot script: %d %s", status, body)
+	}
+	script := string(body)
+	if !strings.HasPrefix(script, "#!/bin/sh\n") {
+		t.Fatalf("not a shell script: %q", script)
+	}
+	uploadURL := regexp.MustCompile(`(?m)^RIPX_URL='([^']*)'$`).FindStringSubmatch(script)
+	secret := regexp.MustCompile(`(?m)^RIPX_TOKEN='([^']*)'$`).FindStringSubmatch(script)
+	if uploadURL == nil || secret == nil {
+		t.Fatalf("script without RIPX_URL or RIPX_TOKEN:\n%s", script)
+	}
+	checkIntegrationToken(t, secret[1], b.sessionID())
+
+	albums, err := getUserAlbums(b.sessionID())
+	if err != nil || len(albums) != 1 {
+		t.Fatalf("albums after flameshot script: %+v, %v", albums, err)
+	}
+	if meta, _ := loadAlbumMeta(b.sessionID(), albums[0].ID); meta == nil || meta.Name != "Screenshots" {
+		t.Errorf("new album metadata %+v, want name Screenshots", meta)
+	}
+	if status, result := integrationUpload(t, uploadURL[1], secret[1], testPNG(t, 61)); status != http.StatusCreated || result.URL == "" {
+		t.Errorf("upload with script settings: %d %+v", status, result)
+	}
+}
+
+func TestIntegrationNeedsOwner(t *testing.T) {
+	b := newIntegrationBrowser(t)
+	_, secret, err := tokens.Create(b.sessionID(), "ci", []string{ScopeUpload}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, testServer.URL+ShareXConfigPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+secret)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("sharex config with a scoped token: %d, want 403", resp.StatusCode)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := map[string]string{
+		"ripx_abc":           `'ripx_abc'`,
+		"it's":               `'it'\''s'`,
+		"$(rm -rf /) `x` \\": `'$(rm -rf /) ` + "`x`" + ` \'`,
+	}
+	for in, want := range tests {
+		if got := shellQuote(in); got != want {
+			t.Errorf("shellQuote(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrInvalidInvite - код приглашения не найден, истек или исчерпан
+var ErrInvalidInvite = errors.New("invalid, expired or used up invite code")
+
+// Invite - приглашение, выпущенное администратором. Код хранится только как SHA-256.
+type Invite struct {
+	ID        string     `json:"id"`
+	Note      string     `json:"note,omitempty"`
+	Hash      string     `json:"hash"`
+	MaxUses   int        `json:"max_uses"` // 0 - без ограничения
+	Uses      int        `json:"uses"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Expired сообщает, истек ли срок действия приглашения
+func (i *Invite) Expired() bool {
+	return i.ExpiresAt != nil && time.Now().After(*i.ExpiresAt)
+}
+
+// Usable сообщает, можно ли еще активировать приглашение
+func (i *Invite) Usable() bool {
+	return !i.Expired() && (i.MaxUses == 0 || i.Uses < i.MaxUses)
+}
+
+// inviteStore хранит приглашения в StatePath/InvitesStateFile
+type inviteStore struct {
+	mu      sync.Mutex
+	loaded  bool
+	invites []*Invite
+}
+
+var invites = &inviteStore{}
+
+// load лениво читает приглашения с диска (вызывается под мьютексом)
+func (s *inviteStore) load() error {
+	if s.loaded {
+		return nil
+	}
+	if err := loadState(InvitesStateFile, &s.invites); err != nil {
+		return err
+	}
+	s.loaded = true
+	return nil
+}
+
+// save записывает приглашения на диск, попутно выбрасывая истекшие (вызывается под мьютексом).
+// Исчерпанные остаются, чтобы администратор видел, сколько раз ими воспользовались.
+func (s *inviteStore) save() error {
+	s.invites = slices.DeleteFunc(s.invites, func(i *Invite) bool { return i.Expired() })
+	return saveState(InvitesStateFile, s.invites)
+}
+
+// Create выпускает приглашение и возвращает его вместе с открытым кодом
+func (s *inviteStore) Create(note string, maxUses int, expiresAt *time.Time) (*Invite, string, error) {
+	id, err := RandomToken(8)
+	if err != nil {
+		return nil, "", err
+	}
+	code, err := randomCode(InviteCodeLen)
+	if err != nil {
+		return nil, "", err
+	}
+
+	invite := &Invite{
+		ID:        id,
+		Note:      note,
+		Hash:      hashToken(normalizeCode(code)),
+		MaxUses:   maxUses,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, "", err
+	}
+	s.invites = append(s.invites, invite)
+	if err := s.save(); err != nil {
+		return nil, "", err
+	}
+	return invite, code, nil
+}
+
+// List возвращает действующие и исчерпанные приглашения
+func (s *inviteStore) List() ([]Invite, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	list := []Invite{}
+	for _, invite := range s.invites {
+		if !invite.Expired() {
+			list = append(list, *invite)
+		}
+	}
+	return list, nil
+}
+
+// Revoke отзывает приглашение; уже активированные сессии сохраняют право загрузки
+func (s *inviteStore) Revoke(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return false, err
+	}
+
+	before := len(s.invites)
+	s.invites = slices.DeleteFunc(s.invites, func(i *Invite) bool { return i.ID == id })
+	if len(s.invites) == before {
+		return false, nil
+	}
+	return true, s.save()
+}
+
+// Redeem засчитывает одно использование приглашения по коду
+func (s *inviteStore) Redeem(code string) (*Invite, error) {
+	hash := hashToken(normalizeCode(code))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	for _, invite := range s.invites {
+		if invite.Hash != hash {
+			continue
+		}
+		if !invite.Usable() {
+			return nil, ErrInvalidInvite
+		}
+		invite.Uses++
+		if err := s.save(); err != nil {
+			return nil, err
+		}
+		found := *invite
+		return &found, nil
+	}
+	return nil, ErrInvalidInvite
+}
+
+// InviteResource - представление приглашения в API (без хеша)
+type InviteResource struct {
+	ID        string     `json:"id"`
+	Note      string     `json:"note,omitempty"`
+	MaxUses   int        `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Code      string     `json:"code,omitempty"` // только в ответе на создание
+}
+
+func inviteResource(invite Invite) InviteResource {
+	return InviteResource{
+		ID:        invite.ID,
+		Note:      invite.Note,
+		MaxUses:   invite.MaxUses,
+		Uses:      invite.Uses,
+		CreatedAt: invite.CreatedAt,
+		ExpiresAt: invite.ExpiresAt,
+	}
+}
+
+// apiListInvites возвращает приглашения (только администратору)
+func apiListInvites(w http.ResponseWriter, r *http.Request) {
+	if !apiRequireAdmin(w, r) {
+		return
+	}
+
+	list, err := invites.List()
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	resources := make([]InviteResource, 0, len(list))
+	for _, invite := range list {
+		resources = append(resources, inviteResource(invite))
+	}
+	apiData(w, http.StatusOK, resources)
+}
+
+// inviteCreateRequest - параметры нового приглашения; без max_uses приглашение одноразовое
+type inviteCreateRequest struct {
+	Note      string     `json:"note"`
+	MaxUses   *int       `json:"max_uses"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// apiCreateInvite выпускает приглашение; код возвращается только в этом ответе
+func apiCreateInvite(w http.ResponseWriter, r *http.Request) {
+	if !apiRequireAdmin(w, r) {
+		return
+	}
+
+	var req inviteCreateRequest
+	if !apiDecodeJSON(w, r, &req) {
+		return
+	}
+
+	note := strings.TrimSpace(req.Note)
+	if utf8.RuneCountInString(note) > MaxAlbumNameLen {
+		ErrorResponse(w, http.StatusBadRequest, ErrCodeBadRequest,
+			fmt.Sprintf("note must be at most %d characters", MaxAlbumNameLen))
+		return
+	}
+	maxUses := 1
+	if req.MaxUses != nil {
+		maxUses = *req.MaxUses
+	}
+	if maxUses < 0 {
+		ErrorResponse(w, http.StatusBadRequest, ErrCodeBadRequest, "max_uses must be 0 (unlimited) or positive")
+		return
+	}
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		ErrorResponse(w, http.StatusBadRequest, ErrCodeBadRequest, "expires_at must be in the future")
+		return
+	}
+
+	invite, code, err := invites.Create(note, maxUses, req.ExpiresAt)
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	logger.Info(fmt.Sprintf("Invite %s created (max uses %d)", invite.ID, invite.MaxUses))
+	resource := inviteResource(*invite)
+	resource.Code = code
+	apiData(w, http.StatusCreated, resource)
+}
+
+// apiRevokeInvite отзывает приглашение
+func apiRevokeInvite(w http.ResponseWriter, r *http.Request) {
+	if !apiRequireAdmin(w, r) {
+		return
+	}
+
+	revoked, err := invites.Revoke(r.PathValue("id"))
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+	if !revoked {
+		ErrorResponse(w, http.StatusNotFound, ErrCodeInviteNotFound, "invite not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// inviteRedeemRequest - код приглашения
+type inviteRedeemRequest struct {
+	Code string `json:"code"`
+}
+
+// apiRedeemInvite активирует приглашение для сессии клиента. Браузер без сессии
+// получает новую, но только после проверки кода: неверный код не создает сессий.
+// Повторная активация не тратит использование приглашения.
+func apiRedeemInvite(w http.ResponseWriter, r *http.Request) {
+	var req inviteRedeemRequest
+	if !apiDecodeJSON(w, r, &req) {
+		return
+	}
+
+	var caller *Caller
+	if _, err := authenticate(r); !errors.Is(err, ErrNoCredentials) {
+		var ok bool
+		if caller, ok = apiSessionOwner(w, r); !ok {
+			return
+		}
+	}
+
+	if caller == nil || !sessions.Invited(caller.SessionID) {
+		invite, err := invites.Redeem(req.Code)
+		if errors.Is(err, ErrInvalidInvite) {
+			ErrorResponse(w, http.StatusBadRequest, ErrCodeInvalidCode, err.Error())
+			return
+		}
+		if err != nil {
+			apiInternalError(w, err)
+			return
+		}
+
+		if caller == nil {
+			sessionID, err := createSession()
+			if err != nil {
+				apiInternalError(w, err)
+				return
+			}
+			caller = &Caller{SessionID: sessionID}
+			setNewSessionCookies(w, sessionID)
+		}
+		if err := sessions.SetInvite(caller.SessionID, invite.ID); err != nil {
+			apiInternalError(w, err)
+			return
+		}
+		logger.Info(fmt.Sprintf("Invite %s redeemed by session %s", invite.ID, caller.SessionID))
+	}
+
+	apiData(w, http.StatusOK, map[string]interface{}{
+		"session_id": caller.SessionID,
+		"invited":    true,
+	})
+}
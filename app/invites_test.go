@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRedeemInviteValidatesCodeFirst(t *testing.T) {
+	invite, code, err := invites.Create("test", 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Неверный код не создает ни сессии, ни cookie
+	b := newTestBrowser(t)
+	before := countSessionDirs(t)
+	if status, body := b.do("POST", "/api/v1/invite", map[string]string{"code": "WRONG-CODE"}); status != http.StatusBadRequest {
+		t.Fatalf("wrong code: %d %s, want 400", status, body)
+	}
+	if b.sessionID() != "" {
+		t.Errorf("wrong code set session cookie %q", b.sessionID())
+	}
+	if after := countSessionDirs(t); after != before {
+		t.Errorf("wrong code created %d session directories", after-before)
+	}
+
+	if status, body := b.do("POST", "/api/v1/invite", map[string]string{"code": code}); status != http.StatusOK {
+		t.Fatalf("valid code: %d %s", status, body)
+	}
+	sessionID := b.sessionID()
+	if sessionID == "" || !sessions.Invited(sessionID) {
+		t.Fatalf("session %q is not invited after redeem", sessionID)
+	}
+
+	// Повторная активация в той же сессии не тратит использование
+	if status, body := b.do("POST", "/api/v1/invite", map[string]string{"code": code}); status != http.StatusOK {
+		t.Fatalf("second redeem: %d %s", status, body)
+	}
+	list, err := invites.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range list {
+		if item.ID == invite.ID && item.Uses != 1 {
+			t.Errorf("invite uses %d, want 1", item.Uses)
+		}
+	}
+}
@@ -14,6 +14,11 @@ import (
 var templates *template.Template
 
 func main() {
+	// Режим консольного клиента: ripx upload ...
+	if len(os.Args) > 1 && os.Args[1] == "upload" {
+		os.Exit(runUploadCommand(os.Args[2:]))
+	}
+
 	// Инициализация приложения
 	if err := initializeApp(); err != nil {
 		fmt.Printf("Failed to initialize app: %v\n", err)
@@ -21,12 +26,18 @@ func main() {
 	}
 
 	// Создание HTTP роутера
-	mux := setupRoutes()
+	handler := setupRoutes()
+
+	// Сверка роутов с OpenAPI спецификацией
+	if err := checkOpenAPISpec(registeredRoutes); err != nil {
+		logger.Error(err.Error())
+	}
 
 	// Запуск cleanup worker
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	go startCleanupWorker(ctx)
+	go startDiskMonitor(ctx)
 
 	// Настройка graceful shutdown
 	sigChan := make(chan os.Signal, 1)
@@ -36,7 +47,7 @@ func main() {
 	serverErr := make(chan error, 1)
 	go func() {
 		fmt.Printf("Server starting on %s\n", ServerAddr)
-		serverErr <- http.ListenAndServe(ServerAddr, mux)
+		serverErr <- http.ListenAndServe(ServerAddr, handler)
 	}()
 
 	// Ожидание сигнала или ошибки
@@ -49,6 +60,11 @@ func main() {
 
 	fmt.Println("Shutting down gracefully...")
 	cancel()
+
+	// Время просмотров альбомов копится в памяти
+	if err := albumAccess.Flush(); err != nil {
+		logger.Error("Failed to save album access times: " + err.Error())
+	}
 }
 
 // initializeApp инициализирует приложение
@@ -59,8 +75,48 @@ func initializeApp() error {
 	}
 
 	// Подсчет общего количества изображений при запуске приложения
-	TotalImageCount = countAllFilesInDataPath()
-	logger.Info(fmt.Sprintf("Total images on startup: %d", TotalImageCount))
+	TotalImageCount.Store(int64(countAllFilesInDataPath()))
+	logger.Info(fmt.Sprintf("Total images on startup: %d", TotalImageCount.Load()))
+
+	// Проверка настроек входа
+	if err := checkAuthConfig(); err != nil {
+		return err
+	}
+
+	// Ключ CSRF токенов
+	if err := setupCSRF(); err != nil {
+		return err
+	}
+
+	// Хеши заблокированного контента из файла
+	if err := blocklist.LoadFile(); err != nil {
+		return fmt.Errorf("failed to load blocklist: %w", err)
+	}
+
+	// Проверка загрузок антивирусом
+	if err := setupScanner(); err != nil {
+		return err
+	}
+
+	// Бюджеты запросов
+	if err := setupRateLimits(); err != nil {
+		return err
+	}
+
+	// Квоты владельцев по умолчанию
+	if err := setupQuotas(); err != nil {
+		return err
+	}
+
+	// Границы свободного места на диске
+	if err := setupDiskWatermarks(); err != nil {
+		return err
+	}
+
+	// Политика хранения
+	if err := setupRetention(); err != nil {
+		return err
+	}
 
 	// Проверка доступности директории шаблонов
 	if err := checkTemplates(); err != nil {
@@ -80,23 +136,45 @@ func checkTemplates() error {
 	return nil
 }
 
-// setupRoutes настраивает HTTP роуты
-func setupRoutes() *http.ServeMux {
+// setupRoutes настраивает HTTP роуты и общие проверки запросов
+func setupRoutes() http.Handler {
 	mux := http.NewServeMux()
 
 	// Статические файлы
-	mux.HandleFunc("/static/", handleStaticFiles)
+	handleRoute(mux, "/static/", handleStaticFiles)
 
 	// API endpoints
-	mux.HandleFunc("/", indexHandler)
-	mux.HandleFunc("/upload", uploadHandler)
-	mux.HandleFunc("/create-album", createAlbumHandler)
-	mux.HandleFunc("/delete-image", deleteImageHandler)
-	mux.HandleFunc("/delete-album", deleteAlbumHandler)
-	mux.HandleFunc("/delete-user", deleteUserHandler)
-	mux.HandleFunc("/changelog", changelogHandler)
-
-	return mux
+	handleRoute(mux, "/", indexHandler)
+	handleRoute(mux, "/upload", uploadHandler)
+	handleRoute(mux, "/put/", putHandler)
+	handleRoute(mux, "/create-album", createAlbumHandler)
+	handleRoute(mux, "/delete-image", deleteImageHandler)
+	handleRoute(mux, "/delete-album", deleteAlbumHandler)
+	handleRoute(mux, "/album-privacy", albumPrivacyHandler)
+	handleRoute(mux, "/delete-user", deleteUserHandler)
+	handleRoute(mux, "/changelog", changelogHandler)
+
+	// Удаление по ссылке, выданной при загрузке
+	registerDeletionRoutes(mux)
+
+	// Вход через OpenID Connect провайдера
+	registerOIDCRoutes(mux)
+
+	// Админка (Basic auth администратора, отдельно от сессий пользователей)
+	registerAdminRoutes(mux)
+
+	// Загрузка из ShareX, Flameshot и других программ
+	registerIntegrationRoutes(mux)
+
+	// Версионированный JSON API
+	registerAPIRoutes(mux)
+
+	// Счетчики для Prometheus (Basic auth администратора)
+	handleRoute(mux, "GET /metrics", metricsHandler)
+
+	// Без пользователя от прокси (при RIPX_PROXY_AUTH_FALLBACK=deny) и без CSRF токена дальше не пускаем,
+	// сверх бюджета запросов - тоже
+	return proxyAuthMiddleware(rateLimitMiddleware(mux, csrfMiddleware(mux)))
 }
 
 // handleStaticFiles обрабатывает статические файлы
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testServer обслуживает setupRoutes() поверх временного хранилища
+var testServer *httptest.Server
+
+// TestMain поднимает приложение так же, как main, но с данными во временной директории
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "ripx-test-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	DataPath = dir
+	StatePath = filepath.Join(dir, ".ripx")
+
+	if err := initializeApp(); err != nil {
+		fmt.Fprintf(os.Stderr, "initializeApp: %v\n", err)
+		os.RemoveAll(dir)
+		os.Exit(1)
+	}
+	// Тесты делают много запросов подряд с одного адреса
+	rateLimiters = map[string]*rateLimiter{}
+
+	testServer = httptest.NewServer(setupRoutes())
+	code := m.Run()
+	testServer.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// testPNG возвращает маленькое PNG изображение; seed меняет его содержимое
+func testPNG(t testing.TB, seed int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for x := 0; x < 16; x++ {
+		for y := 0; y < 16; y++ {
+			img.Set(x, y, color.RGBA{uint8(x * seed), uint8(y * 16), uint8(seed), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testBrowser ходит на testServer с cookie и, как страницы сайта, передает CSRF токен своей сессии
+type testBrowser struct {
+	t      *testing.T
+	client *http.Client
+}
+
+func newTestBrowser(t *testing.T) *testBrowser {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testBrowser{t: t, client: &http.Client{Jar: jar}}
+}
+
+// sessionID возвращает ID сессии из cookie браузера
+func (b *testBrowser) sessionID() string {
+	u, _ := url.Parse(testServer.URL)
+	for _, cookie := range b.client.Jar.Cookies(u) {
+		if cookie.Name == SessionCookieName {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// do отправляет запрос с JSON телом (если body не nil) и возвращает код и тело ответа
+func (b *testBrowser) do(method, path string, body any) (int, []byte) {
+	b.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			b.t.Fatal(err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, testServer.URL+path, reader)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if sessionID := b.sessionID(); sessionID != "" {
+		req.Header.Set(CSRFHeaderName, csrfToken(sessionID))
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	return resp.StatusCode, raw
+}
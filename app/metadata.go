@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// AlbumMetaFilename - имя служебного файла с метаданными альбома
+const AlbumMetaFilename = ".album.json"
+
+// AlbumMeta хранит метаданные альбома и его изображений
+type AlbumMeta struct {
+	Name    string               `json:"name,omitempty"`
+	Private bool                 `json:"private,omitempty"`
+	Pinned  bool                 `json:"pinned,omitempty"` // администратор исключил альбом из вытеснения
+	Images  map[string]ImageMeta `json:"images,omitempty"`
+}
+
+// ImageMeta хранит метаданные одного изображения
+type ImageMeta struct {
+	OriginalName string      `json:"original_name,omitempty"`
+	UploadedAt   time.Time   `json:"uploaded_at"`
+	DeleteHash   string      `json:"delete_hash,omitempty"` // SHA-256 ключа из ссылки удаления
+	SHA256       string      `json:"sha256,omitempty"`      // хеши для сверки с блоклистом
+	PHash        string      `json:"phash,omitempty"`
+	Scan         *ScanResult `json:"scan,omitempty"` // nil, если сканер не настроен
+}
+
+// albumMetaMutex сериализует чтение-изменение-запись файлов метаданных
+var albumMetaMutex sync.Mutex
+
+func albumMetaPath(userID, albumID string) string {
+	return filepath.Join(albumPath(userID, albumID), AlbumMetaFilename)
+}
+
+// loadAlbumMeta читает метаданные альбома (пустые, если файла нет)
+func loadAlbumMeta(userID, albumID string) (*AlbumMeta, error) {
+	meta := &AlbumMeta{Images: map[string]ImageMeta{}}
+
+	data, err := os.ReadFile(albumMetaPath(userID, albumID))
+	if os.IsNotExist(err) {
+		return meta, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, err
+	}
+	if meta.Images == nil {
+		meta.Images = map[string]ImageMeta{}
+	}
+	return meta, nil
+}
+
+// updateAlbumMeta атомарно изменяет метаданные альбома
+func updateAlbumMeta(userID, albumID string, update func(meta *AlbumMeta)) error {
+	albumMetaMutex.Lock()
+	defer albumMetaMutex.Unlock()
+
+	meta, err := loadAlbumMeta(userID, albumID)
+	if err != nil {
+		return err
+	}
+	update(meta)
+	return writeAlbumMeta(userID, albumID, meta)
+}
+
+// writeAlbumMeta записывает метаданные альбома (вызывается под albumMetaMutex)
+func writeAlbumMeta(userID, albumID string, meta *AlbumMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	// Пишем во временный файл и переименовываем, чтобы не оставить обрезанный JSON
+	path := albumMetaPath(userID, albumID)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// pruneAlbumMeta убирает из метаданных удаленные файлы. Если в альбоме не осталось
+// изображений, удаляются метаданные и сама директория альбома.
+func pruneAlbumMeta(userID, albumID string, filenames []string) error {
+	albumMetaMutex.Lock()
+	defer albumMetaMutex.Unlock()
+
+	albumDir := albumPath(userID, albumID)
+	entries, err := os.ReadDir(albumDir)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(entries, func(entry os.DirEntry) bool {
+		return !entry.IsDir() && IsImageFile(entry.Name())
+	}) {
+		if err := os.Remove(albumMetaPath(userID, albumID)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		// Директория может быть уже не пустой, если в альбом как раз загружают файл
+		os.Remove(albumDir)
+		return nil
+	}
+
+	meta, err := loadAlbumMeta(userID, albumID)
+	if err != nil {
+		return err
+	}
+	for _, filename := range filenames {
+		delete(meta.Images, filename)
+	}
+	return writeAlbumMeta(userID, albumID, meta)
+}
+
+// isAlbumPrivate сообщает, скрыт ли альбом от всех, кроме владельца
+func isAlbumPrivate(userID, albumID string) bool {
+	meta, err := loadAlbumMeta(userID, albumID)
+	if err != nil {
+		logger.Error(fmt.Sprintf("isAlbumPrivate: failed to load metadata: %v", err))
+		// При ошибке чтения считаем альбом приватным, чтобы не раскрыть его
+		return true
+	}
+	return meta.Private
+}
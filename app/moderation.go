@@ -0,0 +1,395 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Статусы изображения в очереди модерации
+const (
+	ModerationOpen        = "open"        // есть жалобы, изображение доступно
+	ModerationQuarantined = "quarantined" // скрыто администратором до решения, отдается с 451
+)
+
+// ReportReasons - допустимые причины жалобы
+var ReportReasons = []string{"illegal", "copyright", "abuse", "spam", "other"}
+
+// ReportReasonBlocklist - причина жалоб, которые создает повторная проверка по блоклисту
+const ReportReasonBlocklist = "blocklist"
+
+// ErrReportNotFound - в очереди нет такого элемента
+var ErrReportNotFound = errors.New("report not found")
+
+// ErrUnderReview - изображение скрыто до решения по жалобе, и владелец не может его удалить
+var ErrUnderReview = errors.New("image is under review and cannot be deleted until the report is resolved")
+
+// Report - одна жалоба на изображение
+type Report struct {
+	Reason    string    `json:"reason"`
+	Details   string    `json:"details,omitempty"`
+	Session   string    `json:"session,omitempty"` // сессия пожаловавшегося, если она есть
+	Addr      string    `json:"addr"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ModerationItem - изображение в очереди модерации вместе со всеми жалобами на него
+type ModerationItem struct {
+	ID        string    `json:"id"`
+	SessionID string    `json:"session_id"`
+	AlbumID   string    `json:"album_id"`
+	Filename  string    `json:"filename"`
+	Status    string    `json:"status"`
+	Reports   []Report  `json:"reports"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// is сообщает, относится ли элемент к указанному изображению
+func (m *ModerationItem) is(sessionID, albumID, filename string) bool {
+	return m.SessionID == sessionID && m.AlbumID == albumID && m.Filename == filename
+}
+
+// reportedBy сообщает, жаловался ли уже этот клиент
+func (m *ModerationItem) reportedBy(report Report) bool {
+	return slices.ContainsFunc(m.Reports, func(prev Report) bool {
+		if report.Session != "" {
+			return prev.Session == report.Session
+		}
+		return prev.Session == "" && prev.Addr == report.Addr
+	})
+}
+
+// moderationStore хранит очередь модерации в StatePath/ModerationStateFile.
+// Решенные элементы удаляются из очереди, решение остается в логе.
+type moderationStore struct {
+	mu     sync.Mutex
+	loaded bool
+	items  []*ModerationItem
+}
+
+var moderation = &moderationStore{}
+
+// load лениво читает очередь с диска (вызывается под мьютексом)
+func (s *moderationStore) load() error {
+	if s.loaded {
+		return nil
+	}
+	if err := loadState(ModerationStateFile, &s.items); err != nil {
+		return err
+	}
+	s.loaded = true
+	return nil
+}
+
+// save записывает очередь на диск (вызывается под мьютексом)
+func (s *moderationStore) save() error {
+	return saveState(ModerationStateFile, s.items)
+}
+
+// find ищет элемент по изображению (вызывается под мьютексом)
+func (s *moderationStore) find(sessionID, albumID, filename string) *ModerationItem {
+	for _, item := range s.items {
+		if item.is(sessionID, albumID, filename) {
+			return item
+		}
+	}
+	return nil
+}
+
+// Add записывает жалобу. Повторная жалоба того же клиента и жалобы сверх
+// MaxReportsPerItem не сохраняются, но и не считаются ошибкой.
+func (s *moderationStore) Add(sessionID, albumID, filename string, report Report) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	item := s.find(sessionID, albumID, filename)
+	if item == nil {
+		id, err := RandomToken(8)
+		if err != nil {
+			return err
+		}
+		item = &ModerationItem{
+			ID:        id,
+			SessionID: sessionID,
+			AlbumID:   albumID,
+			Filename:  filename,
+			Status:    ModerationOpen,
+			CreatedAt: report.CreatedAt,
+		}
+		s.items = append(s.items, item)
+	} else if item.reportedBy(report) || len(item.Reports) >= MaxReportsPerItem {
+		return nil
+	}
+
+	item.Reports = append(item.Reports, report)
+	item.UpdatedAt = report.CreatedAt
+	return s.save()
+}
+
+// Flag скрывает изображение без участия администратора и записывает причину в очередь
+func (s *moderationStore) Flag(sessionID, albumID, filename string, report Report) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	item := s.find(sessionID, albumID, filename)
+	if item == nil {
+		id, err := RandomToken(8)
+		if err != nil {
+			return err
+		}
+		item = &ModerationItem{
+			ID:        id,
+			SessionID: sessionID,
+			AlbumID:   albumID,
+			Filename:  filename,
+			CreatedAt: report.CreatedAt,
+		}
+		s.items = append(s.items, item)
+	}
+	if item.Status == ModerationQuarantined && item.reportedBy(report) {
+		return nil
+	}
+
+	item.Status = ModerationQuarantined
+	if !item.reportedBy(report) {
+		item.Reports = append(item.Reports, report)
+	}
+	item.UpdatedAt = report.CreatedAt
+	return s.save()
+}
+
+// List возвращает очередь: сначала скрытые, затем по времени первой жалобы
+func (s *moderationStore) List() ([]ModerationItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	list := make([]ModerationItem, 0, len(s.items))
+	for _, item := range s.items {
+		list = append(list, *item)
+	}
+	slices.SortStableFunc(list, func(a, b ModerationItem) int {
+		if (a.Status == ModerationQuarantined) != (b.Status == ModerationQuarantined) {
+			if a.Status == ModerationQuarantined {
+				return -1
+			}
+			return 1
+		}
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return list, nil
+}
+
+// Get возвращает элемент очереди по ID
+func (s *moderationStore) Get(id string) (*ModerationItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	for _, item := range s.items {
+		if item.ID == id {
+			copied := *item
+			return &copied, nil
+		}
+	}
+	return nil, ErrReportNotFound
+}
+
+// Quarantine скрывает изображение до решения администратора
+func (s *moderationStore) Quarantine(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	for _, item := range s.items {
+		if item.ID == id {
+			item.Status = ModerationQuarantined
+			item.UpdatedAt = time.Now()
+			return s.save()
+		}
+	}
+	return ErrReportNotFound
+}
+
+// Resolve убирает элемент из очереди; изображение снова доступно, если оно еще есть
+func (s *moderationStore) Resolve(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	before := len(s.items)
+	s.items = slices.DeleteFunc(s.items, func(item *ModerationItem) bool { return item.ID == id })
+	if len(s.items) == before {
+		return ErrReportNotFound
+	}
+	return s.save()
+}
+
+// Quarantined сообщает, скрыто ли изображение
+func (s *moderationStore) Quarantined(sessionID, albumID, filename string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		logger.Error(fmt.Sprintf("moderation: %v", err))
+		return false
+	}
+
+	item := s.find(sessionID, albumID, filename)
+	return item != nil && item.Status == ModerationQuarantined
+}
+
+// UnderReview сообщает, есть ли скрытые изображения среди указанных. Пустые albumID и filename
+// означают весь альбом или всю сессию.
+func (s *moderationStore) UnderReview(sessionID, albumID, filename string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		logger.Error(fmt.Sprintf("moderation: %v", err))
+		// Не удаляем то, что не удалось проверить
+		return true
+	}
+
+	return slices.ContainsFunc(s.items, func(item *ModerationItem) bool {
+		return item.Status == ModerationQuarantined && item.SessionID == sessionID &&
+			(albumID == "" || item.AlbumID == albumID) && (filename == "" || item.Filename == filename)
+	})
+}
+
+// checkDeletable не дает владельцу удалить скрытые изображения: удаление уничтожило бы
+// доказательства до решения администратора. Пустые albumID и filename - как в UnderReview.
+func checkDeletable(sessionID, albumID, filename string) error {
+	if moderation.UnderReview(sessionID, albumID, filename) {
+		return ErrUnderReview
+	}
+	return nil
+}
+
+// QuarantinedInAlbum возвращает имена скрытых файлов альбома
+func (s *moderationStore) QuarantinedInAlbum(sessionID, albumID string) map[string]bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		logger.Error(fmt.Sprintf("moderation: %v", err))
+		return nil
+	}
+
+	hidden := make(map[string]bool)
+	for _, item := range s.items {
+		if item.SessionID == sessionID && item.AlbumID == albumID && item.Status == ModerationQuarantined {
+			hidden[item.Filename] = true
+		}
+	}
+	return hidden
+}
+
+// QuarantinedPaths возвращает пути скрытых файлов, которые очистка должна пропускать
+func (s *moderationStore) QuarantinedPaths() (map[string]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]bool)
+	for _, item := range s.items {
+		if item.Status != ModerationQuarantined {
+			continue
+		}
+		if path, err := resolveStoragePath(item.SessionID, item.AlbumID, item.Filename); err == nil {
+			paths[path] = true
+		}
+	}
+	return paths, nil
+}
+
+// MoveAlbum переносит жалобы вслед за альбомом при объединении сессий
+func (s *moderationStore) MoveAlbum(fromSession, fromAlbum, intoSession, intoAlbum string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	moved := false
+	for _, item := range s.items {
+		if item.SessionID == fromSession && item.AlbumID == fromAlbum {
+			item.SessionID, item.AlbumID = intoSession, intoAlbum
+			moved = true
+		}
+	}
+	if !moved {
+		return nil
+	}
+	return s.save()
+}
+
+// reportRequest - тело жалобы на изображение
+type reportRequest struct {
+	Reason  string `json:"reason"`
+	Details string `json:"details"`
+}
+
+// apiReportImage принимает жалобу на изображение. Пожаловаться может любой, кто видит
+// изображение; отвечает одинаково, записана жалоба или нет, и ничего не сообщает об очереди.
+func apiReportImage(w http.ResponseWriter, r *http.Request) {
+	sessionID, albumID, ok := apiVisibleAlbum(w, r)
+	if !ok {
+		return
+	}
+	img, ok := apiImage(w, r, sessionID, albumID)
+	if !ok {
+		return
+	}
+
+	var req reportRequest
+	if !apiDecodeJSON(w, r, &req) {
+		return
+	}
+	req.Details = strings.TrimSpace(req.Details)
+	if !slices.Contains(ReportReasons, req.Reason) {
+		ErrorResponse(w, http.StatusBadRequest, ErrCodeBadRequest,
+			"reason must be one of: "+strings.Join(ReportReasons, ", "))
+		return
+	}
+	if utf8.RuneCountInString(req.Details) > MaxReportDetailsLen {
+		ErrorResponse(w, http.StatusBadRequest, ErrCodeBadRequest,
+			fmt.Sprintf("details must be at most %d characters", MaxReportDetailsLen))
+		return
+	}
+
+	reporter, _ := resolveSession(r)
+	report := Report{
+		Reason:    req.Reason,
+		Details:   req.Details,
+		Session:   reporter,
+		Addr:      clientIP(r),
+		CreatedAt: time.Now(),
+	}
+	if err := moderation.Add(sessionID, albumID, img.Filename, report); err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	logger.Info(fmt.Sprintf("Image %s/%s/%s reported (%s) from %s", sessionID, albumID, img.Filename, req.Reason, r.RemoteAddr))
+	apiData(w, http.StatusAccepted, map[string]string{"message": "report received"})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// storeTestImage сохраняет изображение в альбом в обход HTTP
+func storeTestImage(t *testing.T, sessionID, albumID string, seed int) *ImageInfo {
+	t.Helper()
+	content := testPNG(t, seed)
+	img, err := storeImage(bytes.NewReader(content), int64(len(content)), "test.png", sessionID, albumID)
+	if err != nil {
+		t.Fatalf("storeImage: %v", err)
+	}
+	return img
+}
+
+// quarantineTestImage жалуется на изображение и скрывает его; возвращает ID элемента очереди
+func quarantineTestImage(t *testing.T, img *ImageInfo) string {
+	t.Helper()
+	if err := moderation.Add(img.UserID, img.AlbumID, img.Filename, Report{Reason: "illegal", Addr: "192.0.2.1", CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	items, err := moderation.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range items {
+		if item.is(img.UserID, img.AlbumID, img.Filename) {
+			if err := moderation.Quarantine(item.ID); err != nil {
+				t.Fatal(err)
+			}
+			return item.ID
+		}
+	}
+	t.Fatalf("report for %s is not in the queue", img.Filename)
+	return ""
+}
+
+// ageFile сдвигает время изменения файла за CleanupDuration
+func ageFile(t *testing.T, path string) {
+	t.Helper()
+	old := time.Now().Add(-CleanupDuration - time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQuarantinedImageCannotBeDeleted(t *testing.T) {
+	b := newTestBrowser(t)
+	if status, body := b.do("POST", "/api/v1/sessions", nil); status != http.StatusCreated {
+		t.Fatalf("create session: %d %s", status, body)
+	}
+	sessionID := b.sessionID()
+	albumID, err := createAlbum(sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := storeTestImage(t, sessionID, albumID, 8)
+	reportID := quarantineTestImage(t, img)
+
+	imagePath := "/api/v1/sessions/" + sessionID + "/albums/" + albumID + "/images/" + img.Filename
+	for _, req := range []struct{ method, path string }{
+		{"POST", "/delete-image?album_id=" + albumID + "&filename=" + img.Filename},
+		{"POST", "/delete-album?album_id=" + albumID},
+		{"POST", "/delete-user"},
+		{"POST", DeletionPathPrefix + sessionID + "/" + albumID + "/" + img.Filename + "/" + img.DeleteKey},
+		{"DELETE", imagePath},
+		{"DELETE", "/api/v1/sessions/" + sessionID + "/albums/" + albumID},
+		{"DELETE", "/api/v1/sessions/" + sessionID},
+	} {
+		status, body := b.do(req.method, req.path, nil)
+		if status != http.StatusConflict {
+			t.Errorf("%s %s: %d %s, want 409", req.method, req.path, status, body)
+			continue
+		}
+		if req.method == "DELETE" {
+			var resp struct {
+				Error struct{ Code string } `json:"error"`
+			}
+			if err := json.Unmarshal(body, &resp); err != nil || resp.Error.Code != ErrCodeUnderReview {
+				t.Errorf("%s %s: error %s, want %s", req.method, req.path, body, ErrCodeUnderReview)
+			}
+		}
+	}
+	if _, err := os.Stat(img.Path); err != nil {
+		t.Fatalf("quarantined image is gone: %v", err)
+	}
+
+	// После решения по жалобе владелец снова может удалить изображение
+	if err := moderation.Resolve(reportID); err != nil {
+		t.Fatal(err)
+	}
+	if status, body := b.do("DELETE", imagePath, nil); status != http.StatusNoContent {
+		t.Errorf("delete after resolve: %d %s, want 204", status, body)
+	}
+}
+
+func TestCleanupKeepsAlbumMetaWithQuarantinedImage(t *testing.T) {
+	sessionID := newTestClient(t).SessionID()
+	albumID, err := createAlbum(sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := storeTestImage(t, sessionID, albumID, 9)
+	hidden := storeTestImage(t, sessionID, albumID, 10)
+	if err := updateAlbumMeta(sessionID, albumID, func(meta *AlbumMeta) { meta.Private = true }); err != nil {
+		t.Fatal(err)
+	}
+	reportID := quarantineTestImage(t, hidden)
+	for _, path := range []string{expired.Path, hidden.Path, albumMetaPath(sessionID, albumID)} {
+		ageFile(t, path)
+	}
+
+	if err := cleanupOldImages(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(expired.Path); !os.IsNotExist(err) {
+		t.Errorf("expired image survived cleanup: %v", err)
+	}
+	if _, err := os.Stat(hidden.Path); err != nil {
+		t.Fatalf("quarantined image removed by cleanup: %v", err)
+	}
+	meta, err := loadAlbumMeta(sessionID, albumID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !meta.Private {
+		t.Error("album lost its private flag while an image is under review")
+	}
+	if _, ok := meta.Images[expired.Filename]; ok {
+		t.Error("metadata still lists the removed image")
+	}
+	if meta.Images[hidden.Filename].DeleteHash == "" {
+		t.Error("metadata lost the quarantined image")
+	}
+
+	// Вместе с последним изображением уходят и метаданные, и сам альбом
+	if err := moderation.Resolve(reportID); err != nil {
+		t.Fatal(err)
+	}
+	if err := cleanupOldImages(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(albumPath(sessionID, albumID)); !os.IsNotExist(err) {
+		t.Errorf("empty album survived cleanup: %v", err)
+	}
+}
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ошибки входа через OIDC
+var (
+	ErrOIDCState      = errors.New("unknown or expired login state")
+	ErrOIDCToken      = errors.New("invalid ID token")
+	ErrOIDCGroups     = errors.New("account is not in an allowed group")
+	ErrOIDCUnknownKey = errors.New("unknown signing key")
+)
+
+// oidcHTTPClient выполняет запросы к провайдеру
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// oidcPending - начатый вход, ожидающий возврата от провайдера; хранится только в памяти
+type oidcPending struct {
+	Verifier    string // PKCE code_verifier
+	Nonce       string
+	RedirectURI string
+	Merge       bool
+	ExpiresAt   time.Time
+}
+
+// oidcProvider - адреса провайдера из discovery документа и его ключи подписи
+type oidcProvider struct {
+	mu            sync.Mutex
+	issuer        string
+	authEndpoint  string
+	tokenEndpoint string
+	jwksURI       string
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+	pending       map[string]oidcPending // ключ - state
+}
+
+var oidc = &oidcProvider{pending: map[string]oidcPending{}}
+
+// oidcEnabled сообщает, настроен ли вход через провайдера
+func oidcEnabled() bool {
+	return OIDCIssuer != ""
+}
+
+// discover читает discovery документ провайдера (вызывается под мьютексом)
+func (p *oidcProvider) discover() error {
+	if p.issuer != "" {
+		return nil
+	}
+
+	var doc struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	configURL := strings.TrimSuffix(OIDCIssuer, "/") + "/.well-known/openid-configuration"
+	if err := fetchJSON(configURL, &doc); err != nil {
+		return fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != strings.TrimSuffix(OIDCIssuer, "/") {
+		return fmt.Errorf("oidc discovery: issuer %q does not match %q", doc.Issuer, OIDCIssuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return errors.New("oidc discovery: endpoints missing")
+	}
+
+	p.issuer = doc.Issuer
+	p.authEndpoint = doc.AuthorizationEndpoint
+	p.tokenEndpoint = doc.TokenEndpoint
+	p.jwksURI = doc.JWKSURI
+	return nil
+}
+
+// Start начинает вход: запоминает PKCE verifier и nonce и возвращает адрес страницы провайдера
+func (p *oidcProvider) Start(redirectURI string, merge bool) (authURL, state string, err error) {
+	state, err = RandomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := RandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := RandomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.discover(); err != nil {
+		return "", "", err
+	}
+
+	// Заодно выбрасываем брошенные попытки входа
+	now := time.Now()
+	for key, pending := range p.pending {
+		if now.After(pending.ExpiresAt) {
+			delete(p.pending, key)
+		}
+	}
+	p.pending[state] = oidcPending{
+		Verifier:    verifier,
+		Nonce:       nonce,
+		RedirectURI: redirectURI,
+		Merge:       merge,
+		ExpiresAt:   now.Add(OIDCLoginTTL),
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {OIDCClientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {OIDCScopes},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(p.authEndpoint, "?") {
+		separator = "&"
+	}
+	return p.authEndpoint + separator + query.Encode(), state, nil
+}
+
+// Finish обменивает код на ID токен, проверяет его и возвращает утверждения (claims)
+func (p *oidcProvider) Finish(state, code string) (map[string]interface{}, bool, error) {
+	p.mu.Lock()
+	pending, ok := p.pending[state]
+	delete(p.pending, state)
+	p.mu.Unlock()
+	if !ok || time.Now().After(pending.ExpiresAt) {
+		return nil, false, ErrOIDCState
+	}
+
+	rawToken, err := p.exchange(code, pending)
+	if err != nil {
+		return nil, false, err
+	}
+	claims, err := p.verifyIDToken(rawToken, pending.Nonce)
+	if err != nil {
+		return nil, false, err
+	}
+	return claims, pending.Merge, nil
+}
+
+// exchange получает ID токен по коду авторизации и PKCE verifier
+func (p *oidcProvider) exchange(code string, pending oidcPending) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {pending.RedirectURI},
+		"code_verifier": {pending.Verifier},
+		"client_id":     {OIDCClientID},
+	}
+	req, err := http.NewRequest(http.MethodPost, p.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if OIDCClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(OIDCClientID), url.QueryEscape(OIDCClientSecret))
+	}
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("oidc token request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("oidc token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return "", fmt.Errorf("oidc token request: %s %s (HTTP %d)", body.Error, body.ErrorDescription, resp.StatusCode)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("oidc token response: no id_token")
+	}
+	return body.IDToken, nil
+}
+
+// verifyIDToken проверяет подпись (RS256 или ES256), издателя, получателя, срок и nonce
+func (p *oidcProvider) verifyIDToken(raw, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrOIDCToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, ErrOIDCToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrOIDCToken
+	}
+	key, err := p.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	valid := false
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		valid = header.Alg == "RS256" && rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		if header.Alg == "ES256" && len(signature) == 64 {
+			r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+			valid = ecdsa.Verify(pub, digest[:], r, s)
+		}
+	}
+	if !valid {
+		return nil, fmt.Errorf("%w: bad %s signature", ErrOIDCToken, header.Alg)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, ErrOIDCToken
+	}
+
+	if iss, _ := claims["iss"].(string); iss != p.issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrOIDCToken, iss)
+	}
+	if !slices.Contains(claimStrings(claims["aud"]), OIDCClientID) {
+		return nil, fmt.Errorf("%w: not issued for this client", ErrOIDCToken)
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != OIDCClientID {
+		return nil, fmt.Errorf("%w: not issued for this client", ErrOIDCToken)
+	}
+	exp, _ := claims["exp"].(float64)
+	if time.Now().After(time.Unix(int64(exp), 0).Add(time.Minute)) {
+		return nil, fmt.Errorf("%w: expired", ErrOIDCToken)
+	}
+	if got, _ := claims["nonce"].(string); subtle.ConstantTimeCompare([]byte(got), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrOIDCToken)
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrOIDCToken)
+	}
+	return claims, nil
+}
+
+// key возвращает ключ подписи по kid. Незнакомый kid означает ротацию ключей у провайдера,
+// поэтому JWKS перечитывается, но не чаще раза в минуту.
+func (p *oidcProvider) key(kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	lookup := func() crypto.PublicKey {
+		if key, ok := p.keys[kid]; ok {
+			return key
+		}
+		// Провайдер с единственным ключом может не указывать kid
+		if kid == "" && len(p.keys) == 1 {
+			for _, key := range p.keys {
+				return key
+			}
+		}
+		return nil
+	}
+
+	if key := lookup(); key != nil {
+		return key, nil
+	}
+	if time.Since(p.keysFetchedAt) < time.Minute {
+		return nil, ErrOIDCUnknownKey
+	}
+	if err := p.fetchKeys(); err != nil {
+		return nil, err
+	}
+	if key := lookup(); key != nil {
+		return key, nil
+	}
+	return nil, ErrOIDCUnknownKey
+}
+
+// fetchKeys читает JWKS провайдера (вызывается под мьютексом)
+func (p *oidcProvider) fetchKeys() error {
+	if err := p.discover(); err != nil {
+		return err
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := fetchJSON(p.jwksURI, &set); err != nil {
+		return fmt.Errorf("oidc jwks: %w", err)
+	}
+	p.keysFetchedAt = time.Now()
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch {
+		case k.Kty == "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil || len(e) > 4 {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	p.keys = keys
+	return nil
+}
+
+// fetchJSON загружает JSON документ провайдера
+func fetchJSON(rawURL string, v interface{}) error {
+	resp, err := oidcHTTPClient.Get(rawURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: HTTP %d", rawURL, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// decodeJWTPart декодирует заголовок или тело JWT
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// claimStrings приводит утверждение-строку или массив строк к срезу
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var list []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// oidcAllowed проверяет группы пользователя, если вход ограничен OIDCAllowedGroups
+func oidcAllowed(claims map[string]interface{}) bool {
+	if len(OIDCAllowedGroups) == 0 {
+		return true
+	}
+	for _, group := range claimStrings(claims[OIDCGroupsClaim]) {
+		if slices.Contains(OIDCAllowedGroups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+// oidcDisplayName выбирает имя пользователя для интерфейса
+func oidcDisplayName(claims map[string]interface{}) string {
+	for _, claim := range []string{"preferred_username", "email", "name", "sub"} {
+		if name, _ := claims[claim].(string); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// registerOIDCRoutes регистрирует вход через провайдера
+func registerOIDCRoutes(mux *http.ServeMux) {
+	handleRoute(mux, "GET "+OIDCLoginPath, oidcLoginHandler)
+	handleRoute(mux, "GET "+OIDCCallbackPath, oidcCallbackHandler)
+}
+
+// oidcRedirectURI возвращает адрес возврата, зарегистрированный у провайдера
+func oidcRedirectURI(r *http.Request) string {
+	if OIDCRedirectURL != "" {
+		return OIDCRedirectURL
+	}
+	return BaseURL(r) + OIDCCallbackPath
+}
+
+// setOIDCStateCookie привязывает начатый вход к браузеру, чтобы чужой код нельзя было подсунуть
+func setOIDCStateCookie(w http.ResponseWriter, state string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     OIDCStateCookie,
+		Value:    state,
+		Path:     "/auth/oidc/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// oidcLoginHandler отправляет браузер на страницу входа провайдера.
+// С ?merge=1 альбомы текущей сессии после входа переносятся в аккаунт.
+func oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	authURL, state, err := oidc.Start(oidcRedirectURI(r), r.URL.Query().Get("merge") == "1")
+	if err != nil {
+		logger.Error("OIDC login: " + err.Error())
+		http.Error(w, "Identity provider is unavailable", http.StatusBadGateway)
+		return
+	}
+	setOIDCStateCookie(w, state, int(OIDCLoginTTL/time.Second))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// oidcCallbackHandler завершает вход: проверяет ID токен, находит или создает аккаунт
+// по субъекту (sub) и привязывает браузер к его сессии
+func oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+	query := r.URL.Query()
+	setOIDCStateCookie(w, "", -1)
+
+	if errCode := query.Get("error"); errCode != "" {
+		http.Error(w, "Login failed: "+errCode+" "+query.Get("error_description"), http.StatusUnauthorized)
+		return
+	}
+	state := query.Get("state")
+	cookie, err := r.Cookie(OIDCStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		http.Error(w, "Login failed: "+ErrOIDCState.Error(), http.StatusBadRequest)
+		return
+	}
+
+	claims, merge, err := oidc.Finish(state, query.Get("code"))
+	if err != nil {
+		logger.Error("OIDC callback: " + err.Error())
+		http.Error(w, "Login failed: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	issuer, _ := claims["iss"].(string)
+	subject, _ := claims["sub"].(string)
+	if !oidcAllowed(claims) {
+		logger.Info(fmt.Sprintf("OIDC login denied for %s: no allowed group", subject))
+		http.Error(w, "Login failed: "+ErrOIDCGroups.Error(), http.StatusForbidden)
+		return
+	}
+
+	account, err := accounts.FindBySubject(issuer, subject)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error loading account: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if account == nil {
+		// Первый вход: как и при регистрации, текущая сессия становится сессией аккаунта
+		caller, err := newAccountSession(r)
+		if err == nil {
+			account, err = accounts.Create(Account{
+				Username:  oidcDisplayName(claims),
+				Issuer:    issuer,
+				Subject:   subject,
+				SessionID: caller.SessionID,
+			})
+		}
+		if err == nil {
+			err = activateAccount(w, r, caller, account)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error creating account: %v", err), http.StatusInternalServerError)
+			return
+		}
+		logger.Info(fmt.Sprintf("Account %s provisioned from OIDC subject %s", account.Username, subject))
+	} else if _, err := signIn(w, r, account, merge); err != nil {
+		if errors.Is(err, ErrSessionHasAccount) {
+			http.Error(w, "Current session belongs to another account; log out first", http.StatusConflict)
+			return
+		}
+		if errors.Is(err, ErrOwnerNotProven) {
+			http.Error(w, "Login failed: "+err.Error(), http.StatusForbidden)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Error signing in: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
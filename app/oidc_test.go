@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockIssuer - OIDC провайдер на httptest: discovery, authorize с PKCE, token и JWKS
+type mockIssuer struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu        sync.Mutex
+	subject   string
+	groups    []string
+	badNonce  bool   // подписать ID токен с чужим nonce
+	challenge string // code_challenge из последнего authorize
+	method    string // code_challenge_method из последнего authorize
+	nonce     string
+	pkceOK    bool // token получил verifier, совпавший с challenge
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{t: t, key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("GET /authorize", m.authorize)
+	mux.HandleFunc("POST /token", m.token)
+	mux.HandleFunc("GET /jwks", m.jwks)
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockIssuer) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 m.server.URL,
+		"authorization_endpoint": m.server.URL + "/authorize",
+		"token_endpoint":         m.server.URL + "/token",
+		"jwks_uri":               m.server.URL + "/jwks",
+	})
+}
+
+// authorize сразу «входит» и возвращает браузер на redirect_uri с кодом
+func (m *mockIssuer) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	m.mu.Lock()
+	m.challenge = query.Get("code_challenge")
+	m.method = query.Get("code_challenge_method")
+	m.nonce = query.Get("nonce")
+	m.mu.Unlock()
+
+	target := query.Get("redirect_uri") + "?" + url.Values{"code": {"test-code"}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// token проверяет PKCE verifier и выдает подписанный ID токен
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	m.pkceOK = m.method == "S256" && base64.RawURLEncoding.EncodeToString(sum[:]) == m.challenge
+	if !m.pkceOK || r.PostFormValue("code") != "test-code" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	nonce := m.nonce
+	if m.badNonce {
+		nonce = "someone-else"
+	}
+	claims := map[string]any{
+		"iss":    m.server.URL,
+		"aud":    OIDCClientID,
+		"sub":    m.subject,
+		"exp":    time.Now().Add(time.Minute).Unix(),
+		"iat":    time.Now().Unix(),
+		"nonce":  nonce,
+		"groups": m.groups,
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": m.sign(claims), "token_type": "Bearer"})
+}
+
+func (m *mockIssuer) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := m.key.PublicKey
+	json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "test",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}})
+}
+
+// sign подписывает JWT ключом провайдера (RS256)
+func (m *mockIssuer) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// useMockIssuer включает вход через m на время теста
+func useMockIssuer(t *testing.T, m *mockIssuer, allowedGroups ...string) {
+	oldIssuer, oldClient, oldGroups, oldProvider := OIDCIssuer, OIDCClientID, OIDCAllowedGroups, oidc
+	OIDCIssuer, OIDCClientID, OIDCAllowedGroups = m.server.URL, "ripx-test", allowedGroups
+	oidc = &oidcProvider{pending: map[string]oidcPending{}}
+	t.Cleanup(func() {
+		OIDCIssuer, OIDCClientID, OIDCAllowedGroups, oidc = oldIssuer, oldClient, oldGroups, oldProvider
+	})
+}
+
+func TestOIDCLogin(t *testing.T) {
+	m := newMockIssuer(t)
+	useMockIssuer(t, m, "design")
+
+	tests := []struct {
+		name     string
+		subject  string
+		groups   []string
+		badNonce bool
+		status   int
+		body     string
+	}{
+		{name: "allowed group", subject: "alice", groups: []string{"staff", "design"}, status: http.StatusOK},
+		{name: "no allowed group", subject: "bob", groups: []string{"staff"}, status: http.StatusForbidden, body: ErrOIDCGroups.Error()},
+		{name: "nonce mismatch", subject: "carol", groups: []string{"design"}, badNonce: true, status: http.StatusUnauthorized, body: "nonce mismatch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m.mu.Lock()
+			m.subject, m.groups, m.badNonce, m.pkceOK = tt.subject, tt.groups, tt.badNonce, false
+			m.mu.Unlock()
+
+			// Клиент идет по всем перенаправлениям: ripx -> authorize -> callback -> /
+			b := newTestBrowser(t)
+			status, body := b.do("GET", OIDCLoginPath, nil)
+			if status != tt.status || !strings.Contains(string(body), tt.body) {
+				t.Fatalf("login: %d %.200s, want %d %q", status, body, tt.status, tt.body)
+			}
+
+			m.mu.Lock()
+			pkceOK, method := m.pkceOK, m.method
+			m.mu.Unlock()
+			if method != "S256" || !pkceOK {
+				t.Errorf("PKCE: method %q, verifier matched %v", method, pkceOK)
+			}
+
+			account, err := accounts.FindBySubject(m.server.URL, tt.subject)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if created := account != nil; created != (tt.status == http.StatusOK) {
+				t.Errorf("account created: %v", created)
+			}
+			if account != nil && b.sessionID() != account.SessionID {
+				t.Errorf("browser session %q, want account session %q", b.sessionID(), account.SessionID)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackRejectsForeignState(t *testing.T) {
+	m := newMockIssuer(t)
+	useMockIssuer(t, m)
+
+	// Код и state без cookie начатого входа в этом браузере
+	b := newTestBrowser(t)
+	status, _ := b.do("GET", OIDCCallbackPath+"?code=test-code&state=forged", nil)
+	if status != http.StatusBadRequest {
+		t.Errorf("callback without state cookie: %d, want 400", status)
+	}
+}
+
+func TestCheckAuthConfigGroupsNeedSignupOff(t *testing.T) {
+	oldIssuer, oldClient, oldGroups, oldAccounts := OIDCIssuer, OIDCClientID, OIDCAllowedGroups, AccountsEnabled
+	t.Cleanup(func() {
+		OIDCIssuer, OIDCClientID, OIDCAllowedGroups, AccountsEnabled = oldIssuer, oldClient, oldGroups, oldAccounts
+	})
+	OIDCIssuer, OIDCClientID, OIDCAllowedGroups = "https://id.example.com", "ripx", []string{"design"}
+
+	AccountsEnabled = true
+	if err := checkAuthConfig(); err == nil || !strings.Contains(err.Error(), "RIPX_ACCOUNTS=false") {
+		t.Errorf("groups with password signup: %v, want an error naming RIPX_ACCOUNTS=false", err)
+	}
+	AccountsEnabled = false
+	if err := checkAuthConfig(); err != nil {
+		t.Errorf("groups without password signup: %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// openAPISpec - спецификация OpenAPI 3, вшитая в бинарник
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// registeredRoutes - паттерны, зарегистрированные через handleRoute
+var registeredRoutes []string
+
+// handleRoute регистрирует роут и запоминает его паттерн для сверки со спецификацией
+func handleRoute(mux *http.ServeMux, pattern string, handler http.HandlerFunc) {
+	registeredRoutes = append(registeredRoutes, pattern)
+	mux.HandleFunc(pattern, handler)
+}
+
+// openAPIHandler отдает спецификацию API
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// openAPIDocument - часть спецификации, нужная для сверки с роутами.
+// Каждый path item перечисляет в x-route паттерны ServeMux, которые его обслуживают.
+type openAPIDocument struct {
+	Paths map[string]map[string]json.RawMessage `json:"paths"`
+}
+
+// checkOpenAPISpec сверяет зарегистрированные роуты со спецификацией:
+// у каждого роута должно быть описание, а у каждого описания - роут
+func checkOpenAPISpec(routes []string) error {
+	var doc openAPIDocument
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		return fmt.Errorf("invalid openapi.json: %w", err)
+	}
+
+	registered := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		registered[route] = true
+	}
+
+	documented := make(map[string]bool)
+	var problems []string
+	for path, item := range doc.Paths {
+		var xRoutes []string
+		if raw, ok := item["x-route"]; ok {
+			if err := json.Unmarshal(raw, &xRoutes); err != nil {
+				return fmt.Errorf("invalid x-route for %s: %w", path, err)
+			}
+		}
+		if len(xRoutes) == 0 {
+			problems = append(problems, fmt.Sprintf("%s: no x-route", path))
+		}
+
+		for _, route := range xRoutes {
+			documented[route] = true
+			if !registered[route] {
+				problems = append(problems, fmt.Sprintf("%s: route %q is not registered", path, route))
+				continue
+			}
+
+			// Для паттернов с методом проверяем, что операция описана по тому же пути
+			method, routePath, hasMethod := strings.Cut(route, " ")
+			if !hasMethod {
+				continue
+			}
+			if routePath != path {
+				problems = append(problems, fmt.Sprintf("%s: route %q serves another path", path, route))
+			}
+			if _, ok := item[strings.ToLower(method)]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: %s operation is not described", path, method))
+			}
+		}
+	}
+
+	for _, route := range routes {
+		if !documented[route] {
+			problems = append(problems, fmt.Sprintf("route %q is not documented", route))
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("openapi.json is out of sync with setupRoutes:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
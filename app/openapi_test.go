@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPISpecMatchesRoutes(t *testing.T) {
+	if err := checkOpenAPISpec(registeredRoutes); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// apiStep - запрос к API и операция спецификации, которой должен соответствовать ответ
+type apiStep struct {
+	method   string
+	specPath string // путь из openapi.json
+	url      string // путь запроса
+	token    string
+	body     any // JSON тело или *multipartBody
+	status   int
+}
+
+func TestOpenAPIResponses(t *testing.T) {
+	spec := loadTestSpec(t)
+
+	var session struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	data := callAPI(t, spec, apiStep{method: "POST", specPath: "/api/v1/sessions", url: "/api/v1/sessions", status: 201})
+	unmarshalData(t, data, &session)
+	if session.ID == "" || session.Token == "" {
+		t.Fatalf("session without id or token: %s", data)
+	}
+	s, tok := session.ID, session.Token
+	sessionURL := "/api/v1/sessions/" + s
+
+	callAPI(t, spec, apiStep{method: "GET", specPath: "/api/v1/health", url: "/api/v1/health", status: 200})
+	callAPI(t, spec, apiStep{method: "GET", specPath: "/api/v1/openapi.json", url: "/api/v1/openapi.json", status: 200})
+	callAPI(t, spec, apiStep{method: "GET", specPath: "/api/{path}", url: "/api/v1/nothing", status: 404})
+	callAPI(t, spec, apiStep{method: "GET", specPath: "/api/v1/session", url: "/api/v1/session", token: tok, status: 200})
+	callAPI(t, spec, apiStep{method: "GET", specPath: "/api/v1/session", url: "/api/v1/session", status: 401})
+	callAPI(t, spec, apiStep{method: "GET", specPath: "/api/v1/sessions/{session}", url: sessionURL, token: tok, status: 200})
+
+	// Альбомы
+	var album struct {
+		ID string `json:"id"`
+	}
+	albumsPath := "/api/v1/sessions/{session}/albums"
+	data = callAPI(t, spec, apiStep{method: "POST", specPath: albumsPath, url: sessionURL + "/albums", token: tok,
+		body: map[string]any{"name": "holiday"}, status: 201})
+	unmarshalData(t, data, &album)
+	callAPI(t, spec, apiStep{method: "POST", specPath: albumsPath, url: sessionURL + "/albums", status: 401})
+	callAPI(t, spec, apiStep{method: "POST", specPath: albumsPath, url: sessionURL + "/albums", token: tok,
+		body: map[string]any{"title": "x"}, status: 400})
+	callAPI(t, spec, apiStep{method: "GET", specPath: albumsPath, url: sessionURL + "/albums", token: tok, status: 200})
+
+	albumPath := albumsPath + "/{album}"
+	albumURL := sessionURL + "/albums/" + album.ID
+	callAPI(t, spec, apiStep{method: "GET", specPath: albumPath, url: albumURL, status: 200})
+	callAPI(t, spec, apiStep{method: "GET", specPath: albumPath, url: sessionURL + "/albums/zzzzz", status: 404})
+	callAPI(t, spec, apiStep{method: "PATCH", specPath: albumPath, url: albumURL, token: tok,
+		body: map[string]any{"name": "renamed", "private": false}, status: 200})
+
+	// Изображения
+	imagesPath := albumPath + "/images"
+	var images []struct {
+		Filename    string `json:"filename"`
+		DeletionURL string `json:"deletion_url"`
+	}
+	data = callAPI(t, spec, apiStep{method: "POST", specPath: imagesPath, url: albumURL + "/images", token: tok,
+		body: multipartImages(t, testPNG(t, 1), testPNG(t, 2)), status: 201})
+	unmarshalData(t, data, &images)
+	if len(images) != 2 || images[0].DeletionURL == "" {
+		t.Fatalf("unexpected upload result: %s", data)
+	}
+	callAPI(t, spec, apiStep{method: "POST", specPath: imagesPath, url: albumURL + "/images", token: tok,
+		body: multipartImages(t, testPNG(t, 3), []byte("not an image")), status: 415})
+	callAPI(t, spec, apiStep{method: "GET", specPath: imagesPath, url: albumURL + "/images?per_page=1", status: 200})
+	callAPI(t, spec, apiStep{method: "GET", specPath: imagesPath, url: albumURL + "/images?page=0", status: 400})
+
+	imagePath := imagesPath + "/{filename}"
+	imageURL := albumURL + "/images/" + images[0].Filename
+	callAPI(t, spec, apiStep{method: "GET", specPath: imagePath, url: imageURL, status: 200})
+	callAPI(t, spec, apiStep{method: "PATCH", specPath: imagePath, url: imageURL, token: tok,
+		body: map[string]any{"original_name": "beach.png"}, status: 200})
+	callAPI(t, spec, apiStep{method: "POST", specPath: imagePath + "/reports", url: albumURL + "/images/" + images[1].Filename + "/reports",
+		body: map[string]any{"reason": "spam"}, status: 202})
+	callAPI(t, spec, apiStep{method: "DELETE", specPath: imagePath, url: imageURL, token: tok, status: 204})
+	callAPI(t, spec, apiStep{method: "GET", specPath: imagePath, url: imageURL, status: 404})
+
+	// Токены
+	var token struct {
+		ID     string `json:"id"`
+		Secret string `json:"token"`
+	}
+	data = callAPI(t, spec, apiStep{method: "POST", specPath: "/api/v1/tokens", url: "/api/v1/tokens", token: tok,
+		body: map[string]any{"name": "ci", "scopes": []string{"upload"}}, status: 201})
+	unmarshalData(t, data, &token)
+	callAPI(t, spec, apiStep{method: "GET", specPath: "/api/v1/tokens", url: "/api/v1/tokens", token: tok, status: 200})
+	callAPI(t, spec, apiStep{method: "GET", specPath: "/api/v1/tokens", url: "/api/v1/tokens", token: token.Secret, status: 403})
+	callAPI(t, spec, apiStep{method: "DELETE", specPath: albumPath, url: albumURL, token: token.Secret, status: 403})
+	callAPI(t, spec, apiStep{method: "DELETE", specPath: "/api/v1/tokens/{id}", url: "/api/v1/tokens/" + token.ID, token: tok, status: 204})
+	callAPI(t, spec, apiStep{method: "DELETE", specPath: "/api/v1/tokens/{id}", url: "/api/v1/tokens/" + token.ID, token: tok, status: 404})
+
+	// Устройства и коды
+	callAPI(t, spec, apiStep{method: "GET", specPath: "/api/v1/devices", url: "/api/v1/devices", token: tok, status: 200})
+	callAPI(t, spec, apiStep{method: "POST", specPath: "/api/v1/pairing-codes", url: "/api/v1/pairing-codes", token: tok, status: 201})
+	callAPI(t, spec, apiStep{method: "POST", specPath: "/api/v1/recovery-code", url: "/api/v1/recovery-code", token: tok, status: 201})
+
+	// Удаление
+	callAPI(t, spec, apiStep{method: "DELETE", specPath: albumPath, url: albumURL, token: tok, status: 204})
+	callAPI(t, spec, apiStep{method: "DELETE", specPath: albumPath, url: albumURL, token: tok, status: 404})
+	callAPI(t, spec, apiStep{method: "DELETE", specPath: "/api/v1/sessions/{session}", url: sessionURL, token: tok, status: 204})
+}
+
+// loadTestSpec разбирает вшитую спецификацию
+func loadTestSpec(t *testing.T) map[string]any {
+	t.Helper()
+	var spec map[string]any
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("invalid openapi.json: %v", err)
+	}
+	return spec
+}
+
+// callAPI выполняет запрос, проверяет код ответа и сверяет тело со схемой
+// из спецификации. Возвращает поле data ответа (или все тело, если его нет).
+func callAPI(t *testing.T, spec map[string]any, step apiStep) json.RawMessage {
+	t.Helper()
+
+	var body io.Reader
+	contentType := ""
+	switch b := step.body.(type) {
+	case nil:
+	case *multipartBody:
+		body, contentType = &b.buf, b.contentType
+	default:
+		encoded, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, contentType = bytes.NewReader(encoded), "application/json"
+	}
+
+	req, err := http.NewRequest(step.method, testServer.URL+step.url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if step.token != "" {
+		req.Header.Set("Authorization", "Bearer "+step.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := step.method + " " + step.url
+	if resp.StatusCode != step.status {
+		t.Fatalf("%s: status %d, want %d: %s", name, resp.StatusCode, step.status, raw)
+	}
+
+	operation, ok := lookup(spec, "paths", step.specPath, strings.ToLower(step.method)).(map[string]any)
+	if !ok {
+		t.Fatalf("%s: operation %s %s is not in openapi.json", name, step.method, step.specPath)
+	}
+	response, ok := lookup(operation, "responses", strconv.Itoa(resp.StatusCode)).(map[string]any)
+	if !ok {
+		t.Fatalf("%s: status %d is not documented for %s %s", name, resp.StatusCode, step.method, step.specPath)
+	}
+
+	schema, ok := lookup(response, "content", "application/json", "schema").(map[string]any)
+	if !ok {
+		if content, _ := response["content"].(map[string]any); len(content) == 0 && len(raw) > 0 {
+			t.Errorf("%s: response has a body, but the spec describes none", name)
+		}
+		return raw
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("%s: Content-Type %q, want application/json", name, ct)
+	}
+
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		t.Fatalf("%s: invalid JSON: %v: %s", name, err, raw)
+	}
+	if problems := validateSchema(spec, schema, value, "$"); len(problems) > 0 {
+		t.Errorf("%s: response does not match openapi.json:\n  %s\n%s", name, strings.Join(problems, "\n  "), raw)
+	}
+
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if json.Unmarshal(raw, &envelope) == nil && envelope.Data != nil {
+		return envelope.Data
+	}
+	return raw
+}
+
+// unmarshalData разбирает поле data ответа
+func unmarshalData(t *testing.T, data json.RawMessage, v any) {
+	t.Helper()
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("decoding %s: %v", data, err)
+	}
+}
+
+// multipartBody - готовое multipart тело с полями image
+type multipartBody struct {
+	buf         bytes.Buffer
+	contentType string
+}
+
+// multipartImages собирает multipart форму из файлов в поле image
+func multipartImages(t *testing.T, files ...[]byte) *multipartBody {
+	t.Helper()
+	body := &multipartBody{}
+	mw := multipart.NewWriter(&body.buf)
+	for i, content := range files {
+		part, err := mw.CreateFormFile("image", fmt.Sprintf("file%d.png", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(content)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	body.contentType = mw.FormDataContentType()
+	return body
+}
+
+// lookup спускается по вложенным объектам JSON
+func lookup(value any, keys ...string) any {
+	for _, key := range keys {
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = obj[key]
+	}
+	return value
+}
+
+// validateSchema сверяет значение со схемой OpenAPI. Поддерживается то подмножество,
+// которое встречается в openapi.json: $ref, type, required, properties,
+// additionalProperties, items и enum. Поля, не описанные в properties, считаются ошибкой.
+func validateSchema(spec, schema map[string]any, value any, at string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		resolved, ok := lookup(spec, strings.Split(strings.TrimPrefix(ref, "#/"), "/")...).(map[string]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: unresolved $ref %s", at, ref)}
+		}
+		return validateSchema(spec, resolved, value, at)
+	}
+
+	if value == nil {
+		return []string{fmt.Sprintf("%s: null is not allowed", at)}
+	}
+
+	var problems []string
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, allowed := range enum {
+			if allowed == value {
+				found = true
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s: %v is not one of %v", at, value, enum))
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return append(problems, fmt.Sprintf("%s: want object, got %T", at, value))
+		}
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: required field %q is missing", at, name))
+			}
+		}
+
+		properties, _ := schema["properties"].(map[string]any)
+		extra, _ := schema["additionalProperties"].(map[string]any)
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			field := at + "." + name
+			if property, ok := properties[name].(map[string]any); ok {
+				problems = append(problems, validateSchema(spec, property, obj[name], field)...)
+			} else if extra != nil {
+				problems = append(problems, validateSchema(spec, extra, obj[name], field)...)
+			} else if properties != nil {
+				problems = append(problems, fmt.Sprintf("%s: field is not documented", field))
+			}
+		}
+	case "array":
+		list, ok := value.([]any)
+		if !ok {
+			return append(problems, fmt.Sprintf("%s: want array, got %T", at, value))
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range list {
+				problems = append(problems, validateSchema(spec, items, item, fmt.Sprintf("%s[%d]", at, i))...)
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			problems = append(problems, fmt.Sprintf("%s: want string, got %T", at, value))
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != math.Trunc(n) {
+			problems = append(problems, fmt.Sprintf("%s: want integer, got %v", at, value))
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			problems = append(problems, fmt.Sprintf("%s: want number, got %T", at, value))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			problems = append(problems, fmt.Sprintf("%s: want boolean, got %T", at, value))
+		}
+	}
+	return problems
+}
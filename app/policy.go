@@ -0,0 +1,48 @@
+package main
+
+import "errors"
+
+// Ошибки политики загрузки
+var (
+	ErrLoginRequired  = errors.New("log in to upload on this server")
+	ErrInviteRequired = errors.New("redeem an invite code to upload on this server")
+)
+
+// checkUploadPolicy проверяет, может ли клиент загружать изображения и менять альбомы.
+// Просмотр альбомов эти ограничения не затрагивают.
+func checkUploadPolicy(caller *Caller) error {
+	if RequireLogin {
+		account, err := accounts.FindBySession(caller.SessionID)
+		if err != nil {
+			return err
+		}
+		if account == nil {
+			return ErrLoginRequired
+		}
+	}
+	if InviteOnly && !sessions.Invited(caller.SessionID) {
+		return ErrInviteRequired
+	}
+	return nil
+}
+
+// checkAuthConfig проверяет согласованность настроек входа при запуске
+func checkAuthConfig() error {
+	if OIDCIssuer != "" && OIDCClientID == "" {
+		return errors.New("RIPX_OIDC_ISSUER is set but RIPX_OIDC_CLIENT_ID is empty")
+	}
+	// Регистрация по паролю обошла бы ограничение по группам провайдера
+	if oidcEnabled() && len(OIDCAllowedGroups) > 0 && AccountsEnabled {
+		return errors.New("RIPX_OIDC_ALLOWED_GROUPS is set but password signup is enabled and bypasses it: set RIPX_ACCOUNTS=false")
+	}
+	if (AdminUser == "") != (AdminPassword == "") {
+		return errors.New("RIPX_ADMIN_USER and RIPX_ADMIN_PASSWORD must be set together")
+	}
+	if InviteOnly && !adminEnabled() {
+		return errors.New("RIPX_INVITE_ONLY=true needs RIPX_ADMIN_USER and RIPX_ADMIN_PASSWORD: nobody could issue invites")
+	}
+	if RequireLogin && !accountsAvailable() {
+		return errors.New("RIPX_REQUIRE_LOGIN=true needs accounts, OIDC or proxy auth: nobody would be able to upload")
+	}
+	return loadTrustedProxies()
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// trustedProxyPrefixes - разобранный RIPX_TRUSTED_PROXIES
+var trustedProxyPrefixes []netip.Prefix
+
+// proxyAuthEnabled сообщает, определяется ли владелец заголовком прокси
+func proxyAuthEnabled() bool {
+	return ProxyAuthHeader != ""
+}
+
+// loadTrustedProxies разбирает список доверенных прокси; отдельный IP считается подсетью из одного адреса
+func loadTrustedProxies() error {
+	trustedProxyPrefixes = nil
+	for _, item := range TrustedProxies {
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return fmt.Errorf("RIPX_TRUSTED_PROXIES: %w", err)
+			}
+			trustedProxyPrefixes = append(trustedProxyPrefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return fmt.Errorf("RIPX_TRUSTED_PROXIES: %w", err)
+		}
+		trustedProxyPrefixes = append(trustedProxyPrefixes, prefix.Masked())
+	}
+
+	if proxyAuthEnabled() && len(trustedProxyPrefixes) == 0 {
+		return errors.New("RIPX_PROXY_AUTH_HEADER is set but RIPX_TRUSTED_PROXIES is empty")
+	}
+	if ProxyAuthFallback != ProxyFallbackAnonymous && ProxyAuthFallback != ProxyFallbackDeny {
+		return fmt.Errorf("RIPX_PROXY_AUTH_FALLBACK must be %q or %q", ProxyFallbackAnonymous, ProxyFallbackDeny)
+	}
+	return nil
+}
+
+// remoteHost возвращает адрес соединения без порта
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientIP возвращает адрес клиента. За доверенным прокси это самый правый адрес
+// X-Forwarded-For, не принадлежащий доверенным прокси: левее клиент мог дописать что угодно.
+func clientIP(r *http.Request) string {
+	if !isTrustedProxy(r) {
+		return remoteHost(r)
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		if !isTrustedAddr(addr) {
+			return addr.Unmap().String()
+		}
+	}
+	return remoteHost(r)
+}
+
+// isTrustedProxy проверяет, что запрос пришел с адреса доверенного прокси
+func isTrustedProxy(r *http.Request) bool {
+	addr, err := netip.ParseAddr(remoteHost(r))
+	if err != nil {
+		return false
+	}
+	return isTrustedAddr(addr)
+}
+
+// isTrustedAddr проверяет, что адрес входит в RIPX_TRUSTED_PROXIES
+func isTrustedAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxyPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyUser возвращает пользователя из заголовка прокси. Заголовок из недоверенного
+// источника игнорируется: иначе любой клиент мог бы представиться кем угодно.
+func proxyUser(r *http.Request) string {
+	if !proxyAuthEnabled() {
+		return ""
+	}
+	user := strings.TrimSpace(r.Header.Get(ProxyAuthHeader))
+	if user == "" || len(user) > MaxProxyUserLen {
+		return ""
+	}
+	if !isTrustedProxy(r) {
+		logger.Debug(fmt.Sprintf("proxyUser: ignoring %s from untrusted %s", ProxyAuthHeader, r.RemoteAddr))
+		return ""
+	}
+	return user
+}
+
+// proxySession возвращает сессию пользователя прокси. При первом запросе для него
+// создается аккаунт и директория сессии.
+func proxySession(user string) (string, error) {
+	account, err := accounts.FindBySubject(ProxyAuthIssuer, user)
+	if err != nil || account != nil {
+		if account != nil {
+			return account.SessionID, nil
+		}
+		return "", err
+	}
+
+	sessionID, err := createSession()
+	if err != nil {
+		return "", err
+	}
+	account, err = accounts.Create(Account{
+		Username:  user,
+		Issuer:    ProxyAuthIssuer,
+		Subject:   user,
+		SessionID: sessionID,
+	})
+	if errors.Is(err, ErrAccountExists) {
+		// Параллельный запрос того же пользователя успел создать аккаунт первым
+		os.Remove(userPath(sessionID))
+		account, err = accounts.FindBySubject(ProxyAuthIssuer, user)
+		if err == nil && account == nil {
+			err = ErrAccountExists
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := sessions.SetAccount(account.SessionID, account.ID); err != nil {
+		return "", err
+	}
+
+	logger.Info(fmt.Sprintf("Account %s provisioned from proxy header with session %s", user, account.SessionID))
+	return account.SessionID, nil
+}
+
+// proxyAuthMiddleware при политике deny отклоняет запросы без пользователя от прокси
+func proxyAuthMiddleware(next http.Handler) http.Handler {
+	if !proxyAuthEnabled() || ProxyAuthFallback != ProxyFallbackDeny {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if proxyUser(r) == "" {
+			http.Error(w, "Unauthorized: authenticate through the proxy", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestProxySessionConcurrentFirstLogin(t *testing.T) {
+	// Первые запросы одного пользователя приходят одновременно
+	const requests = 8
+	before := countSessionDirs(t)
+	ids := make([]string, requests)
+	var wg sync.WaitGroup
+	for i := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, err := proxySession("dave@example.com")
+			if err != nil {
+				t.Error(err)
+			}
+			ids[i] = id
+		}()
+	}
+	wg.Wait()
+
+	for _, id := range ids[1:] {
+		if id != ids[0] {
+			t.Fatalf("proxy user got different sessions: %v", ids)
+		}
+	}
+	if !IsValidID(ids[0]) {
+		t.Fatalf("invalid session ID %q", ids[0])
+	}
+	if _, err := os.Stat(userPath(ids[0])); err != nil {
+		t.Errorf("session directory: %v", err)
+	}
+
+	// Проигравшие гонку запросы убирают за собой директории сессий
+	if after := countSessionDirs(t); after != before+1 {
+		t.Errorf("%d session directories after first login, want %d", after, before+1)
+	}
+}
+
+// countSessionDirs считает директории сессий в DataPath
+func countSessionDirs(t *testing.T) int {
+	t.Helper()
+	entries, err := os.ReadDir(DataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for _, entry := range entries {
+		if entry.IsDir() && !isHiddenName(entry.Name()) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestCreateSessionClaimsUniqueIDs(t *testing.T) {
+	seen := map[string]bool{}
+	for range 200 {
+		id, err := createSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[id] {
+			t.Fatalf("createSession returned %s twice", id)
+		}
+		seen[id] = true
+	}
+}
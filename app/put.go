@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// putHandler принимает изображение сырым телом запроса:
+//
+//	curl -H "Authorization: Bearer ripx_..." --upload-file shot.png https://host/put/shot.png
+//
+// Альбом берется из заголовка X-Album-ID или параметра ?album= и должен существовать, иначе создается новый.
+// В ответ отдается прямая ссылка на изображение в виде простого текста.
+func putHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debug(fmt.Sprintf("putHandler: request received, method=%s, path=%s", r.Method, r.URL.Path))
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Скрипты авторизуются API токеном, браузер - cookie сессии
+	caller, ok := requireCaller(w, r, ScopeUpload)
+	if !ok {
+		return
+	}
+	sessionID := caller.SessionID
+
+	albumID := r.Header.Get(AlbumHeaderName)
+	if albumID == "" {
+		albumID = r.URL.Query().Get("album")
+	}
+	if albumID != "" && !IsValidID(albumID) {
+		http.Error(w, "Invalid album ID", http.StatusBadRequest)
+		return
+	}
+	// Новые альбомы создаются только через createAlbum, как в getAlbumID
+	if albumID != "" && !albumExists(sessionID, albumID) {
+		http.Error(w, "Album not found", http.StatusNotFound)
+		return
+	}
+
+	// Читаем тело целиком, но не больше MaxFileSize
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxFileSize))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+	if len(data) == 0 {
+		http.Error(w, "Empty request body", http.StatusBadRequest)
+		return
+	}
+
+	if albumID == "" {
+		albumID, err = createAlbum(sessionID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error creating album: %v", err), uploadErrorStatus(err, http.StatusInternalServerError))
+			return
+		}
+	}
+
+	// Имя из пути storeImage очищает так же, как имя файла из multipart формы
+	originalName := strings.TrimPrefix(r.URL.Path, "/put/")
+	info, err := storeImage(bytes.NewReader(data), int64(len(data)), originalName, sessionID, albumID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Upload failed: %v", err), uploadErrorStatus(err, http.StatusBadRequest))
+		return
+	}
+
+	// Альбом отдаем заголовком, чтобы скрипт мог продолжить загрузку в него
+	w.Header().Set(AlbumHeaderName, albumID)
+	if url := deletionURL(r, info); url != "" {
+		w.Header().Set(DeletionURLHeaderName, url)
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, BaseURL(r)+"/"+sessionID+"/"+albumID+"/"+info.Filename)
+}
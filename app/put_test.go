@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"ripx/client"
+)
+
+// putRequest отправляет PUT /put/<name> с токеном и возвращает код и тело ответа
+func putRequest(t *testing.T, token, name, albumID string, body []byte) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPut, testServer.URL+"/put/"+url.PathEscape(name), bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if albumID != "" {
+		req.Header.Set(AlbumHeaderName, albumID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(raw)
+}
+
+func TestPutUpload(t *testing.T) {
+	c := newTestClient(t)
+	album, err := c.CreateAlbum(context.Background(), client.AlbumUpdate{})
+	if err != nil {
+		t.Fatalf("CreateAlbum: %v", err)
+	}
+
+	// В ответе прямая ссылка на изображение в указанном альбоме
+	content := testPNG(t, 11)
+	status, body := putRequest(t, c.Token(), "..\\..\\evil\x01.png", album.ID, content)
+	if status != http.StatusOK {
+		t.Fatalf("PUT returned %d: %s", status, body)
+	}
+	link := strings.TrimSpace(body)
+	prefix := testServer.URL + "/" + c.SessionID() + "/" + album.ID + "/"
+	if !strings.HasPrefix(link, prefix) {
+		t.Fatalf("PUT returned %q, want a link under %q", link, prefix)
+	}
+	resp, err := http.Get(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	served, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(served, content) {
+		t.Errorf("GET %s: %d, %d bytes, want the uploaded image", link, resp.StatusCode, len(served))
+	}
+
+	// Исходное имя из пути запроса хранится только базовым именем без управляющих символов
+	meta, err := loadAlbumMeta(c.SessionID(), album.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := meta.Images[strings.TrimPrefix(link, prefix)].OriginalName; name != "evil.png" {
+		t.Errorf("original name %q, want %q", name, "evil.png")
+	}
+}
+
+func TestPutUploadRejects(t *testing.T) {
+	c := newTestClient(t)
+	album, err := c.CreateAlbum(context.Background(), client.AlbumUpdate{})
+	if err != nil {
+		t.Fatalf("CreateAlbum: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		albumID string
+		body    []byte
+		want    int
+	}{
+		{"unknown album", RandomID(), testPNG(t, 12), http.StatusNotFound},
+		{"invalid album", "../..", testPNG(t, 12), http.StatusBadRequest},
+		{"empty body", album.ID, nil, http.StatusBadRequest},
+		{"too large", album.ID, make([]byte, MaxFileSize+1), http.StatusRequestEntityTooLarge},
+		{"not an image", album.ID, []byte("not an image"), http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, body := putRequest(t, c.Token(), "shot.png", tt.albumID, tt.body); status != tt.want {
+				t.Errorf("PUT returned %d (%s), want %d", status, strings.TrimSpace(body), tt.want)
+			}
+		})
+	}
+
+	// Отклоненные загрузки не оставляют файлов в альбоме
+	images, _, err := c.ListImages(context.Background(), album.ID, 1, 10)
+	if err != nil {
+		t.Fatalf("ListImages: %v", err)
+	}
+	if len(images) != 0 {
+		t.Errorf("album has %d images after rejected uploads", len(images))
+	}
+}
+
+// formUploadRequest отправляет POST /upload с одним файлом и токеном и возвращает код ответа
+func formUploadRequest(t *testing.T, token, albumID string, content []byte) int {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("album_id", albumID)
+	part, err := mw.CreateFormFile("image", "shot.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	mw.Close()
+
+	req, err := http.NewRequest(http.MethodPost, testServer.URL+"/upload", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestUploadErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{ErrFileTooLarge, http.StatusRequestEntityTooLarge},
+		{fmt.Errorf("error saving file a.png: %w", ErrInvalidImageType), http.StatusUnsupportedMediaType},
+		{ErrContentRejected, http.StatusUnprocessableEntity},
+		{ErrInfectedFile, http.StatusUnprocessableEntity},
+		{ErrScanUnavailable, http.StatusServiceUnavailable},
+		{ErrQuotaExceeded, http.StatusForbidden},
+		{ErrInsufficientStorage, http.StatusInsufficientStorage},
+		{errors.Join(errors.New("disk error"), ErrInfectedFile), http.StatusUnprocessableEntity},
+		{errors.New("disk error"), http.StatusTeapot},
+	}
+	for _, tt := range tests {
+		if got := uploadErrorStatus(tt.err, http.StatusTeapot); got != tt.want {
+			t.Errorf("uploadErrorStatus(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestUploadStatusesMatchAPI(t *testing.T) {
+	c := newTestClient(t)
+	album, err := c.CreateAlbum(context.Background(), client.AlbumUpdate{})
+	if err != nil {
+		t.Fatalf("CreateAlbum: %v", err)
+	}
+
+	// /put и /upload отвечают на ошибки хранилища теми же кодами, что и JSON API
+	tests := []struct {
+		name  string
+		setup func(t *testing.T)
+		body  []byte
+		want  int
+	}{
+		{"not an image", func(*testing.T) {}, []byte("not an image"), http.StatusUnsupportedMediaType},
+		{"infected", func(t *testing.T) {
+			clamd := newFakeClamd(t, "stream: Eicar-Test-Signature FOUND", false)
+			useScanner(t, clamd.ln.Addr().String(), ScanFailClosed, time.Second)
+		}, testPNG(t, 13), http.StatusUnprocessableEntity},
+		{"scanner down", func(t *testing.T) {
+			useScanner(t, closedAddr(t), ScanFailClosed, time.Second)
+		}, testPNG(t, 14), http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setup(t)
+			if status, body := putRequest(t, c.Token(), "shot.png", album.ID, tt.body); status != tt.want {
+				t.Errorf("PUT returned %d (%s), want %d", status, strings.TrimSpace(body), tt.want)
+			}
+			if status := formUploadRequest(t, c.Token(), album.ID, tt.body); status != tt.want {
+				t.Errorf("/upload returned %d, want %d", status, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrQuotaExceeded - загрузка или новый альбом не помещаются в квоту владельца
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// Quota - ограничения владельца (сессии или аккаунта); ноль означает "без ограничения"
+type Quota struct {
+	Bytes  byteSize `json:"bytes"`
+	Images int      `json:"images"`
+	Albums int      `json:"albums"`
+}
+
+// Unlimited сообщает, что квота ничего не ограничивает
+func (q Quota) Unlimited() bool {
+	return q == Quota{}
+}
+
+// StorageUsage - сколько занимает владелец
+type StorageUsage struct {
+	Bytes  byteSize
+	Images int
+	Albums int
+}
+
+func (u StorageUsage) add(other StorageUsage) StorageUsage {
+	return StorageUsage{Bytes: u.Bytes + other.Bytes, Images: u.Images + other.Images, Albums: u.Albums + other.Albums}
+}
+
+func (u StorageUsage) sub(other StorageUsage) StorageUsage {
+	return StorageUsage{Bytes: u.Bytes - other.Bytes, Images: u.Images - other.Images, Albums: u.Albums - other.Albums}
+}
+
+// check проверяет, что used вместе с need не выходит за квоту
+func (q Quota) check(used, need StorageUsage) error {
+	total := used.add(need)
+	switch {
+	case q.Bytes > 0 && need.Bytes > 0 && total.Bytes > q.Bytes:
+		return fmt.Errorf("%w: %s of %s used", ErrQuotaExceeded, used.Bytes, q.Bytes)
+	case q.Images > 0 && need.Images > 0 && total.Images > q.Images:
+		return fmt.Errorf("%w: %d of %d images", ErrQuotaExceeded, used.Images, q.Images)
+	case q.Albums > 0 && need.Albums > 0 && total.Albums > q.Albums:
+		return fmt.Errorf("%w: %d of %d albums", ErrQuotaExceeded, used.Albums, q.Albums)
+	}
+	return nil
+}
+
+// defaultQuota - квота владельцев без индивидуальной настройки
+var defaultQuota Quota
+
+// setupQuotas читает квоты по умолчанию при запуске
+func setupQuotas() error {
+	bytes, err := parseByteSize(QuotaBytes)
+	if err != nil {
+		return fmt.Errorf("RIPX_QUOTA_BYTES: %w", err)
+	}
+	images, err := strconv.Atoi(QuotaImages)
+	if err != nil || images < 0 {
+		return fmt.Errorf("RIPX_QUOTA_IMAGES: invalid count %q", QuotaImages)
+	}
+	albums, err := strconv.Atoi(QuotaAlbums)
+	if err != nil || albums < 0 {
+		return fmt.Errorf("RIPX_QUOTA_ALBUMS: invalid count %q", QuotaAlbums)
+	}
+	defaultQuota = Quota{Bytes: bytes, Images: images, Albums: albums}
+	return nil
+}
+
+// byteUnits - множители суффиксов размера
+var byteUnits = map[string]float64{
+	"":  1,
+	"b": 1,
+	"k": 1 << 10, "kb": 1e3, "kib": 1 << 10,
+	"m": 1 << 20, "mb": 1e6, "mib": 1 << 20,
+	"g": 1 << 30, "gb": 1e9, "gib": 1 << 30,
+	"t": 1 << 40, "tb": 1e12, "tib": 1 << 40,
+}
+
+// parseByteSize разбирает размер вида "1073741824", "500MB" или "1.5 GiB"
+func parseByteSize(value string) (byteSize, error) {
+	value = strings.TrimSpace(value)
+	split := strings.IndexFunc(value, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if split < 0 {
+		split = len(value)
+	}
+	number, err := strconv.ParseFloat(value[:split], 64)
+	unit, known := byteUnits[strings.ToLower(strings.TrimSpace(value[split:]))]
+	if err != nil || !known || number < 0 || number*unit > math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return byteSize(number * unit), nil
+}
+
+// quotaStore хранит индивидуальные квоты владельцев в StatePath/QuotasStateFile
+type quotaStore struct {
+	mu        sync.Mutex
+	loaded    bool
+	overrides map[string]Quota
+}
+
+var quotas = &quotaStore{}
+
+// load лениво читает квоты с диска (вызывается под мьютексом)
+func (s *quotaStore) load() error {
+	if s.loaded {
+		return nil
+	}
+	s.overrides = make(map[string]Quota)
+	if err := loadState(QuotasStateFile, &s.overrides); err != nil {
+		return err
+	}
+	s.loaded = true
+	return nil
+}
+
+// save записывает квоты на диск (вызывается под мьютексом)
+func (s *quotaStore) save() error {
+	return saveState(QuotasStateFile, s.overrides)
+}
+
+// Limit возвращает квоту владельца и признак того, что она задана администратором
+func (s *quotaStore) Limit(sessionID string) (Quota, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return Quota{}, false, err
+	}
+
+	if quota, ok := s.overrides[sessionID]; ok {
+		return quota, true, nil
+	}
+	return defaultQuota, false, nil
+}
+
+// Set задает владельцу индивидуальную квоту
+func (s *quotaStore) Set(sessionID string, quota Quota) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	s.overrides[sessionID] = quota
+	return s.save()
+}
+
+// Reset возвращает владельцу квоту по умолчанию
+func (s *quotaStore) Reset(sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	if _, ok := s.overrides[sessionID]; !ok {
+		return nil
+	}
+	delete(s.overrides, sessionID)
+	return s.save()
+}
+
+// quotaOwner - резервы одного владельца. Его мьютекс держится на время подсчета места,
+// проверки и резервирования, поэтому параллельные загрузки одного владельца не могут вместе
+// превысить квоту, а обход диска одного владельца не задерживает загрузки остальных.
+type quotaOwner struct {
+	mu      sync.Mutex
+	pending StorageUsage // место, занятое загрузками, которые еще пишутся на диск
+	refs    int          // резервы и ожидающие их вызовы; под quotaReservations.mu
+}
+
+// quotaReservations - владельцы с резервами; глобальный мьютекс защищает только карту
+var quotaReservations = struct {
+	mu     sync.Mutex
+	owners map[string]*quotaOwner
+}{owners: make(map[string]*quotaOwner)}
+
+// acquireQuotaOwner возвращает запись владельца, не давая ее удалить до releaseQuotaOwner
+func acquireQuotaOwner(sessionID string) *quotaOwner {
+	quotaReservations.mu.Lock()
+	defer quotaReservations.mu.Unlock()
+	owner := quotaReservations.owners[sessionID]
+	if owner == nil {
+		owner = &quotaOwner{}
+		quotaReservations.owners[sessionID] = owner
+	}
+	owner.refs++
+	return owner
+}
+
+// releaseQuotaOwner забывает владельца, когда у него не осталось резервов
+func releaseQuotaOwner(sessionID string, owner *quotaOwner) {
+	quotaReservations.mu.Lock()
+	defer quotaReservations.mu.Unlock()
+	owner.refs--
+	if owner.refs == 0 {
+		delete(quotaReservations.owners, sessionID)
+	}
+}
+
+// reserveQuota проверяет, что need помещается в квоту владельца, и резервирует место
+// до вызова release. Резерв снимается после записи: файл к этому времени уже учтен на диске.
+func reserveQuota(sessionID string, need StorageUsage) (release func(), err error) {
+	quota, _, err := quotas.Limit(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if quota.Unlimited() {
+		return func() {}, nil
+	}
+
+	owner := acquireQuotaOwner(sessionID)
+	owner.mu.Lock()
+	used, err := measureUsage(sessionID)
+	if err == nil {
+		err = quota.check(used.add(owner.pending), need)
+	}
+	if err != nil {
+		owner.mu.Unlock()
+		releaseQuotaOwner(sessionID, owner)
+		return nil, err
+	}
+	owner.pending = owner.pending.add(need)
+	owner.mu.Unlock()
+
+	return func() {
+		owner.mu.Lock()
+		owner.pending = owner.pending.sub(need)
+		owner.mu.Unlock()
+		releaseQuotaOwner(sessionID, owner)
+	}, nil
+}
+
+// measureUsage считает альбомы, изображения и их размер в директории владельца
+func measureUsage(sessionID string) (StorageUsage, error) {
+	var usage StorageUsage
+	userDir, err := resolveStoragePath(sessionID, "", "")
+	if err != nil {
+		return usage, err
+	}
+
+	err = filepath.WalkDir(userDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			if filepath.Dir(path) == userDir {
+				usage.Albums++
+			}
+			return nil
+		}
+		if !IsImageFile(entry.Name()) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil // файл удалили во время обхода
+		}
+		usage.Images++
+		usage.Bytes += byteSize(info.Size())
+		return nil
+	})
+	return usage, err
+}
+
+// quotaView - занятое место и квота для страниц
+type quotaView struct {
+	Used     StorageUsage
+	Limit    Quota
+	Override bool // квота задана администратором
+}
+
+// loadQuotaView собирает занятое место владельца; nil, если квота его не ограничивает
+func loadQuotaView(sessionID string) *quotaView {
+	limit, override, err := quotas.Limit(sessionID)
+	if err != nil {
+		logger.Error(fmt.Sprintf("loadQuotaView: %v", err))
+		return nil
+	}
+	if limit.Unlimited() && !override {
+		return nil
+	}
+	used, err := measureUsage(sessionID)
+	if err != nil {
+		logger.Error(fmt.Sprintf("loadQuotaView: %v", err))
+		return nil
+	}
+	return &quotaView{Used: used, Limit: limit, Override: override}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// useDefaultQuota задает квоту по умолчанию на время теста
+func useDefaultQuota(t *testing.T, quota Quota) {
+	old := defaultQuota
+	defaultQuota = quota
+	t.Cleanup(func() { defaultQuota = old })
+}
+
+func TestReserveQuotaConcurrent(t *testing.T) {
+	useDefaultQuota(t, Quota{Images: 3})
+	sessionID := RandomID()
+
+	// Резервы одного владельца вместе не выходят за квоту
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		reserved []func()
+	)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if release, err := reserveQuota(sessionID, StorageUsage{Images: 1}); err == nil {
+				mu.Lock()
+				reserved = append(reserved, release)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(reserved) != 3 {
+		t.Fatalf("%d reservations succeeded, want 3", len(reserved))
+	}
+
+	for _, release := range reserved {
+		release()
+	}
+	quotaReservations.mu.Lock()
+	_, tracked := quotaReservations.owners[sessionID]
+	quotaReservations.mu.Unlock()
+	if tracked {
+		t.Error("owner is still tracked after every reservation was released")
+	}
+}
+
+func TestReserveQuotaDoesNotWaitForOtherOwners(t *testing.T) {
+	useDefaultQuota(t, Quota{Images: 3})
+	busyID, otherID := RandomID(), RandomID()
+
+	// Пока у одного владельца идет подсчет места, другой резервирует без ожидания
+	busy := acquireQuotaOwner(busyID)
+	busy.mu.Lock()
+	defer func() {
+		busy.mu.Unlock()
+		releaseQuotaOwner(busyID, busy)
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		release, err := reserveQuota(otherID, StorageUsage{Images: 1})
+		if err == nil {
+			release()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("reserveQuota: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reserveQuota waited for another owner")
+	}
+}
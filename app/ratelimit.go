@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Классы запросов с отдельными бюджетами
+const (
+	RateClassUpload = "upload" // загрузка изображений
+	RateClassAlbum  = "album"  // создание альбомов
+	RateClassDelete = "delete" // удаление изображений, альбомов и сессий
+	RateClassView   = "view"   // просмотр страниц и архивов альбомов
+	RateClassAuth   = "auth"   // вход, коды приглашений и устройств, новые сессии, жалобы, админка
+)
+
+// rateLimitRoutes сопоставляет шаблоны маршрутов классам. Файлы изображений
+// под "/" в бюджет просмотров не входят: страница альбома тянет их десятками.
+var rateLimitRoutes = map[string]string{
+	"/upload":                       RateClassUpload,
+	"/put/":                         RateClassUpload,
+	"POST " + IntegrationUploadPath: RateClassUpload,
+	"POST " + APIPrefix + "/sessions/{session}/albums/{album}/images": RateClassUpload,
+
+	"/create-album": RateClassAlbum,
+	"POST " + APIPrefix + "/sessions/{session}/albums": RateClassAlbum,
+
+	"/delete-image": RateClassDelete,
+	"/delete-album": RateClassDelete,
+	"/delete-user":  RateClassDelete,
+	"POST " + DeletionPathPrefix + "{session}/{album}/{filename}/{key}":            RateClassDelete,
+	"DELETE " + APIPrefix + "/sessions/{session}":                                  RateClassDelete,
+	"DELETE " + APIPrefix + "/sessions/{session}/albums/{album}":                   RateClassDelete,
+	"DELETE " + APIPrefix + "/sessions/{session}/albums/{album}/images/{filename}": RateClassDelete,
+
+	"/": RateClassView,
+
+	"POST " + APIPrefix + "/account":          RateClassAuth,
+	"POST " + APIPrefix + "/account/login":    RateClassAuth,
+	"POST " + APIPrefix + "/account/sessions": RateClassAuth,
+	"POST " + APIPrefix + "/devices":          RateClassAuth,
+	"POST " + APIPrefix + "/invite":           RateClassAuth,
+	"POST " + APIPrefix + "/sessions":         RateClassAuth,
+
+	"POST " + APIPrefix + "/sessions/{session}/albums/{album}/images/{filename}/reports": RateClassAuth,
+}
+
+// isAdminRoute сообщает, что маршрут закрыт Basic auth администратора
+func isAdminRoute(pattern string) bool {
+	path := pattern
+	if _, rest, found := strings.Cut(pattern, " "); found {
+		path = rest
+	}
+	return path == "/admin" || strings.HasPrefix(path, "/admin/") || path == "/metrics" ||
+		strings.HasPrefix(path, APIPrefix+"/admin/")
+}
+
+// tokenBucket - бюджет одного клиента: tokens пополняется до burst со скоростью rate в секунду
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter - token bucket на каждый IP и каждую сессию внутри одного класса
+type rateLimiter struct {
+	class string
+	rate  float64 // токенов в секунду
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	pruned  time.Time
+
+	allowed uint64
+	limited uint64
+}
+
+// rateLimiters - включенные классы; класс без записи не ограничивается
+var rateLimiters = map[string]*rateLimiter{}
+
+// parseRateLimit разбирает бюджет "N/период", например "60/1m"
+func parseRateLimit(value string) (count int, period time.Duration, err error) {
+	countStr, periodStr, found := strings.Cut(value, "/")
+	if !found {
+		return 0, 0, fmt.Errorf("want N/period, got %q", value)
+	}
+	count, err = strconv.Atoi(countStr)
+	if err != nil || count <= 0 {
+		return 0, 0, fmt.Errorf("invalid request count in %q", value)
+	}
+	period, err = time.ParseDuration(periodStr)
+	if err != nil || period <= 0 {
+		return 0, 0, fmt.Errorf("invalid period in %q", value)
+	}
+	return count, period, nil
+}
+
+// setupRateLimits читает бюджеты классов при запуске
+func setupRateLimits() error {
+	budgets := map[string]struct{ env, value string }{
+		RateClassUpload: {"RIPX_RATE_LIMIT_UPLOAD", RateLimitUpload},
+		RateClassAlbum:  {"RIPX_RATE_LIMIT_ALBUM", RateLimitAlbum},
+		RateClassDelete: {"RIPX_RATE_LIMIT_DELETE", RateLimitDelete},
+		RateClassView:   {"RIPX_RATE_LIMIT_VIEW", RateLimitView},
+		RateClassAuth:   {"RIPX_RATE_LIMIT_AUTH", RateLimitAuth},
+	}
+
+	rateLimiters = map[string]*rateLimiter{}
+	for class, budget := range budgets {
+		if budget.value == RateLimitOff {
+			logger.Info(fmt.Sprintf("Rate limiting of %s requests is disabled", class))
+			continue
+		}
+		count, period, err := parseRateLimit(budget.value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", budget.env, err)
+		}
+		rateLimiters[class] = &rateLimiter{
+			class:   class,
+			rate:    float64(count) / period.Seconds(),
+			burst:   float64(count),
+			buckets: make(map[string]*tokenBucket),
+		}
+	}
+	return nil
+}
+
+// refill пополняет бюджет к моменту now (вызывается под мьютексом)
+func (l *rateLimiter) refill(key string, now time.Time) *tokenBucket {
+	bucket := l.buckets[key]
+	if bucket == nil {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[key] = bucket
+		return bucket
+	}
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate)
+	bucket.updated = now
+	return bucket
+}
+
+// Allow списывает по токену со всех ключей клиента. Если хотя бы в одном бюджете пусто,
+// ничего не списывается и возвращается время до появления токена.
+func (l *rateLimiter) Allow(keys []string) (bool, time.Duration) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(now)
+
+	var wait float64
+	buckets := make([]*tokenBucket, 0, len(keys))
+	for _, key := range keys {
+		bucket := l.refill(key, now)
+		if bucket.tokens < 1 {
+			wait = math.Max(wait, (1-bucket.tokens)/l.rate)
+		}
+		buckets = append(buckets, bucket)
+	}
+	if wait > 0 {
+		l.limited++
+		return false, time.Duration(wait * float64(time.Second))
+	}
+
+	for _, bucket := range buckets {
+		bucket.tokens--
+	}
+	l.allowed++
+	return true, 0
+}
+
+// prune раз в RateLimitPruneInterval удаляет полностью восстановившиеся бюджеты:
+// они ничем не отличаются от новых (вызывается под мьютексом)
+func (l *rateLimiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < RateLimitPruneInterval {
+		return
+	}
+	l.pruned = now
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// RateLimitStats - счетчики одного класса для метрик
+type RateLimitStats struct {
+	Class   string
+	Allowed uint64
+	Limited uint64
+	Buckets int
+}
+
+// Stats возвращает счетчики класса
+func (l *rateLimiter) Stats() RateLimitStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return RateLimitStats{Class: l.class, Allowed: l.allowed, Limited: l.limited, Buckets: len(l.buckets)}
+}
+
+// rateLimitStats возвращает счетчики включенных классов в порядке имен
+func rateLimitStats() []RateLimitStats {
+	stats := make([]RateLimitStats, 0, len(rateLimiters))
+	for _, limiter := range rateLimiters {
+		stats = append(stats, limiter.Stats())
+	}
+	slices.SortFunc(stats, func(a, b RateLimitStats) int { return strings.Compare(a.Class, b.Class) })
+	return stats
+}
+
+// rateLimitClass определяет класс запроса по маршруту, который его обработает
+func rateLimitClass(mux *http.ServeMux, r *http.Request) string {
+	_, pattern := mux.Handler(r)
+
+	// В админке бюджет тратят только запросы без верного пароля: подбор ограничен,
+	// а страница альбома с десятками изображений открывается без задержек
+	if isAdminRoute(pattern) {
+		if isAdmin(r) {
+			return ""
+		}
+		return RateClassAuth
+	}
+
+	class := rateLimitRoutes[pattern]
+	if class != RateClassView {
+		return class
+	}
+
+	// Под "/" просмотром считаются главная, страницы альбомов и архивы, но не файлы изображений
+	if r.URL.Path == "/" {
+		return class
+	}
+	route, err := parseContentPath(r)
+	if err != nil || route.Filename != "" {
+		return ""
+	}
+	return class
+}
+
+// rateLimitKeys возвращает бюджеты клиента: адрес и учетные данные, с которыми он пришел.
+// Учетные данные здесь не проверяются: authenticate отмечает использование токенов
+// и устройств и создает аккаунты пользователей прокси, а запрос, который получит 429,
+// не должен делать эту работу. Секреты попадают в ключ только хешем.
+func rateLimitKeys(r *http.Request) []string {
+	keys := []string{"ip:" + clientIP(r)}
+	if secret, ok := bearerToken(r); ok {
+		return append(keys, "token:"+hashToken(secret))
+	}
+	if user := proxyUser(r); user != "" {
+		return append(keys, "proxy:"+user)
+	}
+	if cookie, err := r.Cookie(DeviceCookieName); err == nil && cookie.Value != "" {
+		return append(keys, "device:"+hashToken(cookie.Value))
+	}
+	if cookie, err := r.Cookie(SessionCookieName); err == nil && IsValidID(cookie.Value) {
+		return append(keys, "session:"+cookie.Value)
+	}
+	return keys
+}
+
+// rateLimitMiddleware списывает запрос с бюджета его класса и отвечает 429 с Retry-After,
+// когда бюджет исчерпан
+func rateLimitMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limiter := rateLimiters[rateLimitClass(mux, r)]
+		if limiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed, wait := limiter.Allow(rateLimitKeys(r))
+		if allowed {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		retryAfter := int(math.Ceil(wait.Seconds()))
+		logger.Debug(fmt.Sprintf("Rate limit (%s) exceeded for %s %s from %s", limiter.class, r.Method, r.URL.Path, clientIP(r)))
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			ErrorResponse(w, http.StatusTooManyRequests, ErrCodeRateLimited,
+				fmt.Sprintf("too many %s requests, retry in %d s", limiter.class, retryAfter))
+			return
+		}
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+	})
+}
+
+// metricsHandler отдает счетчики сервера в текстовом формате Prometheus
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	stats := rateLimitStats()
+	var b strings.Builder
+	b.WriteString("# HELP ripx_rate_limit_requests_total Requests checked by the rate limiter.\n")
+	b.WriteString("# TYPE ripx_rate_limit_requests_total counter\n")
+	for _, s := range stats {
+		fmt.Fprintf(&b, "ripx_rate_limit_requests_total{class=%q,result=\"allowed\"} %d\n", s.Class, s.Allowed)
+		fmt.Fprintf(&b, "ripx_rate_limit_requests_total{class=%q,result=\"limited\"} %d\n", s.Class, s.Limited)
+	}
+	b.WriteString("# HELP ripx_rate_limit_buckets Clients tracked by the rate limiter.\n")
+	b.WriteString("# TYPE ripx_rate_limit_buckets gauge\n")
+	for _, s := range stats {
+		fmt.Fprintf(&b, "ripx_rate_limit_buckets{class=%q} %d\n", s.Class, s.Buckets)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
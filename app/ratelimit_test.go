@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"ripx/client"
+)
+
+// useRateLimits включает бюджеты "N/1m" для классов на время теста
+func useRateLimits(t *testing.T, budgets map[string]int) {
+	t.Helper()
+	old := rateLimiters
+	rateLimiters = map[string]*rateLimiter{}
+	for class, count := range budgets {
+		rateLimiters[class] = &rateLimiter{
+			class:   class,
+			rate:    float64(count) / 60,
+			burst:   float64(count),
+			buckets: make(map[string]*tokenBucket),
+		}
+	}
+	t.Cleanup(func() { rateLimiters = old })
+}
+
+// useTrustedProxies задает RIPX_TRUSTED_PROXIES на время теста
+func useTrustedProxies(t *testing.T, proxies ...string) {
+	t.Helper()
+	old := TrustedProxies
+	TrustedProxies = proxies
+	if err := loadTrustedProxies(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		TrustedProxies = old
+		loadTrustedProxies()
+	})
+}
+
+// limitedRequest отправляет запрос без cookie с заданными заголовками и возвращает ответ с прочитанным телом
+func limitedRequest(t *testing.T, method, path string, header http.Header) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, testServer.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	useRateLimits(t, map[string]int{RateClassAuth: 2, RateClassView: 1})
+
+	for range 2 {
+		if resp, body := limitedRequest(t, "POST", "/api/v1/sessions", nil); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("create session within budget: %d %s", resp.StatusCode, body)
+		}
+	}
+	resp, body := limitedRequest(t, "POST", "/api/v1/sessions", nil)
+	if resp.StatusCode != http.StatusTooManyRequests || !strings.Contains(body, ErrCodeRateLimited) {
+		t.Fatalf("create session over budget: %d %s, want 429 %s", resp.StatusCode, body, ErrCodeRateLimited)
+	}
+	// Один токен из двух в минуту появляется через 30 секунд
+	if retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After")); err != nil || retryAfter < 1 || retryAfter > 30 {
+		t.Errorf("Retry-After %q, want 1..30 seconds", resp.Header.Get("Retry-After"))
+	}
+
+	// Страницы отвечают на превышение текстом, но тоже с Retry-After
+	limitedRequest(t, "GET", "/", nil)
+	resp, body = limitedRequest(t, "GET", "/", nil)
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" || strings.Contains(body, ErrCodeRateLimited) {
+		t.Errorf("page over budget: %d %q, Retry-After %q", resp.StatusCode, body, resp.Header.Get("Retry-After"))
+	}
+}
+
+func TestRateLimitClasses(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+	album, err := c.CreateAlbum(ctx, client.AlbumUpdate{})
+	if err != nil {
+		t.Fatalf("CreateAlbum: %v", err)
+	}
+	img := storeTestImage(t, c.SessionID(), album.ID, 80)
+	useRateLimits(t, map[string]int{RateClassAuth: 1, RateClassAlbum: 2, RateClassView: 1})
+
+	// Исчерпанный бюджет одного класса не мешает другим
+	limitedRequest(t, "POST", "/api/v1/sessions", nil)
+	if resp, _ := limitedRequest(t, "POST", "/api/v1/sessions", nil); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("auth over budget: %d, want 429", resp.StatusCode)
+	}
+	for range 2 {
+		if _, err := c.CreateAlbum(ctx, client.AlbumUpdate{}); err != nil {
+			t.Fatalf("CreateAlbum within the album budget: %v", err)
+		}
+	}
+	var apiErr *client.Error
+	if _, err := c.CreateAlbum(ctx, client.AlbumUpdate{}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("CreateAlbum over budget: %v, want 429", err)
+	}
+
+	// Файлы изображений не входят в бюджет просмотров, страница альбома входит
+	imagePath := "/" + c.SessionID() + "/" + album.ID + "/" + img.Filename
+	for range 3 {
+		if resp, _ := limitedRequest(t, "GET", imagePath, nil); resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET image: %d, want 200", resp.StatusCode)
+		}
+	}
+	albumPage := "/" + c.SessionID() + "/" + album.ID
+	if resp, _ := limitedRequest(t, "GET", albumPage, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET album page: %d, want 200", resp.StatusCode)
+	}
+	if resp, _ := limitedRequest(t, "GET", albumPage, nil); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("second album page: %d, want 429", resp.StatusCode)
+	}
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	useTrustedProxies(t, "10.0.0.0/8")
+	sessionID := RandomID()
+	tests := []struct {
+		name   string
+		remote string
+		header http.Header
+		want   []string
+	}{
+		{"direct client", "203.0.113.5:1234", nil, []string{"ip:203.0.113.5"}},
+		{"forged X-Forwarded-For", "203.0.113.5:1234", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, []string{"ip:203.0.113.5"}},
+		{"trusted proxy", "10.0.0.2:1234", http.Header{"X-Forwarded-For": {"198.51.100.1, 10.0.0.3"}}, []string{"ip:198.51.100.1"}},
+		{"spoofed hop before the client", "10.0.0.2:1234", http.Header{"X-Forwarded-For": {"192.0.2.7, 198.51.100.1"}}, []string{"ip:198.51.100.1"}},
+		{"broken X-Forwarded-For", "10.0.0.2:1234", http.Header{"X-Forwarded-For": {"unknown"}}, []string{"ip:10.0.0.2"}},
+		{"bearer token", "203.0.113.5:1234", http.Header{"Authorization": {"Bearer ripx_secret"}}, []string{"ip:203.0.113.5", "token:" + hashToken("ripx_secret")}},
+		{"device cookie", "203.0.113.5:1234", http.Header{"Cookie": {DeviceCookieName + "=device_secret"}}, []string{"ip:203.0.113.5", "device:" + hashToken("device_secret")}},
+		{"session cookie", "203.0.113.5:1234", http.Header{"Cookie": {SessionCookieName + "=" + sessionID}}, []string{"ip:203.0.113.5", "session:" + sessionID}},
+		{"invalid session cookie", "203.0.113.5:1234", http.Header{"Cookie": {SessionCookieName + "=../x"}}, []string{"ip:203.0.113.5"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/upload", nil)
+			r.RemoteAddr = tt.remote
+			for name, values := range tt.header {
+				r.Header[name] = values
+			}
+			if got := rateLimitKeys(r); !slices.Equal(got, tt.want) {
+				t.Errorf("rateLimitKeys = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitPerForwardedClient(t *testing.T) {
+	useTrustedProxies(t, "127.0.0.1")
+	useRateLimits(t, map[string]int{RateClassAuth: 1})
+	from := func(ip string) http.Header { return http.Header{"X-Forwarded-For": {ip}} }
+
+	if resp, _ := limitedRequest(t, "POST", "/api/v1/sessions", from("198.51.100.1")); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("first client: %d, want 201", resp.StatusCode)
+	}
+	if resp, _ := limitedRequest(t, "POST", "/api/v1/sessions", from("198.51.100.1")); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("first client over budget: %d, want 429", resp.StatusCode)
+	}
+	if resp, _ := limitedRequest(t, "POST", "/api/v1/sessions", from("198.51.100.2")); resp.StatusCode != http.StatusCreated {
+		t.Errorf("second client behind the same proxy: %d, want 201", resp.StatusCode)
+	}
+}
+
+func TestRateLimitedRequestHasNoSideEffects(t *testing.T) {
+	sessionID := RandomID()
+	token, secret, err := tokens.Create(sessionID, "ci", []string{ScopeUpload}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldHeader := ProxyAuthHeader
+	ProxyAuthHeader = "X-Forwarded-User"
+	t.Cleanup(func() { ProxyAuthHeader = oldHeader })
+	useTrustedProxies(t, "127.0.0.1")
+	useRateLimits(t, map[string]int{RateClassAlbum: 1})
+
+	// Бюджет адреса исчерпан запросом без учетных данных
+	path := "/api/v1/sessions/" + sessionID + "/albums"
+	limitedRequest(t, "POST", path, nil)
+
+	if resp, _ := limitedRequest(t, "POST", path, http.Header{"Authorization": {"Bearer " + secret}}); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("token request over budget: %d, want 429", resp.StatusCode)
+	}
+	list, err := tokens.List(sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, listed := range list {
+		if listed.ID == token.ID && listed.LastUsedAt != nil {
+			t.Error("rejected request marked the token as used")
+		}
+	}
+
+	before := countSessionDirs(t)
+	if resp, _ := limitedRequest(t, "POST", path, http.Header{"X-Forwarded-User": {"limited@example.com"}}); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("proxy user request over budget: %d, want 429", resp.StatusCode)
+	}
+	if account, _ := accounts.FindBySubject(ProxyAuthIssuer, "limited@example.com"); account != nil || countSessionDirs(t) != before {
+		t.Error("rejected request provisioned the proxy user")
+	}
+}
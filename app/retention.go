@@ -0,0 +1,302 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Политики хранения
+const (
+	RetentionByAge  = "age"  // удалять изображения старше CleanupDuration
+	RetentionBySize = "size" // держать хранилище в пределах RIPX_STORAGE_BUDGET
+)
+
+// Порядок вытеснения альбомов при политике size
+const (
+	EvictLeastAccessed = "accessed" // давно не открывавшиеся альбомы первыми
+	EvictOldest        = "oldest"   // старые альбомы первыми
+)
+
+// storageBudget - разобранный RIPX_STORAGE_BUDGET
+var storageBudget byteSize
+
+// setupRetention проверяет настройки политики хранения при запуске
+func setupRetention() error {
+	switch RetentionPolicy {
+	case RetentionByAge:
+		return nil
+	case RetentionBySize:
+	default:
+		return fmt.Errorf("RIPX_RETENTION_POLICY must be %q or %q", RetentionByAge, RetentionBySize)
+	}
+
+	if EvictionOrder != EvictLeastAccessed && EvictionOrder != EvictOldest {
+		return fmt.Errorf("RIPX_EVICTION_ORDER must be %q or %q", EvictLeastAccessed, EvictOldest)
+	}
+	if StorageBudget == "" {
+		return errors.New("RIPX_RETENTION_POLICY=size requires RIPX_STORAGE_BUDGET")
+	}
+	budget, err := parseByteSize(StorageBudget)
+	if err != nil || budget == 0 {
+		return fmt.Errorf("RIPX_STORAGE_BUDGET: invalid size %q", StorageBudget)
+	}
+	storageBudget = budget
+
+	mode := ""
+	if RetentionDryRun {
+		mode = ", dry run"
+	}
+	logger.Info(fmt.Sprintf("Retention: keep storage under %s, evicting %s albums first%s", budget, EvictionOrder, mode))
+	return nil
+}
+
+// accessLog запоминает, когда альбомы открывали в последний раз. Время копится в памяти
+// и записывается в StatePath/AccessStateFile перед каждым проходом очистки и при остановке.
+type accessLog struct {
+	mu     sync.Mutex
+	loaded bool
+	dirty  bool
+	albums map[string]time.Time // ключ - "сессия/альбом"
+}
+
+var albumAccess = &accessLog{}
+
+// load лениво читает журнал с диска (вызывается под мьютексом)
+func (l *accessLog) load() error {
+	if l.loaded {
+		return nil
+	}
+	l.albums = make(map[string]time.Time)
+	if err := loadState(AccessStateFile, &l.albums); err != nil {
+		return err
+	}
+	l.loaded = true
+	return nil
+}
+
+// Touch отмечает просмотр альбома. Без политики size ничего не делает.
+func (l *accessLog) Touch(sessionID, albumID string) {
+	if RetentionPolicy != RetentionBySize || EvictionOrder != EvictLeastAccessed {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.load(); err != nil {
+		logger.Error(fmt.Sprintf("accessLog: %v", err))
+		return
+	}
+	l.albums[sessionID+"/"+albumID] = time.Now()
+	l.dirty = true
+}
+
+// Snapshot возвращает копию журнала
+func (l *accessLog) Snapshot() (map[string]time.Time, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	snapshot := make(map[string]time.Time, len(l.albums))
+	for key, at := range l.albums {
+		snapshot[key] = at
+	}
+	return snapshot, nil
+}
+
+// Flush записывает журнал на диск, забывая альбомы, которых больше нет
+func (l *accessLog) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.loaded {
+		return nil
+	}
+	for key := range l.albums {
+		sessionID, albumID, _ := strings.Cut(key, "/")
+		if !albumExists(sessionID, albumID) {
+			delete(l.albums, key)
+			l.dirty = true
+		}
+	}
+	if !l.dirty {
+		return nil
+	}
+	if err := saveState(AccessStateFile, l.albums); err != nil {
+		return err
+	}
+	l.dirty = false
+	return nil
+}
+
+// RetentionStatus - итог последнего прохода политики size для админки
+type RetentionStatus struct {
+	Budget      byteSize
+	Used        byteSize // после прохода (при dry run - сколько осталось бы)
+	Evicted     int      // альбомов удалено (при dry run - удалилось бы)
+	EvictedSize byteSize
+	Exempt      int // закрепленных альбомов и альбомов со скрытыми изображениями
+	DryRun      bool
+	LastRun     time.Time
+}
+
+var (
+	retentionStatus   RetentionStatus
+	retentionStatusMu sync.Mutex
+)
+
+// getRetentionStatus возвращает копию итога последнего прохода
+func getRetentionStatus() RetentionStatus {
+	retentionStatusMu.Lock()
+	defer retentionStatusMu.Unlock()
+	status := retentionStatus
+	status.Budget, status.DryRun = storageBudget, RetentionDryRun
+	return status
+}
+
+// retentionAlbum - альбом, который может уйти при превышении бюджета
+type retentionAlbum struct {
+	SessionID string
+	AlbumID   string
+	Size      byteSize
+	LastUsed  time.Time // время просмотра или создания, в зависимости от EvictionOrder
+	Exempt    bool      // закреплен или содержит скрытые модератором изображения
+}
+
+// collectRetentionAlbums собирает все альбомы хранилища с размерами
+func collectRetentionAlbums() ([]retentionAlbum, error) {
+	accessed, err := albumAccess.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := os.ReadDir(DataPath)
+	if err != nil {
+		return nil, err
+	}
+	var albums []retentionAlbum
+	for _, session := range sessions {
+		// Служебная директория сервера (StatePath) не относится к пользователям
+		if !session.IsDir() || isHiddenName(session.Name()) {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(DataPath, session.Name()))
+		if err != nil {
+			logger.Error(fmt.Sprintf("collectRetentionAlbums: %v", err))
+			continue
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			sessionID, albumID := session.Name(), entry.Name()
+			info := getAlbumInfo(sessionID, albumID)
+			album := retentionAlbum{
+				SessionID: sessionID,
+				AlbumID:   albumID,
+				LastUsed:  info.CreatedAt,
+				Exempt:    info.Pinned || len(moderation.QuarantinedInAlbum(sessionID, albumID)) > 0,
+			}
+			if at, ok := accessed[sessionID+"/"+albumID]; ok && EvictionOrder == EvictLeastAccessed && at.After(album.LastUsed) {
+				album.LastUsed = at
+			}
+			images, _ := getUserImages(sessionID, albumID)
+			for _, img := range images {
+				album.Size += byteSize(img.Size)
+			}
+			albums = append(albums, album)
+		}
+	}
+	return albums, nil
+}
+
+// planEviction выбирает альбомы, без которых хранилище уложится в budget. Закрепленные
+// альбомы и альбомы со скрытыми изображениями учитываются в размере, но не удаляются, пустые
+// альбомы места не освобождают и тоже остаются. С fair альбомы сначала забираются у владельца, занимающего больше всех.
+func planEviction(albums []retentionAlbum, budget byteSize, fair bool) (plan []retentionAlbum, used byteSize) {
+	perOwner := make(map[string]byteSize)
+	var candidates []retentionAlbum
+	for _, album := range albums {
+		used += album.Size
+		perOwner[album.SessionID] += album.Size
+		if !album.Exempt && album.Size > 0 {
+			candidates = append(candidates, album)
+		}
+	}
+	slices.SortStableFunc(candidates, func(a, b retentionAlbum) int { return a.LastUsed.Compare(b.LastUsed) })
+
+	for used > budget && len(candidates) > 0 {
+		next := 0
+		if fair {
+			for i, album := range candidates {
+				owner, best := perOwner[album.SessionID], perOwner[candidates[next].SessionID]
+				if owner > best || (owner == best && album.SessionID < candidates[next].SessionID) {
+					next = i
+				}
+			}
+		}
+		album := candidates[next]
+		candidates = slices.Delete(candidates, next, next+1)
+		plan = append(plan, album)
+		used -= album.Size
+		perOwner[album.SessionID] -= album.Size
+	}
+	return plan, used
+}
+
+// enforceStorageBudget удаляет альбомы, пока хранилище не уложится в RIPX_STORAGE_BUDGET.
+// В режиме dry run только пишет в лог, что было бы удалено.
+func enforceStorageBudget() error {
+	if _, err := os.Stat(DataPath); os.IsNotExist(err) {
+		return nil
+	}
+	if err := albumAccess.Flush(); err != nil {
+		logger.Error("Failed to save album access times: " + err.Error())
+	}
+
+	albums, err := collectRetentionAlbums()
+	if err != nil {
+		return err
+	}
+	plan, used := planEviction(albums, storageBudget, EvictionFair)
+
+	status := RetentionStatus{Used: used, LastRun: time.Now()}
+	for _, album := range albums {
+		if album.Exempt {
+			status.Exempt++
+		}
+	}
+
+	for _, album := range plan {
+		if RetentionDryRun {
+			logger.Info(fmt.Sprintf("Retention dry run: would delete album %s/%s (%s, last used %s)",
+				album.SessionID, album.AlbumID, album.Size, album.LastUsed.Format(time.RFC3339)))
+		} else if err := deleteAlbum(album.SessionID, album.AlbumID); err != nil {
+			logger.Error(fmt.Sprintf("Retention: failed to delete album %s/%s: %v", album.SessionID, album.AlbumID, err))
+			status.Used += album.Size
+			continue
+		} else {
+			logger.Info(fmt.Sprintf("Retention: deleted album %s/%s (%s, last used %s)",
+				album.SessionID, album.AlbumID, album.Size, album.LastUsed.Format(time.RFC3339)))
+		}
+		status.Evicted++
+		status.EvictedSize += album.Size
+	}
+
+	if status.Evicted > 0 && !RetentionDryRun {
+		recordAudit(AuditEvent{Event: AuditRetentionEviction, Detail: fmt.Sprintf("%d albums, %s", status.Evicted, status.EvictedSize)})
+	}
+
+	retentionStatusMu.Lock()
+	retentionStatus = status
+	retentionStatusMu.Unlock()
+
+	if status.Used > storageBudget {
+		return fmt.Errorf("storage uses %s, over the %s budget, and nothing else can be evicted", status.Used, storageBudget)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPlanEviction(t *testing.T) {
+	day := func(n int) time.Time { return time.Date(2024, 1, n, 0, 0, 0, 0, time.UTC) }
+	album := func(session, id string, size byteSize, lastUsed int, exempt bool) retentionAlbum {
+		return retentionAlbum{SessionID: session, AlbumID: id, Size: size, LastUsed: day(lastUsed), Exempt: exempt}
+	}
+	ids := func(plan []retentionAlbum) []string {
+		var out []string
+		for _, a := range plan {
+			out = append(out, a.SessionID+"/"+a.AlbumID)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name     string
+		albums   []retentionAlbum
+		budget   byteSize
+		fair     bool
+		wantPlan []string
+		wantUsed byteSize
+	}{
+		{
+			name:     "within budget",
+			albums:   []retentionAlbum{album("s1", "a", 40, 1, false), album("s2", "b", 60, 2, false)},
+			budget:   100,
+			wantUsed: 100,
+		},
+		{
+			name:     "oldest first until within budget",
+			albums:   []retentionAlbum{album("s1", "new", 50, 3, false), album("s1", "old", 30, 1, false), album("s2", "mid", 40, 2, false)},
+			budget:   60,
+			wantPlan: []string{"s1/old", "s2/mid"},
+			wantUsed: 50,
+		},
+		{
+			name:     "exempt albums count but stay",
+			albums:   []retentionAlbum{album("s1", "pinned", 80, 1, true), album("s1", "a", 30, 2, false)},
+			budget:   50,
+			wantPlan: []string{"s1/a"},
+			wantUsed: 80,
+		},
+		{
+			name:     "empty albums free nothing",
+			albums:   []retentionAlbum{album("s1", "empty", 0, 1, false), album("s1", "a", 30, 2, false), album("s2", "b", 30, 3, false)},
+			budget:   40,
+			wantPlan: []string{"s1/a"},
+			wantUsed: 30,
+		},
+		{
+			name:     "no albums",
+			budget:   10,
+			wantUsed: 0,
+		},
+		{
+			name: "fair takes from the largest owner first",
+			albums: []retentionAlbum{
+				album("small", "old", 20, 1, false),
+				album("big", "a", 50, 2, false),
+				album("big", "b", 40, 3, false),
+			},
+			budget:   50,
+			fair:     true,
+			wantPlan: []string{"big/a", "big/b"},
+			wantUsed: 20,
+		},
+		{
+			name: "fair switches owner once shares even out",
+			albums: []retentionAlbum{
+				album("s1", "a", 30, 1, false),
+				album("s1", "b", 30, 4, false),
+				album("s2", "c", 40, 2, false),
+				album("s2", "d", 10, 3, false),
+			},
+			budget:   60,
+			fair:     true,
+			wantPlan: []string{"s1/a", "s2/c"},
+			wantUsed: 40,
+		},
+		{
+			name:     "fair breaks ties by session",
+			albums:   []retentionAlbum{album("s2", "x", 30, 1, false), album("s1", "y", 30, 2, false)},
+			budget:   30,
+			fair:     true,
+			wantPlan: []string{"s1/y"},
+			wantUsed: 30,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, used := planEviction(tt.albums, tt.budget, tt.fair)
+			if got := ids(plan); !slices.Equal(got, tt.wantPlan) {
+				t.Errorf("plan %v, want %v", got, tt.wantPlan)
+			}
+			if used != tt.wantUsed {
+				t.Errorf("used %d, want %d", used, tt.wantUsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInvalidPath - путь запроса не соответствует грамматике идентификаторов
+var ErrInvalidPath = errors.New("invalid path")
+
+// contentRoute - разобранный URL контента:
+// /{session}/{album}, /{session}/{album}.zip или /{session}/{album}/{filename}
+type contentRoute struct {
+	SessionID string
+	AlbumID   string
+	Filename  string
+	Zip       bool
+}
+
+// parseContentPath разбирает URL контента. Любые экранированные символы (в том числе
+// %2F и %5C), пустые, точечные и скрытые сегменты отклоняются: в корректных ID их не бывает.
+func parseContentPath(r *http.Request) (contentRoute, error) {
+	escaped := strings.TrimPrefix(r.URL.EscapedPath(), "/")
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if escaped != path || strings.ContainsAny(path, "\\\x00") {
+		return contentRoute{}, ErrInvalidPath
+	}
+
+	segments := strings.Split(path, "/")
+	for _, segment := range segments {
+		if segment == "" || segment == "." || segment == ".." || isHiddenName(segment) {
+			return contentRoute{}, ErrInvalidPath
+		}
+	}
+
+	var route contentRoute
+	switch len(segments) {
+	case 2:
+		route.AlbumID, route.Zip = strings.CutSuffix(segments[1], ".zip")
+	case 3:
+		route.AlbumID, route.Filename = segments[1], segments[2]
+		if !IsValidImageFilename(route.Filename) {
+			return contentRoute{}, ErrInvalidPath
+		}
+	default:
+		return contentRoute{}, ErrInvalidPath
+	}
+	route.SessionID = segments[0]
+	if !IsValidID(route.SessionID) || !IsValidID(route.AlbumID) {
+		return contentRoute{}, ErrInvalidPath
+	}
+	return route, nil
+}
+
+// resolveStoragePath строит путь к сессии, альбому или изображению из идентификаторов,
+// пришедших от клиента. albumID и filename могут быть пустыми. Результат всегда лежит
+// внутри DataPath и вне служебной директории StatePath.
+func resolveStoragePath(sessionID, albumID, filename string) (string, error) {
+	if !IsValidID(sessionID) {
+		return "", ErrInvalidPath
+	}
+	elems := []string{DataPath, sessionID}
+	if albumID != "" {
+		if !IsValidID(albumID) {
+			return "", ErrInvalidPath
+		}
+		elems = append(elems, albumID)
+	}
+	if filename != "" {
+		if albumID == "" || !IsValidImageFilename(filename) {
+			return "", ErrInvalidPath
+		}
+		elems = append(elems, filename)
+	}
+
+	path := filepath.Join(elems...)
+	if !ValidatePath(DataPath, path) || ValidatePath(StatePath, path) {
+		return "", ErrInvalidPath
+	}
+	return path, nil
+}
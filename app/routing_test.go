@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// assertUnderSession проверяет, что путь лежит внутри DataPath/{session} и вне StatePath
+func assertUnderSession(t *testing.T, sessionID, path string) {
+	t.Helper()
+	sessionDir := filepath.Join(DataPath, sessionID)
+	rel, err := filepath.Rel(sessionDir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		t.Fatalf("path %q escapes %q", path, sessionDir)
+	}
+	if path != filepath.Clean(path) {
+		t.Fatalf("path %q is not clean", path)
+	}
+	if ValidatePath(StatePath, path) {
+		t.Fatalf("path %q is inside StatePath", path)
+	}
+}
+
+func FuzzParseContentPath(f *testing.F) {
+	for _, seed := range []string{
+		"/abcde/fghij",
+		"/abcde/fghij.zip",
+		"/abcde/fghij/12345.png",
+		"/abcde/fghij/12345.webp",
+		"/../etc/passwd",
+		"/abcde/../fghij",
+		"/abcde/%2e%2e/12345.png",
+		"/abcde/fghij/..%2F..%2F12345.png",
+		"/abcde/fghij/%5c12345.png",
+		"/.ripx/tokens.json",
+		"/abcde/.album.json",
+		"//abcde/fghij",
+		"/abcde/fghij/12345.png/x",
+		"/abcde/fghij\x00/12345.png",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return
+		}
+		route, err := parseContentPath(&http.Request{URL: u})
+		if err != nil {
+			return
+		}
+
+		// Разобранный маршрут должен указывать внутрь сессии
+		path, err := resolveStoragePath(route.SessionID, route.AlbumID, route.Filename)
+		if err != nil {
+			t.Fatalf("parseContentPath(%q) = %+v, but resolveStoragePath failed: %v", raw, route, err)
+		}
+		assertUnderSession(t, route.SessionID, path)
+		if route.Filename != "" && filepath.Base(path) != route.Filename {
+			t.Fatalf("filename %q resolved to %q", route.Filename, path)
+		}
+	})
+}
+
+func FuzzResolveStoragePath(f *testing.F) {
+	f.Add("abcde", "fghij", "12345.png")
+	f.Add("abcde", "", "")
+	f.Add("abcde", "fghij", "")
+	f.Add("..", "fghij", "12345.png")
+	f.Add("abcde", "..", "12345.png")
+	f.Add("abcde", "fghij", "../../12345.png")
+	f.Add(".ripx", "", "")
+	f.Add("abcde", ".ripx", "")
+	f.Add("abcde/..", "fghij", "")
+	f.Add("abcde", "fghij", "..\\12345.png")
+	f.Add("abcde", "", "12345.png")
+
+	f.Fuzz(func(t *testing.T, sessionID, albumID, filename string) {
+		path, err := resolveStoragePath(sessionID, albumID, filename)
+		if err != nil {
+			return
+		}
+		assertUnderSession(t, sessionID, path)
+
+		// Принятые идентификаторы не меняются при сборке пути
+		want := filepath.Join(DataPath, sessionID, albumID, filename)
+		if path != want {
+			t.Fatalf("resolveStoragePath(%q, %q, %q) = %q, want %q", sessionID, albumID, filename, path, want)
+		}
+		if strings.Count(strings.TrimPrefix(path, DataPath), string(filepath.Separator)) > 3 {
+			t.Fatalf("path %q is deeper than session/album/file", path)
+		}
+	})
+}
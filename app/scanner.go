@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Итоги проверки загрузки
+const (
+	ScanClean     = "clean"
+	ScanInfected  = "infected"
+	ScanUnscanned = "unscanned" // сканер недоступен, файл принят по политике fail-open
+)
+
+// Политики на случай недоступного сканера
+const (
+	ScanFailOpen   = "open"   // принять файл без проверки
+	ScanFailClosed = "closed" // отклонить загрузку
+)
+
+// Ошибки проверки загрузок
+var (
+	ErrInfectedFile     = errors.New("file rejected by malware scan")
+	ErrScanUnavailable  = errors.New("malware scanner unavailable, try again later")
+	errClamdSizeLimit   = errors.New("clamd: stream exceeds StreamMaxLength")
+	errClamdBadResponse = errors.New("clamd: unexpected response")
+)
+
+// ScanResult - результат проверки, сохраняется в метаданных изображения
+type ScanResult struct {
+	Scanner   string    `json:"scanner"`
+	Status    string    `json:"status"`
+	Signature string    `json:"signature,omitempty"` // имя сигнатуры для infected
+	Error     string    `json:"error,omitempty"`     // причина для unscanned
+	ScannedAt time.Time `json:"scanned_at"`
+}
+
+// Scanner проверяет содержимое загрузки до того, как оно попадет на диск
+type Scanner interface {
+	Name() string
+	// Scan возвращает ScanClean или ScanInfected с сигнатурой; ошибка означает,
+	// что проверить файл не удалось
+	Scan(ctx context.Context, r io.Reader) (status, signature string, err error)
+}
+
+// uploadScanner - сканер загрузок; nil, если проверка не настроена
+var uploadScanner Scanner
+
+// scanTimeout - время на проверку одного файла, включая подключение
+var scanTimeout time.Duration
+
+// setupScanner читает настройки сканера при запуске
+func setupScanner() error {
+	if ClamdAddr == "" {
+		return nil
+	}
+	if ScanFailPolicy != ScanFailOpen && ScanFailPolicy != ScanFailClosed {
+		return fmt.Errorf("RIPX_SCAN_FAIL_POLICY must be %q or %q", ScanFailOpen, ScanFailClosed)
+	}
+	timeout, err := time.ParseDuration(ScanTimeout)
+	if err != nil || timeout <= 0 {
+		return fmt.Errorf("invalid RIPX_SCAN_TIMEOUT %q", ScanTimeout)
+	}
+	scanner, err := newClamdScanner(ClamdAddr)
+	if err != nil {
+		return err
+	}
+
+	uploadScanner, scanTimeout = scanner, timeout
+	logger.Info(fmt.Sprintf("Upload scanning via %s, fail-%s", scanner.Name(), ScanFailPolicy))
+	return nil
+}
+
+// scanUpload проверяет загрузку настроенным сканером и возвращает указатель src в начало.
+// Без сканера возвращает nil результат.
+func scanUpload(src io.ReadSeeker, originalName, userID, albumID string) (*ScanResult, error) {
+	if uploadScanner == nil {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), scanTimeout)
+	defer cancel()
+	status, signature, err := uploadScanner.Scan(ctx, src)
+	if _, seekErr := src.Seek(0, io.SeekStart); seekErr != nil {
+		return nil, seekErr
+	}
+
+	result := &ScanResult{Scanner: uploadScanner.Name(), Status: status, Signature: signature, ScannedAt: time.Now()}
+	switch {
+	case err != nil:
+		logger.Error(fmt.Sprintf("Scan of upload to %s/%s failed: %v", userID, albumID, err))
+		if ScanFailPolicy == ScanFailClosed {
+			return nil, ErrScanUnavailable
+		}
+		result.Status, result.Error = ScanUnscanned, err.Error()
+		return result, nil
+	case status == ScanInfected:
+		recordAudit(AuditEvent{
+			Event:        AuditUploadInfected,
+			SessionID:    userID,
+			AlbumID:      albumID,
+			OriginalName: originalName,
+			Detail:       result.Scanner + ": " + signature,
+		})
+		logger.Info(fmt.Sprintf("Upload to %s/%s rejected by %s: %s", userID, albumID, result.Scanner, signature))
+		return nil, ErrInfectedFile
+	}
+	return result, nil
+}
+
+// clamdScanner проверяет файлы командой INSTREAM демона clamd
+type clamdScanner struct {
+	network string // unix или tcp
+	address string
+}
+
+// newClamdScanner разбирает адрес вида unix:/run/clamav/clamd.ctl, tcp:host:port или host:port
+func newClamdScanner(addr string) (*clamdScanner, error) {
+	network, address, found := strings.Cut(addr, ":")
+	switch {
+	case found && network == "unix" && address != "":
+		return &clamdScanner{network: "unix", address: address}, nil
+	case found && network == "tcp":
+		addr = address
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid RIPX_CLAMD_ADDR %q: want unix:/path or [tcp:]host:port", ClamdAddr)
+	}
+	return &clamdScanner{network: "tcp", address: addr}, nil
+}
+
+func (c *clamdScanner) Name() string {
+	return "clamd"
+}
+
+// Scan отправляет файл по протоколу INSTREAM: блоки с 4-байтовой длиной в network order,
+// нулевой блок в конце. Ответ с префиксом z завершается NUL.
+func (c *clamdScanner) Scan(ctx context.Context, r io.Reader) (string, string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return "", "", err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", "", err
+	}
+	chunk := make([]byte, ClamdChunkSize)
+	for {
+		n, readErr := r.Read(chunk)
+		if n > 0 {
+			if err := binary.Write(conn, binary.BigEndian, uint32(n)); err != nil {
+				return "", "", err
+			}
+			if _, err := conn.Write(chunk[:n]); err != nil {
+				return "", "", err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return "", "", readErr
+		}
+	}
+	if err := binary.Write(conn, binary.BigEndian, uint32(0)); err != nil {
+		return "", "", err
+	}
+
+	reply, err := io.ReadAll(io.LimitReader(conn, 4096))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", "", err
+	}
+	return parseClamdReply(string(bytes.TrimRight(reply, "\x00\n")))
+}
+
+// parseClamdReply разбирает ответ "stream: OK", "stream: <сигнатура> FOUND" или "... ERROR"
+func parseClamdReply(reply string) (string, string, error) {
+	_, verdict, _ := strings.Cut(reply, ": ")
+	switch {
+	case verdict == "OK":
+		return ScanClean, "", nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return ScanInfected, strings.TrimSuffix(verdict, " FOUND"), nil
+	case strings.Contains(reply, "size limit exceeded"):
+		return "", "", errClamdSizeLimit
+	}
+	return "", "", fmt.Errorf("%w: %q", errClamdBadResponse, reply)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"ripx/client"
+)
+
+// fakeClamd - clamd на локальном порту: принимает INSTREAM и отвечает reply,
+// а с hang читает файл и молчит до конца теста
+type fakeClamd struct {
+	ln    net.Listener
+	reply string
+	hang  bool
+	done  chan struct{}
+
+	mu       sync.Mutex
+	received []byte // содержимое последнего INSTREAM
+}
+
+func newFakeClamd(t *testing.T, reply string, hang bool) *fakeClamd {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeClamd{ln: ln, reply: reply, hang: hang, done: make(chan struct{})}
+	t.Cleanup(func() {
+		close(f.done)
+		ln.Close()
+	})
+	go f.serve()
+	return f
+}
+
+func (f *fakeClamd) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeClamd) handle(conn net.Conn) {
+	defer conn.Close()
+	command := make([]byte, len("zINSTREAM\x00"))
+	if _, err := io.ReadFull(conn, command); err != nil || string(command) != "zINSTREAM\x00" {
+		return
+	}
+
+	var stream []byte
+	for {
+		var size uint32
+		if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+			return
+		}
+		if size == 0 {
+			break
+		}
+		chunk := make([]byte, size)
+		if _, err := io.ReadFull(conn, chunk); err != nil {
+			return
+		}
+		stream = append(stream, chunk...)
+	}
+	f.mu.Lock()
+	f.received = stream
+	f.mu.Unlock()
+
+	if f.hang {
+		<-f.done
+		return
+	}
+	conn.Write([]byte(f.reply + "\x00"))
+}
+
+// useScanner включает проверку загрузок через addr на время теста
+func useScanner(t *testing.T, addr, policy string, timeout time.Duration) {
+	t.Helper()
+	scanner, err := newClamdScanner(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldScanner, oldTimeout, oldPolicy := uploadScanner, scanTimeout, ScanFailPolicy
+	uploadScanner, scanTimeout, ScanFailPolicy = scanner, timeout, policy
+	t.Cleanup(func() { uploadScanner, scanTimeout, ScanFailPolicy = oldScanner, oldTimeout, oldPolicy })
+}
+
+// closedAddr возвращает адрес, на котором никто не слушает
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestClamdScanner(t *testing.T) {
+	// Файл больше блока INSTREAM, чтобы он ушел несколькими блоками
+	content := bytes.Repeat(testPNG(t, 11), ClamdChunkSize/100)
+
+	tests := []struct {
+		name      string
+		reply     string
+		status    string
+		signature string
+		err       error
+	}{
+		{name: "clean", reply: "stream: OK", status: ScanClean},
+		{name: "infected", reply: "stream: Eicar-Test-Signature FOUND", status: ScanInfected, signature: "Eicar-Test-Signature"},
+		{name: "size limit", reply: "INSTREAM size limit exceeded. ERROR", err: errClamdSizeLimit},
+		{name: "unknown reply", reply: "stream: lstat() failed. ERROR", err: errClamdBadResponse},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clamd := newFakeClamd(t, tt.reply, false)
+			scanner, err := newClamdScanner("tcp:" + clamd.ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			status, signature, err := scanner.Scan(context.Background(), bytes.NewReader(content))
+			if status != tt.status || signature != tt.signature || !errors.Is(err, tt.err) {
+				t.Errorf("Scan = %q, %q, %v; want %q, %q, %v", status, signature, err, tt.status, tt.signature, tt.err)
+			}
+			clamd.mu.Lock()
+			received := clamd.received
+			clamd.mu.Unlock()
+			if !bytes.Equal(received, content) {
+				t.Errorf("clamd received %d bytes, want %d", len(received), len(content))
+			}
+		})
+	}
+}
+
+func TestScanUploadFailPolicy(t *testing.T) {
+	content := testPNG(t, 12)
+
+	t.Run("down, fail-open", func(t *testing.T) {
+		useScanner(t, closedAddr(t), ScanFailOpen, time.Second)
+		result, err := scanUpload(bytes.NewReader(content), "a.png", "abcde", "fghij")
+		if err != nil || result == nil || result.Status != ScanUnscanned || result.Error == "" {
+			t.Errorf("scanUpload = %+v, %v; want an unscanned result", result, err)
+		}
+	})
+
+	t.Run("down, fail-closed", func(t *testing.T) {
+		useScanner(t, closedAddr(t), ScanFailClosed, time.Second)
+		if result, err := scanUpload(bytes.NewReader(content), "a.png", "abcde", "fghij"); !errors.Is(err, ErrScanUnavailable) {
+			t.Errorf("scanUpload = %+v, %v; want ErrScanUnavailable", result, err)
+		}
+	})
+
+	t.Run("hanging", func(t *testing.T) {
+		clamd := newFakeClamd(t, "", true)
+		useScanner(t, clamd.ln.Addr().String(), ScanFailClosed, 200*time.Millisecond)
+
+		started := time.Now()
+		result, err := scanUpload(bytes.NewReader(content), "a.png", "abcde", "fghij")
+		if !errors.Is(err, ErrScanUnavailable) {
+			t.Errorf("scanUpload = %+v, %v; want ErrScanUnavailable", result, err)
+		}
+		if elapsed := time.Since(started); elapsed > 5*time.Second {
+			t.Errorf("scan of a hanging clamd took %v, want about scanTimeout", elapsed)
+		}
+	})
+}
+
+func TestUploadRejectedByScanner(t *testing.T) {
+	clamd := newFakeClamd(t, "stream: Eicar-Test-Signature FOUND", false)
+	useScanner(t, clamd.ln.Addr().String(), ScanFailClosed, time.Second)
+
+	ctx := context.Background()
+	c := newTestClient(t)
+	album, err := c.CreateAlbum(ctx, client.AlbumUpdate{})
+	if err != nil {
+		t.Fatalf("CreateAlbum: %v", err)
+	}
+	_, err = c.Upload(ctx, album.ID, "eicar.png", bytes.NewReader(testPNG(t, 13)), -1, nil)
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != ErrCodeInfectedFile {
+		t.Fatalf("Upload of infected file: %v, want %s", err, ErrCodeInfectedFile)
+	}
+
+	album, err = c.GetAlbum(ctx, album.ID)
+	if err != nil || album.ImageCount != 0 {
+		t.Errorf("album after rejected upload: %+v, %v", album, err)
+	}
+}
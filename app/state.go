@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// loadState читает JSON файл служебных данных из StatePath.
+// Если файла еще нет, v остается без изменений.
+func loadState(name string, v interface{}) error {
+	data, err := os.ReadFile(filepath.Join(StatePath, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// saveState атомарно записывает JSON файл служебных данных в StatePath
+func saveState(name string, v interface{}) error {
+	if err := EnsureDir(StatePath); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(StatePath, name)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -9,7 +10,9 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
+	"unicode"
 )
 
 // Path builders for data directories
@@ -19,41 +22,89 @@ func imagePath(userID, albumID, filename string) string {
 	return filepath.Join(DataPath, userID, albumID, filename)
 }
 
-// Глобальная переменная для хранения общего количества изображений
-var TotalImageCount int
+// Ошибки хранилища, по которым обработчики выбирают код ответа
+var (
+	ErrFileTooLarge     = errors.New("file too large")
+	ErrInvalidImageType = errors.New("invalid image type")
+	ErrImageNotFound    = errors.New("image not found")
+	ErrAlbumNotFound    = errors.New("album not found")
+	ErrUserNotFound     = errors.New("user directory not found")
+	ErrNoFreeSessionID  = errors.New("no free session ID")
+)
+
+// TotalImageCount - общее количество изображений. Меняется из обработчиков и из cleanup worker,
+// поэтому счетчик атомарный
+var TotalImageCount atomic.Int64
 
 // ImageInfo хранит информацию об изображении
 type ImageInfo struct {
-	Filename string
-	Path     string
-	Size     int64
-	UserID   string
-	AlbumID  string
+	Filename  string
+	Path      string
+	Size      int64
+	UserID    string
+	AlbumID   string
+	DeleteKey string // ключ ссылки удаления, известен только сразу после загрузки
 }
 
 // AlbumInfo хранит информацию об альбоме
 type AlbumInfo struct {
 	ID         string
 	Name       string
+	Private    bool
+	Pinned     bool
 	ImageCount int
 	CreatedAt  time.Time
 }
 
 // saveImage сохраняет загруженное изображение
 func saveImage(file multipart.File, header *multipart.FileHeader, userID, albumID string) (*ImageInfo, error) {
-	// Проверка размера файла
-	if header.Size > MaxFileSize {
-		return nil, fmt.Errorf("file too large: %d bytes", header.Size)
+	return storeImage(file, header.Size, header.Filename, userID, albumID)
+}
+
+// storeImage проверяет и сохраняет изображение из произвольного источника
+func storeImage(src io.ReadSeeker, size int64, originalName, userID, albumID string) (*ImageInfo, error) {
+	originalName = cleanOriginalName(originalName)
+	extension, err := checkImageFile(src, size)
+	if err != nil {
+		return nil, err
 	}
 
-	// Валидация типа изображения
-	extension, valid := validateImageType(file)
-	if !valid {
-		return nil, fmt.Errorf("invalid image type")
+	// Место в квоте резервируется до дорогих проверок и записи на диск
+	need := StorageUsage{Bytes: byteSize(size), Images: 1}
+	if !albumExists(userID, albumID) {
+		need.Albums = 1
+	}
+	release, err := reserveQuota(userID, need)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Файл не должен опустить свободное место ниже нижней границы
+	if err := checkDiskSpace(size); err != nil {
+		return nil, err
+	}
+
+	// Сверка с блоклистом до записи на диск
+	fingerprint, err := fingerprintImage(src)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkBlocklist(fingerprint, originalName, userID, albumID); err != nil {
+		return nil, err
+	}
+
+	// Проверка сканером, пока файл еще не виден
+	scan, err := scanUpload(src, originalName, userID, albumID)
+	if err != nil {
+		return nil, err
 	}
 
 	// Создание директории для альбома
-	albumPath := albumPath(userID, albumID)
+	albumPath, err := resolveStoragePath(userID, albumID, "")
+	if err != nil {
+		return nil, err
+	}
 	if err := EnsureDir(albumPath); err != nil {
 		return nil, err
 	}
@@ -67,44 +118,113 @@ func saveImage(file multipart.File, header *multipart.FileHeader, userID, albumI
 	if err != nil {
 		return nil, err
 	}
-	defer dst.Close()
 
-	// Копирование содержимого
-	if _, err := io.Copy(dst, file); err != nil {
+	// Недописанный файл (например, на заполненном диске) удаляется, иначе он
+	// остался бы в альбоме битым изображением и занимал место в квоте
+	discard := func(err error) (*ImageInfo, error) {
+		os.Remove(filePath)
 		return nil, err
 	}
 
+	// Копирование содержимого
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return discard(err)
+	}
+
 	// Получение информации о файле
 	stat, err := os.Stat(filePath)
 	if err != nil {
-		return nil, err
+		return discard(err)
+	}
+
+	// Ключ для ссылки удаления; в метаданных хранится только его хеш
+	deleteKey, err := RandomToken(16)
+	if err != nil {
+		return discard(err)
+	}
+
+	// Запоминаем исходное имя файла
+	if err := updateAlbumMeta(userID, albumID, func(meta *AlbumMeta) {
+		meta.Images[filename] = ImageMeta{
+			OriginalName: originalName,
+			UploadedAt:   time.Now(),
+			DeleteHash:   hashToken(deleteKey),
+			SHA256:       fingerprint.SHA256,
+			PHash:        fingerprint.PHash,
+			Scan:         scan,
+		}
+	}); err != nil {
+		logger.Error(fmt.Sprintf("storeImage: failed to save metadata for %s: %v", filePath, err))
+		deleteKey = ""
 	}
 
 	// Увеличиваем глобальный счетчик изображений
-	TotalImageCount++
+	TotalImageCount.Add(1)
 
 	return &ImageInfo{
-		Filename: filename,
-		Path:     filePath,
-		Size:     stat.Size(),
-		UserID:   userID,
-		AlbumID:  albumID,
+		Filename:  filename,
+		Path:      filePath,
+		Size:      stat.Size(),
+		UserID:    userID,
+		AlbumID:   albumID,
+		DeleteKey: deleteKey,
 	}, nil
 }
 
+// cleanOriginalName приводит присланное клиентом имя файла к виду, пригодному для метаданных:
+// остается только базовое имя без управляющих символов, не длиннее MaxAlbumNameLen.
+// Для имен без смысла (".", "..", пустых) возвращается пустая строка
+func cleanOriginalName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.ReplaceAll(name, "\\", "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSpace(name)
+	if name == "." || name == ".." {
+		return ""
+	}
+	if runes := []rune(name); len(runes) > MaxAlbumNameLen {
+		name = string(runes[:MaxAlbumNameLen])
+	}
+	return name
+}
+
+// checkImageFile проверяет размер и тип изображения и возвращает его расширение
+func checkImageFile(src io.ReadSeeker, size int64) (string, error) {
+	if size > MaxFileSize {
+		return "", fmt.Errorf("%w: %d bytes", ErrFileTooLarge, size)
+	}
+	extension, valid := validateImageType(src)
+	if !valid {
+		return "", ErrInvalidImageType
+	}
+	return extension, nil
+}
+
 // validateImageType проверяет тип изображения
-func validateImageType(file multipart.File) (string, bool) {
+func validateImageType(file io.ReadSeeker) (string, bool) {
 	// Чтение заголовка файла
 	buffer := make([]byte, 512)
-	if _, err := file.Read(buffer); err != nil {
+	n, err := file.Read(buffer)
+	if err != nil {
 		return "", false
 	}
 
 	// Восстановление указателя
-	file.Seek(0, 0)
+	file.Seek(0, io.SeekStart)
 
 	// Определение MIME типа
-	contentType := http.DetectContentType(buffer)
+	contentType := http.DetectContentType(buffer[:n])
 
 	// Проверка разрешенных типов
 	if !AllowedImageTypes[contentType] {
@@ -189,11 +309,10 @@ func getUserImages(userID, albumID string) ([]ImageInfo, error) {
 
 // getSessionID получает или генерирует ID сессии пользователя
 func getSessionID(w http.ResponseWriter, r *http.Request) string {
-	// Проверка наличия cookie
-	cookie, err := r.Cookie(SessionCookieName)
-	if err == nil && cookie.Value != "" {
-		logger.Debug(fmt.Sprintf("getSessionID: using existing cookie, sessionID=%s", cookie.Value))
-		return cookie.Value
+	// Проверка cookie устройства и сессии
+	if sessionID, _ := resolveSession(r); sessionID != "" {
+		logger.Debug(fmt.Sprintf("getSessionID: using existing cookie, sessionID=%s", sessionID))
+		return sessionID
 	}
 
 	// Генерация нового ID сессии
@@ -201,6 +320,32 @@ func getSessionID(w http.ResponseWriter, r *http.Request) string {
 	logger.Debug(fmt.Sprintf("getSessionID: creating new session, sessionID=%s", sessionID))
 
 	// Установка cookie
+	setNewSessionCookies(w, sessionID)
+
+	return sessionID
+}
+
+// createSession занимает свободный ID сессии, создавая ее директорию.
+// os.Mkdir атомарен, поэтому два запроса не получат один и тот же ID.
+func createSession() (string, error) {
+	if err := EnsureDir(DataPath); err != nil {
+		return "", err
+	}
+	for range SessionIDAttempts {
+		sessionID := RandomID()
+		err := os.Mkdir(userPath(sessionID), DefaultFilePerm)
+		if err == nil {
+			return sessionID, nil
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+	}
+	return "", ErrNoFreeSessionID
+}
+
+// setSessionCookie выдает клиенту cookie сессии
+func setSessionCookie(w http.ResponseWriter, sessionID string) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     SessionCookieName,
 		Value:    sessionID,
@@ -209,8 +354,27 @@ func getSessionID(w http.ResponseWriter, r *http.Request) string {
 		HttpOnly: true,
 		SameSite: http.SameSiteLaxMode,
 	})
+}
 
-	return sessionID
+// clearSessionCookie удаляет cookie сессии у клиента
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// canViewAlbum проверяет, может ли клиент просматривать альбом
+func canViewAlbum(r *http.Request, userID, albumID string) bool {
+	// Токену для приватных альбомов нужна область read-private
+	if caller, err := authenticate(r); err == nil && caller.SessionID == userID && caller.Can(ScopeReadPrivate) {
+		return true
+	}
+	return !isAlbumPrivate(userID, albumID)
 }
 
 // getUserAlbums возвращает список альбомов пользователя
@@ -238,26 +402,7 @@ func getUserAlbums(userID string) ([]AlbumInfo, error) {
 			continue
 		}
 
-		albumID := entry.Name()
-		albumDir := filepath.Join(userDir, albumID)
-
-		// Получение информации о директории
-		dirInfo, err := os.Stat(albumDir)
-		var createdAt time.Time
-		if err == nil {
-			createdAt = dirInfo.ModTime()
-		}
-
-		// Подсчет количества изображений
-		imageCount := countImagesInDir(albumDir)
-
-		// Добавление альбома в список
-		albums = append(albums, AlbumInfo{
-			ID:         albumID,
-			Name:       albumID,
-			ImageCount: imageCount,
-			CreatedAt:  createdAt,
-		})
+		albums = append(albums, getAlbumInfo(userID, entry.Name()))
 	}
 
 	// Сортировка альбомов по дате создания (новые сверху)
@@ -271,6 +416,36 @@ func getUserAlbums(userID string) ([]AlbumInfo, error) {
 	return albums, nil
 }
 
+// getAlbumInfo собирает информацию об альбоме из директории и метаданных
+func getAlbumInfo(userID, albumID string) AlbumInfo {
+	albumDir := albumPath(userID, albumID)
+
+	// Получение информации о директории
+	dirInfo, err := os.Stat(albumDir)
+	var createdAt time.Time
+	if err == nil {
+		createdAt = dirInfo.ModTime()
+	}
+
+	album := AlbumInfo{
+		ID:         albumID,
+		Name:       albumID,
+		ImageCount: countImagesInDir(albumDir),
+		CreatedAt:  createdAt,
+	}
+
+	// Название и приватность берутся из метаданных, если они есть
+	if meta, err := loadAlbumMeta(userID, albumID); err == nil {
+		if meta.Name != "" {
+			album.Name = meta.Name
+		}
+		album.Private = meta.Private
+		album.Pinned = meta.Pinned
+	}
+
+	return album
+}
+
 // countImagesInDir подсчитывает количество изображений в директории
 func countImagesInDir(dirPath string) int {
 	count := 0
@@ -287,6 +462,13 @@ func countImagesInDir(dirPath string) int {
 func createAlbum(userID string) (string, error) {
 	albumID := RandomID()
 
+	// Новый альбом должен поместиться в квоту владельца
+	release, err := reserveQuota(userID, StorageUsage{Albums: 1})
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
 	// Создание директории для альбома
 	albumDir := albumPath(userID, albumID)
 	logger.Debug(fmt.Sprintf("createAlbum: creating albumDir=%s", albumDir))
@@ -300,51 +482,70 @@ func createAlbum(userID string) (string, error) {
 
 // deleteImage удаляет изображение
 func deleteImage(userID, albumID, filename string) error {
-	filePath := imagePath(userID, albumID, filename)
+	filePath, err := resolveStoragePath(userID, albumID, filename)
+	if err != nil {
+		return err
+	}
 
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return fmt.Errorf("image not found")
+		return ErrImageNotFound
 	}
 
-	err := os.Remove(filePath)
+	err = os.Remove(filePath)
 	if err == nil {
 		// Уменьшаем глобальный счетчик изображений
-		TotalImageCount--
+		TotalImageCount.Add(-1)
+
+		if err := updateAlbumMeta(userID, albumID, func(meta *AlbumMeta) {
+			delete(meta.Images, filename)
+		}); err != nil {
+			logger.Error(fmt.Sprintf("deleteImage: failed to update metadata: %v", err))
+		}
 	}
 	return err
 }
 
 // deleteAlbum удаляет альбом со всеми изображениями
 func deleteAlbum(userID, albumID string) error {
-	albumDir := albumPath(userID, albumID)
+	// Пустой albumID превратил бы удаление альбома в удаление всей сессии
+	if albumID == "" {
+		return ErrInvalidPath
+	}
+	albumDir, err := resolveStoragePath(userID, albumID, "")
+	if err != nil {
+		return err
+	}
 
 	if _, err := os.Stat(albumDir); os.IsNotExist(err) {
-		return fmt.Errorf("album not found")
+		return ErrAlbumNotFound
 	}
 
 	// Подсчитываем количество изображений в альбоме перед удалением
 	imageCount := countImagesInDir(albumDir)
 
-	err := os.RemoveAll(albumDir)
+	err = os.RemoveAll(albumDir)
 	if err == nil {
 		// Уменьшаем глобальный счетчик изображений на количество удаленных изображений
-		TotalImageCount -= imageCount
+		TotalImageCount.Add(-int64(imageCount))
 	}
 	return err
 }
 
 // deleteUser удаляет все данные пользователя
 func deleteUser(userID string) error {
-	userDir := userPath(userID)
+	userDir, err := resolveStoragePath(userID, "", "")
+	if err != nil {
+		return err
+	}
 
 	if _, err := os.Stat(userDir); os.IsNotExist(err) {
-		return fmt.Errorf("user directory not found")
+		return ErrUserNotFound
 	}
 
 	// Подсчитываем количество изображений в пользовательской директории перед удалением
 	totalImages := 0
 	// Рекурсивный обход всей директории пользователя
-	err := filepath.Walk(userDir, func(path string, info os.FileInfo, err error) error {
+	err = filepath.Walk(userDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			// Пропускаем ошибки доступа к файлам
 			return nil
@@ -364,7 +565,19 @@ func deleteUser(userID string) error {
 	errRemove := os.RemoveAll(userDir)
 	if errRemove == nil && err == nil {
 		// Уменьшаем глобальный счетчик изображений на количество удаленных изображений
-		TotalImageCount -= totalImages
+		TotalImageCount.Add(-int64(totalImages))
+	}
+	if errRemove == nil {
+		// Токены и устройства удаленной сессии больше не должны работать
+		if err := tokens.RevokeSession(userID); err != nil {
+			logger.Error(fmt.Sprintf("deleteUser: failed to revoke tokens: %v", err))
+		}
+		if err := sessions.DeleteSession(userID); err != nil {
+			logger.Error(fmt.Sprintf("deleteUser: failed to remove devices: %v", err))
+		}
+		if err := accounts.DeleteSession(userID); err != nil {
+			logger.Error(fmt.Sprintf("deleteUser: failed to remove account: %v", err))
+		}
 	}
 	return errRemove
 }
@@ -380,8 +593,8 @@ func countAllFilesInDataPath() int {
 			return nil
 		}
 
-		// Пропускаем директории
-		if !info.IsDir() {
+		// Пропускаем директории и служебные файлы
+		if !info.IsDir() && IsImageFile(info.Name()) {
 			count++
 		}
 
@@ -1,3 +1,23 @@
+// Изменяющие запросы к своему серверу отправляются с CSRF токеном страницы
+(function () {
+  const meta = document.querySelector('meta[name="csrf-token"]');
+  if (!meta || !meta.content) {
+    return;
+  }
+  const nativeFetch = window.fetch.bind(window);
+  window.fetch = function (resource, options) {
+    options = options || {};
+    const method = (options.method || 'GET').toUpperCase();
+    const url = new URL(typeof resource === 'string' ? resource : resource.url, window.location.href);
+    if (method !== 'GET' && method !== 'HEAD' && url.origin === window.location.origin) {
+      const headers = new Headers(options.headers || {});
+      headers.set('X-CSRF-Token', meta.content);
+      options = Object.assign({}, options, { headers: headers });
+    }
+    return nativeFetch(resource, options);
+  };
+})();
+
 document.addEventListener('DOMContentLoaded', function () {
   const uploadArea = document.getElementById('uploadArea');
   const fileInput = document.getElementById('fileInput');
@@ -124,6 +144,7 @@ function uploadFilesParallel(files, albumID, sessionID) {
   const total = files.length;
   let completed = 0;
   const progress = showUploadProgress(total);
+  const rejected = [];
 
   // Создаем промисы для каждой операции конвертации и загрузки
   const uploadPromises = [];
@@ -155,6 +176,17 @@ function uploadFilesParallel(files, albumID, sessionID) {
           }
           completed++;
           progress.update(completed);
+          // Для архивов сервер возвращает результат по каждой записи
+          if (response.status === 200) {
+            return response.json().then(data => {
+              const entries = (data.data && data.data.entries) || [];
+              entries.forEach(entry => {
+                if (entry.status === 'rejected') {
+                  rejected.push((entry.name || entry.archive) + ': ' + entry.error);
+                }
+              });
+            });
+          }
           return response;
         });
       });
@@ -166,6 +198,9 @@ function uploadFilesParallel(files, albumID, sessionID) {
   Promise.all(uploadPromises)
     .then(() => {
       progress.hide();
+      if (rejected.length > 0) {
+        alert('Не удалось загрузить файлы из архива:\n' + rejected.join('\n'));
+      }
       // Перенаправляем в альбом
       window.location.href = '/' + sessionID + '/' + albumID;
     })
@@ -314,6 +349,255 @@ function deleteUser() {
     });
 }
 
+// escapeHTML экранирует пользовательский текст перед вставкой в разметку
+function escapeHTML(text) {
+  const div = document.createElement('div');
+  div.textContent = text;
+  return div.innerHTML;
+}
+
+// loadDevices загружает список устройств, привязанных к сессии
+function loadDevices() {
+  const list = document.getElementById('deviceList');
+  fetch('/api/v1/devices', { credentials: 'same-origin' })
+    .then(response => response.json())
+    .then(data => {
+      const devices = data.data || [];
+      list.innerHTML = devices.map(device => `
+        <div class="album-item settings-item">
+          <div>
+            <div style="font-weight:bold;color:#fff;">${escapeHTML(device.name)}${device.current ? ' (это устройство)' : ''}</div>
+            <div class="album-count">был ᴀᴋᴛиʙᴇн: ${new Date(device.last_seen_at).toLocaleString()}</div>
+          </div>
+          <button class="delete-btn" onclick="revokeDevice('${device.id}', ${device.current})">оᴛʙязᴀᴛь</button>
+        </div>
+      `).join('');
+    })
+    .catch(error => console.error('Error loading devices:', error));
+}
+
+// postCredential отправляет POST запрос к API и возвращает поле data ответа
+function postCredential(url, body) {
+  return fetch(url, {
+    method: 'POST',
+    credentials: 'same-origin',
+    headers: { 'Content-Type': 'application/json' },
+    body: body ? JSON.stringify(body) : undefined
+  })
+    .then(response => response.json())
+    .then(data => {
+      if (data.error) {
+        throw new Error(data.error.message);
+      }
+      return data.data;
+    });
+}
+
+// createRecoveryCode выпускает код восстановления сессии
+function createRecoveryCode() {
+  if (!confirm('Новый код заменит прежний. После этого войти в сессию можно будет только с привязанных устройств или по коду. Продолжить?')) {
+    return;
+  }
+  postCredential('/api/v1/recovery-code')
+    .then(data => {
+      document.getElementById('deviceSecret').textContent =
+        'Код восстановления (сохраните его, он больше не будет показан): ' + data.code;
+      loadDevices();
+    })
+    .catch(error => alert('Ошибка: ' + error.message));
+}
+
+// createPairingCode выпускает короткоживущий код для привязки второго устройства
+function createPairingCode() {
+  postCredential('/api/v1/pairing-codes')
+    .then(data => {
+      document.getElementById('deviceSecret').textContent =
+        'Введите код ' + data.code + ' на другом устройстве или откройте ' + data.url +
+        ' (действует до ' + new Date(data.expires_at).toLocaleTimeString() + ')';
+      loadDevices();
+    })
+    .catch(error => alert('Ошибка: ' + error.message));
+}
+
+// redeemCode привязывает этот браузер к сессии по коду
+function redeemCode(form) {
+  if (!confirm('Этот браузер переключится на другую сессию. Текущие альбомы останутся доступны только по ссылкам. Продолжить?')) {
+    return false;
+  }
+  postCredential('/api/v1/devices', { code: form.code.value })
+    .then(() => { window.location.href = '/'; })
+    .catch(error => alert('Ошибка: ' + error.message));
+  return false;
+}
+
+// revokeDevice отвязывает устройство от сессии
+function revokeDevice(id, current) {
+  const message = current
+    ? 'Отвязать это устройство? Вы потеряете доступ к сессии на нем.'
+    : 'Отвязать устройство?';
+  if (!confirm(message)) {
+    return;
+  }
+  fetch('/api/v1/devices/' + encodeURIComponent(id), { method: 'DELETE', credentials: 'same-origin' })
+    .then(response => {
+      if (!response.ok) {
+        throw new Error('HTTP ' + response.status);
+      }
+      if (current) {
+        window.location.href = '/';
+        return;
+      }
+      loadDevices();
+    })
+    .catch(error => alert('Ошибка при отвязке устройства: ' + error.message));
+}
+
+// Ссылка привязки вида /?link=CODE открывает блок устройств с заполненным кодом
+document.addEventListener('DOMContentLoaded', () => {
+  const code = new URLSearchParams(window.location.search).get('link');
+  const input = document.getElementById('redeemCodeInput');
+  if (code && input) {
+    input.value = code;
+    document.getElementById('devicesSection').open = true;
+  }
+});
+
+// submitAccount входит в аккаунт или регистрирует его; при регистрации
+// текущая сессия со всеми альбомами становится сессией аккаунта
+function submitAccount(form, event) {
+  const action = event.submitter && event.submitter.value === 'signup' ? 'signup' : 'login';
+  const body = { username: form.username.value, password: form.password.value };
+  let url = '/api/v1/account';
+  if (action === 'login') {
+    url += '/login';
+    body.merge = form.merge.checked;
+  }
+  postCredential(url, body)
+    .then(() => { window.location.href = '/'; })
+    .catch(error => alert('Ошибка: ' + error.message));
+  return false;
+}
+
+// mergeSession переносит в аккаунт альбомы другой сессии по ее коду привязки или восстановления
+function mergeSession(form) {
+  postCredential('/api/v1/account/sessions', { code: form.code.value.trim() })
+    .then(data => {
+      alert('Перенесено альбомов: ' + data.merged_albums);
+      window.location.href = '/';
+    })
+    .catch(error => alert('Ошибка: ' + error.message));
+  return false;
+}
+
+// redeemInvite активирует приглашение для текущей сессии
+function redeemInvite(form) {
+  postCredential('/api/v1/invite', { code: form.code.value })
+    .then(() => { window.location.href = '/'; })
+    .catch(error => alert('Ошибка: ' + error.message));
+  return false;
+}
+
+// openReport открывает форму жалобы на изображение
+function openReport(sessionID, albumID, filename) {
+  const form = document.getElementById('reportForm');
+  form.dataset.url = '/api/v1/sessions/' + encodeURIComponent(sessionID) + '/albums/' +
+    encodeURIComponent(albumID) + '/images/' + encodeURIComponent(filename) + '/reports';
+  document.getElementById('reportModal').classList.add('active');
+}
+
+// closeReport закрывает форму жалобы
+function closeReport() {
+  document.getElementById('reportModal').classList.remove('active');
+  document.getElementById('reportForm').reset();
+}
+
+// submitReport отправляет жалобу модераторам
+function submitReport(form) {
+  postCredential(form.dataset.url, { reason: form.reason.value, details: form.details.value })
+    .then(() => {
+      closeReport();
+      alert('Жалоба отправлена, спасибо');
+    })
+    .catch(error => alert('Ошибка: ' + error.message));
+  return false;
+}
+
+// logout отвязывает этот браузер от аккаунта
+function logout() {
+  fetch('/api/v1/account/logout', { method: 'POST', credentials: 'same-origin' })
+    .then(response => {
+      if (!response.ok) {
+        throw new Error('HTTP ' + response.status);
+      }
+      window.location.href = '/';
+    })
+    .catch(error => alert('Ошибка при выходе: ' + error.message));
+}
+
+// loadTokens загружает список API токенов
+function loadTokens() {
+  const list = document.getElementById('tokenList');
+  fetch('/api/v1/tokens', { credentials: 'same-origin' })
+    .then(response => response.json())
+    .then(data => {
+      const tokens = data.data || [];
+      list.innerHTML = tokens.map(token => `
+        <div class="album-item settings-item">
+          <div>
+            <div style="font-weight:bold;color:#fff;">${escapeHTML(token.name)}</div>
+            <div class="album-count">${token.scopes.join(', ')}${token.expires_at ? ' · до ' + new Date(token.expires_at).toLocaleDateString() : ''}</div>
+          </div>
+          <button class="delete-btn" onclick="revokeToken('${token.id}')">оᴛозʙᴀᴛь</button>
+        </div>
+      `).join('');
+    })
+    .catch(error => console.error('Error loading tokens:', error));
+}
+
+// createToken выпускает новый токен и один раз показывает его секрет
+function createToken(form) {
+  const scopes = Array.from(form.querySelectorAll('input[name="scope"]:checked')).map(input => input.value);
+  const body = { name: form.name.value, scopes: scopes };
+  const days = parseInt(form.expires.value, 10);
+  if (days > 0) {
+    body.expires_at = new Date(Date.now() + days * 86400000).toISOString();
+  }
+
+  fetch('/api/v1/tokens', {
+    method: 'POST',
+    credentials: 'same-origin',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(body)
+  })
+    .then(response => response.json())
+    .then(data => {
+      if (data.error) {
+        throw new Error(data.error.message);
+      }
+      document.getElementById('tokenSecret').textContent =
+        'Сохраните токен, он больше не будет показан: ' + data.data.token;
+      form.reset();
+      loadTokens();
+    })
+    .catch(error => alert('Ошибка при создании токена: ' + error.message));
+  return false;
+}
+
+// revokeToken отзывает API токен
+function revokeToken(id) {
+  if (!confirm('Отозвать токен? Программы, использующие его, потеряют доступ.')) {
+    return;
+  }
+  fetch('/api/v1/tokens/' + encodeURIComponent(id), { method: 'DELETE', credentials: 'same-origin' })
+    .then(response => {
+      if (!response.ok) {
+        throw new Error('HTTP ' + response.status);
+      }
+      loadTokens();
+    })
+    .catch(error => alert('Ошибка при отзыве токена: ' + error.message));
+}
+
 // Открывает изображение в оверлее
 function toggleZoom(img) {
   const overlay = document.getElementById('image-viewer-overlay');
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Области действия API токенов
+const (
+	ScopeUpload      = "upload"       // загрузка, создание и изменение альбомов
+	ScopeDelete      = "delete"       // удаление изображений, альбомов и сессии
+	ScopeReadPrivate = "read-private" // просмотр приватных альбомов
+)
+
+// AllScopes - области, которые получает токен без явного списка
+var AllScopes = []string{ScopeUpload, ScopeDelete, ScopeReadPrivate}
+
+// Ошибки аутентификации
+var (
+	ErrNoCredentials = errors.New("no credentials")
+	ErrInvalidToken  = errors.New("invalid or revoked token")
+	ErrTokenExpired  = errors.New("token expired")
+	ErrScopeDenied   = errors.New("token scope does not allow this action")
+)
+
+// APIToken - именованный токен, привязанный к сессии. Секрет хранится только как SHA-256.
+type APIToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	SessionID  string     `json:"session_id"`
+	Hash       string     `json:"hash"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Owner      bool       `json:"owner,omitempty"` // выдан вместе с сессией через API и заменяет скрипту cookie владельца
+}
+
+// Expired сообщает, истек ли срок действия токена
+func (t *APIToken) Expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+// HasScope проверяет область действия токена
+func (t *APIToken) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+// tokenStore хранит токены в StatePath/TokensStateFile
+type tokenStore struct {
+	mu     sync.Mutex
+	loaded bool
+	tokens []*APIToken
+}
+
+var tokens = &tokenStore{}
+
+// load лениво читает токены с диска (вызывается под мьютексом)
+func (s *tokenStore) load() error {
+	if s.loaded {
+		return nil
+	}
+	if err := loadState(TokensStateFile, &s.tokens); err != nil {
+		return err
+	}
+	s.loaded = true
+	return nil
+}
+
+// save записывает токены на диск, попутно выбрасывая истекшие (вызывается под мьютексом)
+func (s *tokenStore) save() error {
+	s.tokens = slices.DeleteFunc(s.tokens, func(t *APIToken) bool { return t.Expired() })
+	return saveState(TokensStateFile, s.tokens)
+}
+
+// Create выпускает новый токен и возвращает его вместе с открытым секретом
+func (s *tokenStore) Create(sessionID, name string, scopes []string, expiresAt *time.Time) (*APIToken, string, error) {
+	return s.issue(&APIToken{Name: name, SessionID: sessionID, Scopes: scopes, ExpiresAt: expiresAt})
+}
+
+// CreateOwner выпускает бессрочный токен владельца со всеми областями для сессии, созданной через API
+func (s *tokenStore) CreateOwner(sessionID string) (*APIToken, string, error) {
+	return s.issue(&APIToken{Name: "api", SessionID: sessionID, Scopes: slices.Clone(AllScopes), Owner: true})
+}
+
+// issue дописывает токену ID, хеш и время выпуска и сохраняет его
+func (s *tokenStore) issue(token *APIToken) (*APIToken, string, error) {
+	id, err := RandomToken(8)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := RandomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	secret = TokenPrefix + secret
+
+	token.ID = id
+	token.Hash = hashToken(secret)
+	token.CreatedAt = time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, "", err
+	}
+	s.tokens = append(s.tokens, token)
+	if err := s.save(); err != nil {
+		return nil, "", err
+	}
+	return token, secret, nil
+}
+
+// Authenticate находит токен по секрету
+func (s *tokenStore) Authenticate(secret string) (*APIToken, error) {
+	hash := hashToken(secret)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	for _, token := range s.tokens {
+		if token.Hash != hash {
+			continue
+		}
+		if token.Expired() {
+			return nil, ErrTokenExpired
+		}
+
+		// Время последнего использования сохраняем не чаще раза в минуту
+		now := time.Now()
+		if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > time.Minute {
+			token.LastUsedAt = &now
+			if err := s.save(); err != nil {
+				logger.Error("Failed to save token usage: " + err.Error())
+			}
+		}
+
+		found := *token
+		return &found, nil
+	}
+	return nil, ErrInvalidToken
+}
+
+// List возвращает токены сессии
+func (s *tokenStore) List(sessionID string) ([]APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	list := []APIToken{}
+	for _, token := range s.tokens {
+		if token.SessionID == sessionID && !token.Expired() {
+			list = append(list, *token)
+		}
+	}
+	return list, nil
+}
+
+// Revoke отзывает токен сессии по ID
+func (s *tokenStore) Revoke(sessionID, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return false, err
+	}
+
+	before := len(s.tokens)
+	s.tokens = slices.DeleteFunc(s.tokens, func(t *APIToken) bool {
+		return t.SessionID == sessionID && t.ID == id
+	})
+	if len(s.tokens) == before {
+		return false, nil
+	}
+	return true, s.save()
+}
+
+// RevokeSession отзывает все токены сессии (при удалении профиля)
+func (s *tokenStore) RevokeSession(sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	s.tokens = slices.DeleteFunc(s.tokens, func(t *APIToken) bool { return t.SessionID == sessionID })
+	return s.save()
+}
+
+// MoveSession переносит токены сессии from в сессию into при объединении сессий
+func (s *tokenStore) MoveSession(from, into string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	for _, token := range s.tokens {
+		if token.SessionID == from {
+			token.SessionID = into
+		}
+	}
+	return s.save()
+}
+
+// hashToken возвращает SHA-256 секрета токена в hex
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Caller описывает, от чьего имени выполняется запрос
+type Caller struct {
+	SessionID string
+	DeviceID  string    // привязанное устройство, если клиент вошел с него
+	Token     *APIToken // nil, если клиент вошел через cookie
+}
+
+// IsOwner сообщает, что клиент действует как владелец сессии: через cookie или токен владельца
+func (c *Caller) IsOwner() bool {
+	return c.Token == nil || c.Token.Owner
+}
+
+// Can проверяет, разрешено ли клиенту действие с областью scope.
+// Владелец сессии может все, токен - только то, что указано при его выпуске.
+func (c *Caller) Can(scope string) bool {
+	return c.Token == nil || scope == "" || c.Token.HasScope(scope)
+}
+
+// authenticate определяет клиента без создания новой сессии:
+// сначала Authorization: Bearer, затем заголовок прокси, cookie устройства и cookie сессии
+func authenticate(r *http.Request) (*Caller, error) {
+	if r.Header.Get("Authorization") != "" {
+		secret, ok := bearerToken(r)
+		if !ok {
+			return nil, ErrInvalidToken
+		}
+		token, err := tokens.Authenticate(secret)
+		if err != nil {
+			return nil, err
+		}
+		return &Caller{SessionID: token.SessionID, Token: token}, nil
+	}
+
+	if sessionID, deviceID := resolveSession(r); sessionID != "" {
+		return &Caller{SessionID: sessionID, DeviceID: deviceID}, nil
+	}
+	return nil, ErrNoCredentials
+}
+
+// bearerToken возвращает секрет из заголовка Authorization со схемой Bearer.
+// Имя схемы, как и положено по RFC 9110, сравнивается без учета регистра
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, secret, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	secret = strings.TrimSpace(secret)
+	return secret, secret != ""
+}
+
+// requireCaller аутентифицирует клиента изменяющего запроса и проверяет область действия.
+// В отличие от getSessionID, новая сессия не создается.
+func requireCaller(w http.ResponseWriter, r *http.Request, scope string) (*Caller, bool) {
+	caller, err := authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+	if !caller.Can(scope) {
+		http.Error(w, ErrScopeDenied.Error(), http.StatusForbidden)
+		return nil, false
+	}
+	if scope == ScopeUpload {
+		if err := checkUploadPolicy(caller); err != nil {
+			http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+			return nil, false
+		}
+	}
+	return caller, true
+}
+
+// parseScopes проверяет список областей; пустой список означает все области
+func parseScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return slices.Clone(AllScopes), nil
+	}
+
+	var result []string
+	for _, scope := range scopes {
+		if !slices.Contains(AllScopes, scope) {
+			return nil, fmt.Errorf("unknown scope %q", scope)
+		}
+		if !slices.Contains(result, scope) {
+			result = append(result, scope)
+		}
+	}
+	return result, nil
+}
+
+// TokenResource - представление токена в API (без хеша)
+type TokenResource struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Owner      bool       `json:"owner,omitempty"`
+	Token      string     `json:"token,omitempty"` // только в ответе на создание
+}
+
+func tokenResource(token APIToken) TokenResource {
+	return TokenResource{
+		ID:         token.ID,
+		Name:       token.Name,
+		Scopes:     token.Scopes,
+		CreatedAt:  token.CreatedAt,
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+		Owner:      token.Owner,
+	}
+}
+
+// apiSessionOwner проверяет, что учетными данными (токенами, устройствами, кодами)
+// управляет сам владелец сессии, а не выпущенный им API токен
+func apiSessionOwner(w http.ResponseWriter, r *http.Request) (*Caller, bool) {
+	caller, ok := apiRequireCaller(w, r, "")
+	if !ok {
+		return nil, false
+	}
+	if !caller.IsOwner() {
+		ErrorResponse(w, http.StatusForbidden, ErrCodeForbidden, "API tokens cannot manage session credentials")
+		return nil, false
+	}
+	return caller, true
+}
+
+// apiListTokens возвращает токены сессии
+func apiListTokens(w http.ResponseWriter, r *http.Request) {
+	caller, ok := apiSessionOwner(w, r)
+	if !ok {
+		return
+	}
+	sessionID := caller.SessionID
+
+	list, err := tokens.List(sessionID)
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	resources := make([]TokenResource, 0, len(list))
+	for _, token := range list {
+		resources = append(resources, tokenResource(token))
+	}
+	apiData(w, http.StatusOK, resources)
+}
+
+// tokenCreateRequest - параметры нового токена
+type tokenCreateRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// apiCreateToken выпускает токен; секрет возвращается только в этом ответе
+func apiCreateToken(w http.ResponseWriter, r *http.Request) {
+	caller, ok := apiSessionOwner(w, r)
+	if !ok {
+		return
+	}
+	sessionID := caller.SessionID
+
+	var req tokenCreateRequest
+	if !apiDecodeJSON(w, r, &req) {
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" || utf8.RuneCountInString(name) > MaxAlbumNameLen {
+		ErrorResponse(w, http.StatusBadRequest, ErrCodeBadRequest,
+			fmt.Sprintf("name must be 1 to %d characters", MaxAlbumNameLen))
+		return
+	}
+	scopes, err := parseScopes(req.Scopes)
+	if err != nil {
+		ErrorResponse(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		return
+	}
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		ErrorResponse(w, http.StatusBadRequest, ErrCodeBadRequest, "expires_at must be in the future")
+		return
+	}
+
+	token, secret, err := tokens.Create(sessionID, name, scopes, req.ExpiresAt)
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	resource := tokenResource(*token)
+	resource.Token = secret
+	apiData(w, http.StatusCreated, resource)
+}
+
+// apiRevokeToken отзывает токен
+func apiRevokeToken(w http.ResponseWriter, r *http.Request) {
+	caller, ok := apiSessionOwner(w, r)
+	if !ok {
+		return
+	}
+	sessionID := caller.SessionID
+
+	revoked, err := tokens.Revoke(sessionID, r.PathValue("id"))
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+	if !revoked {
+		ErrorResponse(w, http.StatusNotFound, ErrCodeTokenNotFound, "token not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ripx/client"
+)
+
+// getWithToken выполняет GET к testServer с заголовком Authorization и возвращает код ответа
+func getWithToken(t *testing.T, path, authorization string) int {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, testServer.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestTokenScopes(t *testing.T) {
+	ctx := context.Background()
+	owner := newTestClient(t)
+	album, err := owner.CreateAlbum(ctx, client.AlbumUpdate{Private: client.Bool(true)})
+	if err != nil {
+		t.Fatalf("CreateAlbum: %v", err)
+	}
+	content := testPNG(t, 40)
+	img, err := owner.Upload(ctx, album.ID, "private.png", bytes.NewReader(content), int64(len(content)), nil)
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+
+	scoped := func(scope string) *client.Client {
+		token, err := owner.CreateToken(ctx, client.TokenCreate{Name: scope, Scopes: []string{scope}})
+		if err != nil {
+			t.Fatalf("CreateToken(%s): %v", scope, err)
+		}
+		return client.New(testServer.URL, client.WithToken(token.Secret))
+	}
+	uploader, deleter, reader := scoped(client.ScopeUpload), scoped(client.ScopeDelete), scoped(client.ScopeReadPrivate)
+
+	// Приватный альбом виден только токену с областью read-private
+	for name, c := range map[string]*client.Client{"upload": uploader, "delete": deleter} {
+		if _, err := c.GetAlbum(ctx, album.ID); !errors.Is(err, client.ErrAlbumNotFound) && !errors.Is(err, client.ErrNotFound) {
+			t.Errorf("GetAlbum with %s: %v, want not found", name, err)
+		}
+	}
+	if _, err := reader.GetAlbum(ctx, album.ID); err != nil {
+		t.Errorf("GetAlbum with read-private: %v", err)
+	}
+	if status := getWithToken(t, "/api/v1/sessions/"+owner.SessionID()+"/albums/"+album.ID, ""); status != http.StatusNotFound {
+		t.Errorf("anonymous GetAlbum: %d, want 404", status)
+	}
+	zipPath := "/" + owner.SessionID() + "/" + album.ID + ".zip"
+	if status := getWithToken(t, zipPath, ""); status != http.StatusNotFound {
+		t.Errorf("anonymous album zip: %d, want 404", status)
+	}
+	if status := getWithToken(t, zipPath, "Bearer "+reader.Token()); status != http.StatusOK {
+		t.Errorf("read-private album zip: %d, want 200", status)
+	}
+
+	// Загрузка только с областью upload, удаление только с delete
+	for name, c := range map[string]*client.Client{"delete": deleter, "read-private": reader} {
+		if _, err := c.Upload(ctx, album.ID, "x.png", bytes.NewReader(content), int64(len(content)), nil); !errors.Is(err, client.ErrInsufficientScope) {
+			t.Errorf("Upload with %s: %v, want ErrInsufficientScope", name, err)
+		}
+	}
+	if _, err := uploader.Upload(ctx, album.ID, "x.png", bytes.NewReader(content), int64(len(content)), nil); err != nil {
+		t.Errorf("Upload with upload: %v", err)
+	}
+	for name, c := range map[string]*client.Client{"upload": uploader, "read-private": reader} {
+		if err := c.DeleteImage(ctx, album.ID, img.Filename); !errors.Is(err, client.ErrInsufficientScope) {
+			t.Errorf("DeleteImage with %s: %v, want ErrInsufficientScope", name, err)
+		}
+	}
+	if err := deleter.DeleteImage(ctx, album.ID, img.Filename); err != nil {
+		t.Errorf("DeleteImage with delete: %v", err)
+	}
+
+	// Токены с областями не управляют токенами сессии
+	if _, err := deleter.CreateToken(ctx, client.TokenCreate{Name: "escalate"}); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("CreateToken with a scoped token: %v, want ErrForbidden", err)
+	}
+}
+
+func TestTokenRevocation(t *testing.T) {
+	ctx := context.Background()
+	owner := newTestClient(t)
+	token, err := owner.CreateToken(ctx, client.TokenCreate{Name: "ci", Scopes: []string{client.ScopeUpload}})
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+	bot := client.New(testServer.URL, client.WithToken(token.Secret))
+	if _, err := bot.CurrentSession(ctx); err != nil {
+		t.Fatalf("CurrentSession before revocation: %v", err)
+	}
+
+	if err := owner.RevokeToken(ctx, token.ID); err != nil {
+		t.Fatalf("RevokeToken: %v", err)
+	}
+	if _, err := bot.CurrentSession(ctx); !errors.Is(err, client.ErrInvalidToken) {
+		t.Errorf("CurrentSession after revocation: %v, want ErrInvalidToken", err)
+	}
+	if err := owner.RevokeToken(ctx, token.ID); !errors.Is(err, client.ErrTokenNotFound) {
+		t.Errorf("second RevokeToken: %v, want ErrTokenNotFound", err)
+	}
+	list, err := owner.ListTokens(ctx)
+	if err != nil {
+		t.Fatalf("ListTokens: %v", err)
+	}
+	for _, listed := range list {
+		if listed.ID == token.ID {
+			t.Errorf("revoked token is still listed")
+		}
+	}
+}
+
+func TestTokensHashedAtRest(t *testing.T) {
+	sessionID := RandomID()
+	token, secret, err := tokens.Create(sessionID, "ci", []string{ScopeUpload}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// На диске только хеш секрета
+	data, err := os.ReadFile(filepath.Join(StatePath, TokensStateFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(secret)) {
+		t.Error("tokens file contains the plain secret")
+	}
+	if token.Hash != hashToken(secret) || !bytes.Contains(data, []byte(token.Hash)) {
+		t.Error("tokens file does not contain the secret hash")
+	}
+
+	if found, err := tokens.Authenticate(secret); err != nil || found.ID != token.ID {
+		t.Errorf("Authenticate: %+v, %v", found, err)
+	}
+	if _, err := tokens.Authenticate(token.Hash); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Authenticate with the stored hash: %v, want ErrInvalidToken", err)
+	}
+
+	expiresAt := time.Now().Add(50 * time.Millisecond)
+	_, expired, err := tokens.Create(sessionID, "short", []string{ScopeUpload}, &expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := tokens.Authenticate(expired); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Authenticate with an expired token: %v, want ErrTokenExpired", err)
+	}
+}
+
+func TestBearerScheme(t *testing.T) {
+	c := newTestClient(t)
+	tests := []struct {
+		authorization string
+		want          int
+	}{
+		{"Bearer " + c.Token(), http.StatusOK},
+		{"bearer " + c.Token(), http.StatusOK},
+		{"BEARER  " + c.Token(), http.StatusOK},
+		{"Bearer", http.StatusUnauthorized},
+		{"Bearer ", http.StatusUnauthorized},
+		{"Bearer" + c.Token(), http.StatusUnauthorized},
+		{"Basic " + c.Token(), http.StatusUnauthorized},
+		{"Bearer ripx_unknown", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if status := getWithToken(t, "/api/v1/session", tt.authorization); status != tt.want {
+			t.Errorf("Authorization %q: %d, want %d", tt.authorization, status, tt.want)
+		}
+	}
+}
@@ -73,11 +73,14 @@ func (l *Logger) Error(msg string) {
 // Global logger instance
 var logger = NewLogger(os.Getenv("DEBUG") == "true")
 
-// ErrorResponse отправляет JSON ответ с ошибкой
-func ErrorResponse(w http.ResponseWriter, statusCode int, message string) {
+// ErrorResponse отправляет JSON ответ с ошибкой в едином формате:
+// {"error": {"code": "album_not_found", "message": "..."}}
+func ErrorResponse(w http.ResponseWriter, statusCode int, code, message string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
-	fmt.Fprintf(w, `{"error": "%s"}`, strings.ReplaceAll(message, `"`, `\\"`))
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"code": code, "message": message},
+	})
 }
 
 // SuccessResponse отправляет JSON ответ с успешным результатом
@@ -88,11 +91,63 @@ func SuccessResponse(w http.ResponseWriter, data interface{}) {
 	fmt.Fprintf(w, `{"success": true, "data": %s}`, string(jsonData))
 }
 
-// ValidatePath проверяет безопасность пути
-func ValidatePath(path string) bool {
-	// Проверяем на попытки выйти за пределы директории
-	cleanPath := filepath.Clean(path)
-	return !strings.Contains(cleanPath, "..") && !strings.HasPrefix(cleanPath, "/")
+// ValidatePath проверяет, что path после очистки лежит внутри base (или совпадает с ней)
+func ValidatePath(base, path string) bool {
+	rel, err := filepath.Rel(filepath.Clean(base), filepath.Clean(path))
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// IsValidID проверяет, что строка может быть ID сессии, альбома или токена
+func IsValidID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		isAlnum := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+		if !isAlnum && c != '-' && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// BaseURL возвращает схему и хост, по которым клиент обратился к серверу
+func BaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
+
+// envOr возвращает значение переменной окружения или значение по умолчанию
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// splitList разбирает список через запятую, пропуская пустые элементы
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// isHiddenName проверяет, является ли имя служебным (начинается с точки)
+func isHiddenName(name string) bool {
+	return strings.HasPrefix(name, ".")
 }
 
 // EnsureDir создает директорию если она не существует
@@ -120,6 +175,21 @@ func IsImageFile(filename string) bool {
 	return ValidImageExtensions[ext]
 }
 
+// IsValidImageFilename проверяет имя сохраненного изображения (ID + расширение)
+func IsValidImageFilename(filename string) bool {
+	ext := filepath.Ext(filename)
+	return IsImageFile(filename) && IsValidID(strings.TrimSuffix(filename, ext))
+}
+
+// RandomToken генерирует криптостойкую случайную строку из n байт в hex
+func RandomToken(n int) (string, error) {
+	bytes := make([]byte, n)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
 // RandomID генерирует случайный ID
 func RandomID() string {
 	bytes := make([]byte, 3)
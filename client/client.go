@@ -0,0 +1,194 @@
+// Package client - клиент JSON API ripx (/api/v1) для Go программ.
+//
+//	c := client.New("https://img.example.com", client.WithToken("ripx_..."))
+//	album, err := c.CreateAlbum(ctx, client.AlbumUpdate{Name: client.String("screenshots")})
+//	img, err := c.UploadFile(ctx, album.ID, "shot.png", nil)
+//
+// Ошибки сервера возвращаются как *Error и сравниваются с ErrAlbumNotFound и
+// другими значениями пакета через errors.Is.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// APIPrefix - префикс версии API на сервере
+const APIPrefix = "/api/v1"
+
+// Client выполняет запросы к одному серверу ripx. Безопасен для параллельного использования.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	token     string
+	sessionID string
+}
+
+// Option настраивает клиент
+type Option func(*Client)
+
+// WithToken задает API токен, передаваемый в заголовке Authorization: Bearer
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithHTTPClient заменяет http.DefaultClient
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// New создает клиент для сервера baseURL, например https://img.example.com
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// SessionID возвращает ID сессии, если он уже известен клиенту
+func (c *Client) SessionID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sessionID
+}
+
+// Token возвращает API токен клиента: заданный WithToken или выданный CreateSession
+func (c *Client) Token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+// session возвращает ID сессии, при необходимости узнавая его у сервера по токену
+func (c *Client) session(ctx context.Context) (string, error) {
+	if id := c.SessionID(); id != "" {
+		return id, nil
+	}
+	if c.Token() == "" {
+		return "", fmt.Errorf("ripx client: no session, call CreateSession or use WithToken")
+	}
+
+	session, err := c.CurrentSession(ctx)
+	if err != nil {
+		return "", err
+	}
+	return session.ID, nil
+}
+
+// setSession запоминает ID сессии
+func (c *Client) setSession(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sessionID = id
+}
+
+// sessionPath строит путь внутри сессии клиента: /sessions/{session}/...
+func (c *Client) sessionPath(ctx context.Context, elems ...string) (string, error) {
+	sessionID, err := c.session(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	path := "/sessions/" + url.PathEscape(sessionID)
+	for _, elem := range elems {
+		path += "/" + url.PathEscape(elem)
+	}
+	return path, nil
+}
+
+// request описывает один вызов API
+type request struct {
+	method      string
+	path        string // относительно APIPrefix
+	query       url.Values
+	body        io.Reader
+	length      int64 // длина потокового тела, если известна
+	contentType string
+}
+
+// jsonRequest готовит запрос с JSON телом
+func jsonRequest(method, path string, v interface{}) (request, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return request{}, err
+	}
+	return request{method: method, path: path, body: bytes.NewReader(data), contentType: "application/json"}, nil
+}
+
+// envelope - ответ API: {"data": ..., "pagination": ...}
+type envelope struct {
+	Data       json.RawMessage `json:"data"`
+	Pagination *Pagination     `json:"pagination"`
+}
+
+// do выполняет запрос и разбирает поле data ответа в out.
+// Для списков возвращается также пагинация.
+func (c *Client) do(ctx context.Context, req request, out interface{}) (*Pagination, error) {
+	u := c.baseURL + APIPrefix + req.path
+	if len(req.query) > 0 {
+		u += "?" + req.query.Encode()
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, u, req.body)
+	if err != nil {
+		return nil, err
+	}
+	if req.length > 0 {
+		httpReq.ContentLength = req.length
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	if req.contentType != "" {
+		httpReq.Header.Set("Content-Type", req.contentType)
+	}
+	if token := c.Token(); token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, parseError(resp)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+
+	var env envelope
+	if err := json.NewDecoder(resp.Body).Decode(&env); err != nil {
+		return nil, fmt.Errorf("ripx client: decoding response: %w", err)
+	}
+	if err := json.Unmarshal(env.Data, out); err != nil {
+		return nil, fmt.Errorf("ripx client: decoding data: %w", err)
+	}
+	return env.Pagination, nil
+}
+
+// pageQuery формирует параметры page и per_page; нулевые значения оставляют умолчания сервера
+func pageQuery(page, perPage int) url.Values {
+	query := url.Values{}
+	if page > 0 {
+		query.Set("page", strconv.Itoa(page))
+	}
+	if perPage > 0 {
+		query.Set("per_page", strconv.Itoa(perPage))
+	}
+	return query
+}
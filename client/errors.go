@@ -0,0 +1,87 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Коды ошибок сервера
+const (
+	CodeBadRequest        = "bad_request"
+	CodeUnauthorized      = "unauthorized"
+	CodeForbidden         = "forbidden"
+	CodeNotFound          = "not_found"
+	CodeSessionNotFound   = "session_not_found"
+	CodeAlbumNotFound     = "album_not_found"
+	CodeImageNotFound     = "image_not_found"
+	CodeFileTooLarge      = "file_too_large"
+	CodeInvalidImageType  = "invalid_image_type"
+	CodeInternal          = "internal_error"
+	CodeInvalidToken      = "invalid_token"
+	CodeTokenExpired      = "token_expired"
+	CodeInsufficientScope = "insufficient_scope"
+	CodeTokenNotFound     = "token_not_found"
+)
+
+// Error - ошибка, которую вернул сервер
+type Error struct {
+	StatusCode int    // HTTP статус ответа
+	Code       string // код из конверта ошибки; пустой, если ответ был не JSON
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("ripx: HTTP %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("ripx: %s: %s", e.Code, e.Message)
+}
+
+// Is сравнивает ошибки по коду, чтобы работало errors.Is(err, client.ErrAlbumNotFound)
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code != "" && t.Code == e.Code
+}
+
+// Значения для сравнения через errors.Is
+var (
+	ErrBadRequest        = &Error{Code: CodeBadRequest}
+	ErrUnauthorized      = &Error{Code: CodeUnauthorized}
+	ErrForbidden         = &Error{Code: CodeForbidden}
+	ErrNotFound          = &Error{Code: CodeNotFound}
+	ErrSessionNotFound   = &Error{Code: CodeSessionNotFound}
+	ErrAlbumNotFound     = &Error{Code: CodeAlbumNotFound}
+	ErrImageNotFound     = &Error{Code: CodeImageNotFound}
+	ErrFileTooLarge      = &Error{Code: CodeFileTooLarge}
+	ErrInvalidImageType  = &Error{Code: CodeInvalidImageType}
+	ErrInternal          = &Error{Code: CodeInternal}
+	ErrInvalidToken      = &Error{Code: CodeInvalidToken}
+	ErrTokenExpired      = &Error{Code: CodeTokenExpired}
+	ErrInsufficientScope = &Error{Code: CodeInsufficientScope}
+	ErrTokenNotFound     = &Error{Code: CodeTokenNotFound}
+)
+
+// parseError читает конверт {"error": {...}}; ответы не от API (например, от прокси)
+// превращаются в Error без кода
+func parseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+	var env struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &env); err == nil && env.Error.Code != "" {
+		return &Error{StatusCode: resp.StatusCode, Code: env.Error.Code, Message: env.Error.Message}
+	}
+
+	message := strings.TrimSpace(string(body))
+	if message == "" {
+		message = http.StatusText(resp.StatusCode)
+	}
+	return &Error{StatusCode: resp.StatusCode, Message: message}
+}
@@ -0,0 +1,285 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Session - сессия (профиль) на сервере. Token заполнен только в ответе CreateSession.
+type Session struct {
+	ID         string `json:"id"`
+	AlbumCount int    `json:"album_count"`
+	ImageCount int    `json:"image_count"`
+	Token      string `json:"token,omitempty"`
+}
+
+// Album - альбом сессии
+type Album struct {
+	ID         string    `json:"id"`
+	SessionID  string    `json:"session_id"`
+	Name       string    `json:"name"`
+	Private    bool      `json:"private"`
+	ImageCount int       `json:"image_count"`
+	CreatedAt  time.Time `json:"created_at"`
+	URL        string    `json:"url"`
+	ZipURL     string    `json:"zip_url"`
+}
+
+// AlbumUpdate - изменяемые поля альбома; nil поля не меняются
+type AlbumUpdate struct {
+	Name    *string `json:"name,omitempty"`
+	Private *bool   `json:"private,omitempty"`
+}
+
+// Image - изображение в альбоме
+type Image struct {
+	Filename     string    `json:"filename"`
+	AlbumID      string    `json:"album_id"`
+	SessionID    string    `json:"session_id"`
+	OriginalName string    `json:"original_name,omitempty"`
+	Size         int64     `json:"size"`
+	UploadedAt   time.Time `json:"uploaded_at"`
+	URL          string    `json:"url"`
+	DeletionURL  string    `json:"deletion_url,omitempty"` // только у результата Upload
+}
+
+// Token - API токен. Secret заполнен только в ответе CreateToken.
+type Token struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Owner      bool       `json:"owner,omitempty"` // токен владельца из CreateSession
+	Secret     string     `json:"token,omitempty"`
+}
+
+// TokenCreate - параметры нового токена; пустой Scopes означает все области
+type TokenCreate struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Области действия токенов
+const (
+	ScopeUpload      = "upload"
+	ScopeDelete      = "delete"
+	ScopeReadPrivate = "read-private"
+)
+
+// Pagination описывает страницу списка
+type Pagination struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	Total      int `json:"total"`
+	TotalPages int `json:"total_pages"`
+}
+
+// String возвращает указатель на s, для полей AlbumUpdate
+func String(s string) *string { return &s }
+
+// Bool возвращает указатель на b, для полей AlbumUpdate
+func Bool(b bool) *bool { return &b }
+
+// Сессии
+
+// CreateSession создает новую сессию и дальше работает от ее имени с выданным токеном.
+// Токен показывается один раз: сохраните session.Token, чтобы вернуться в сессию позже.
+func (c *Client) CreateSession(ctx context.Context) (*Session, error) {
+	var session Session
+	if _, err := c.do(ctx, request{method: http.MethodPost, path: "/sessions"}, &session); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.sessionID, c.token = session.ID, session.Token
+	c.mu.Unlock()
+	return &session, nil
+}
+
+// CurrentSession возвращает сессию, которой принадлежит токен клиента
+func (c *Client) CurrentSession(ctx context.Context) (*Session, error) {
+	var session Session
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: "/session"}, &session); err != nil {
+		return nil, err
+	}
+	c.setSession(session.ID)
+	return &session, nil
+}
+
+// DeleteSession удаляет сессию со всеми альбомами
+func (c *Client) DeleteSession(ctx context.Context) error {
+	path, err := c.sessionPath(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(ctx, request{method: http.MethodDelete, path: path}, nil)
+	return err
+}
+
+// Альбомы
+
+// ListAlbums возвращает страницу альбомов; page и perPage = 0 означают значения по умолчанию
+func (c *Client) ListAlbums(ctx context.Context, page, perPage int) ([]Album, *Pagination, error) {
+	path, err := c.sessionPath(ctx, "albums")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var albums []Album
+	pagination, err := c.do(ctx, request{method: http.MethodGet, path: path, query: pageQuery(page, perPage)}, &albums)
+	if err != nil {
+		return nil, nil, err
+	}
+	return albums, pagination, nil
+}
+
+// CreateAlbum создает альбом, при необходимости сразу с названием и приватностью
+func (c *Client) CreateAlbum(ctx context.Context, params AlbumUpdate) (*Album, error) {
+	path, err := c.sessionPath(ctx, "albums")
+	if err != nil {
+		return nil, err
+	}
+	return c.albumRequest(ctx, http.MethodPost, path, params)
+}
+
+// GetAlbum возвращает альбом сессии клиента
+func (c *Client) GetAlbum(ctx context.Context, albumID string) (*Album, error) {
+	path, err := c.sessionPath(ctx, "albums", albumID)
+	if err != nil {
+		return nil, err
+	}
+
+	var album Album
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: path}, &album); err != nil {
+		return nil, err
+	}
+	return &album, nil
+}
+
+// UpdateAlbum меняет название и приватность альбома
+func (c *Client) UpdateAlbum(ctx context.Context, albumID string, params AlbumUpdate) (*Album, error) {
+	path, err := c.sessionPath(ctx, "albums", albumID)
+	if err != nil {
+		return nil, err
+	}
+	return c.albumRequest(ctx, http.MethodPatch, path, params)
+}
+
+// DeleteAlbum удаляет альбом
+func (c *Client) DeleteAlbum(ctx context.Context, albumID string) error {
+	path, err := c.sessionPath(ctx, "albums", albumID)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(ctx, request{method: http.MethodDelete, path: path}, nil)
+	return err
+}
+
+// albumRequest отправляет AlbumUpdate и разбирает альбом из ответа
+func (c *Client) albumRequest(ctx context.Context, method, path string, params AlbumUpdate) (*Album, error) {
+	req, err := jsonRequest(method, path, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var album Album
+	if _, err := c.do(ctx, req, &album); err != nil {
+		return nil, err
+	}
+	return &album, nil
+}
+
+// Изображения
+
+// ListImages возвращает страницу изображений альбома в порядке загрузки
+func (c *Client) ListImages(ctx context.Context, albumID string, page, perPage int) ([]Image, *Pagination, error) {
+	path, err := c.sessionPath(ctx, "albums", albumID, "images")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var images []Image
+	pagination, err := c.do(ctx, request{method: http.MethodGet, path: path, query: pageQuery(page, perPage)}, &images)
+	if err != nil {
+		return nil, nil, err
+	}
+	return images, pagination, nil
+}
+
+// GetImage возвращает сведения об изображении
+func (c *Client) GetImage(ctx context.Context, albumID, filename string) (*Image, error) {
+	path, err := c.sessionPath(ctx, "albums", albumID, "images", filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var image Image
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: path}, &image); err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+// RenameImage меняет исходное имя изображения, которое используется при скачивании альбома
+func (c *Client) RenameImage(ctx context.Context, albumID, filename, originalName string) (*Image, error) {
+	path, err := c.sessionPath(ctx, "albums", albumID, "images", filename)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := jsonRequest(http.MethodPatch, path, map[string]string{"original_name": originalName})
+	if err != nil {
+		return nil, err
+	}
+
+	var image Image
+	if _, err := c.do(ctx, req, &image); err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+// DeleteImage удаляет изображение
+func (c *Client) DeleteImage(ctx context.Context, albumID, filename string) error {
+	path, err := c.sessionPath(ctx, "albums", albumID, "images", filename)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(ctx, request{method: http.MethodDelete, path: path}, nil)
+	return err
+}
+
+// Токены. Управлять ими может только владелец сессии: токен, выданный CreateSession, но не выпущенные им токены.
+
+// ListTokens возвращает токены сессии
+func (c *Client) ListTokens(ctx context.Context) ([]Token, error) {
+	var list []Token
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: "/tokens"}, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// CreateToken выпускает токен; секрет есть только в возвращенном Token.Secret
+func (c *Client) CreateToken(ctx context.Context, params TokenCreate) (*Token, error) {
+	req, err := jsonRequest(http.MethodPost, "/tokens", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var token Token
+	if _, err := c.do(ctx, req, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RevokeToken отзывает токен
+func (c *Client) RevokeToken(ctx context.Context, id string) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: "/tokens/" + url.PathEscape(id)}, nil)
+	return err
+}
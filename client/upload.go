@@ -0,0 +1,93 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// ProgressFunc получает число отправленных байт файла и его размер (-1, если неизвестен)
+type ProgressFunc func(sent, total int64)
+
+// Upload загружает изображение из r в альбом. size - размер данных или -1, если он
+// неизвестен; с известным размером запрос уходит с Content-Length.
+// progress может быть nil.
+func (c *Client) Upload(ctx context.Context, albumID, name string, r io.Reader, size int64, progress ProgressFunc) (*Image, error) {
+	path, err := c.sessionPath(ctx, "albums", albumID, "images")
+	if err != nil {
+		return nil, err
+	}
+
+	// Заголовок и окончание multipart формы собираем заранее, а файл читаем потоком
+	var head, tail bytes.Buffer
+	mw := multipart.NewWriter(&head)
+	if _, err := mw.CreateFormFile("image", name); err != nil {
+		return nil, err
+	}
+	headLen := head.Len()
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	tail.Write(head.Bytes()[headLen:])
+	head.Truncate(headLen)
+
+	if progress != nil {
+		r = &progressReader{r: r, total: size, progress: progress}
+	}
+
+	req := request{
+		method:      http.MethodPost,
+		path:        path,
+		body:        io.MultiReader(&head, r, &tail),
+		contentType: mw.FormDataContentType(),
+	}
+	if size >= 0 {
+		req.length = int64(head.Len()) + size + int64(tail.Len())
+	}
+
+	var images []Image
+	if _, err := c.do(ctx, req, &images); err != nil {
+		return nil, err
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("ripx client: server returned no uploaded images")
+	}
+	return &images[0], nil
+}
+
+// UploadFile загружает файл с диска под его собственным именем
+func (c *Client) UploadFile(ctx context.Context, albumID, path string, progress ProgressFunc) (*Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return c.Upload(ctx, albumID, filepath.Base(path), file, stat.Size(), progress)
+}
+
+// progressReader сообщает о прочитанных байтах
+type progressReader struct {
+	r        io.Reader
+	sent     int64
+	total    int64
+	progress ProgressFunc
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.sent += int64(n)
+		p.progress(p.sent, p.total)
+	}
+	return n, err
+}